@@ -137,8 +137,77 @@ const (
 	MetricJSONPath MetricType = "jsonpath"
 	// MetricNewRelic metrics issue queries to the New Relic service. Requires API and application key configuration.
 	MetricNewRelic MetricType = "newrelic"
+	// MetricInfluxDB metrics issue Flux or InfluxQL queries to an InfluxDB server. Queries are bounded by the trial run.
+	MetricInfluxDB MetricType = "influxdb"
+	// MetricPodMetrics metrics periodically sample the resource usage of the target pods from the "metrics.k8s.io" API
+	// during the trial run. Queries are resource names (e.g. "cpu" or "memory").
+	MetricPodMetrics MetricType = "podmetrics"
+	// MetricDerived metrics are computed from the other metric values of the trial. Queries are arithmetic expressions
+	// using metric names, e.g. "cost / requests * 1000".
+	MetricDerived MetricType = "derived"
+	// MetricPush metrics read values POSTed by the trial job to the controller during the trial run. Queries are the
+	// names of the pushed values.
+	MetricPush MetricType = "push"
+	// MetricJobOutput metrics parse the termination message, or the tail of the logs, of the trial job containers.
+	// Queries are regular expressions (the first capture group of the last match is used) or JSON path expressions.
+	MetricJobOutput MetricType = "joboutput"
+	// MetricElasticsearch metrics run an aggregation query against an Elasticsearch (or OpenSearch) index. Queries are
+	// search request bodies, the "path" query parameter on the URL identifies the aggregation value.
+	MetricElasticsearch MetricType = "elasticsearch"
+	// MetricSQL metrics run a query against a Postgres or MySQL database, the data source name is read from the
+	// "SQL_DSN" secret key. Queries must return a single numeric cell.
+	MetricSQL MetricType = "sql"
+	// MetricLoki metrics issue LogQL metric queries to a Loki server. Queries MUST evaluate to a scalar value.
+	MetricLoki MetricType = "loki"
 )
 
+// MetricRange configures a metric query to be evaluated over the duration of the trial run
+type MetricRange struct {
+	// The query resolution step width, defaults to 5 seconds
+	Step *metav1.Duration `json:"step,omitempty"`
+	// The aggregation used to reduce the range to a single value, one of: avg|max|peak|min|last|sum|p<N> (e.g. "p95"),
+	// default: avg. The standard deviation of the range is used as the error unless an error query is specified.
+	Aggregation string `json:"aggregation,omitempty"`
+}
+
+// MetricTLSConfig configures TLS connections to remote metric sources. Client certificates are read from the metric
+// secret using the "tls.crt" and "tls.key" keys.
+type MetricTLSConfig struct {
+	// ServerName is used to verify the host name of the remote metric source
+	ServerName string `json:"serverName,omitempty"`
+	// InsecureSkipVerify disables verification of the remote metric source's certificate chain and host name
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// UseSecretCA verifies the remote metric source using the "ca.crt" key of the metric secret instead of the system
+	// roots. Note that service account token secrets contain the cluster certificate authority.
+	UseSecretCA bool `json:"useSecretCA,omitempty"`
+}
+
+// MetricHTTPRequest customizes the HTTP request used to query remote metric sources
+type MetricHTTPRequest struct {
+	// Method is the HTTP request method, one of: GET|POST, default: GET (or POST when a body is specified)
+	Method string `json:"method,omitempty"`
+	// Headers are additional HTTP request headers, values are Go templates rendered using the trial
+	Headers []MetricHTTPHeader `json:"headers,omitempty"`
+	// Body is the HTTP request body, a Go template rendered using the trial
+	Body string `json:"body,omitempty"`
+}
+
+// MetricHTTPHeader is an HTTP header included with metric requests
+type MetricHTTPHeader struct {
+	// The name of the header
+	Name string `json:"name"`
+	// The value of the header
+	Value string `json:"value,omitempty"`
+}
+
+// ScrapeTargetSelector matches the Prometheus scrape targets that must be ready before a metric is captured
+type ScrapeTargetSelector struct {
+	// Job restricts the readiness check to scrape targets with a matching "job" label
+	Job string `json:"job,omitempty"`
+	// LabelSelector matches the labels of the scrape targets
+	*metav1.LabelSelector `json:",inline"`
+}
+
 // Metric represents an observable outcome from a trial run
 type Metric struct {
 	// The name of the metric
@@ -152,15 +221,42 @@ type Metric struct {
 	// Indicator that this metric should be optimized (default: true)
 	Optimize *bool `json:"optimize,omitempty"`
 
-	// The metric collection type, one of: kubernetes|prometheus|datadog|jsonpath, default: kubernetes
+	// The metric collection type, one of: kubernetes|prometheus|datadog|jsonpath|newrelic|influxdb|podmetrics|derived|
+	// push|joboutput|elasticsearch|sql|loki (or any additional type registered with the controller), default: kubernetes
 	Type MetricType `json:"type,omitempty"`
 	// Collection type specific query, e.g. Go template for "kubernetes", PromQL for "prometheus" or a JSON pointer expression (with curly braces) for "jsonpath"
 	Query string `json:"query"`
 	// Collection type specific query for the error associated with collected metric value
 	ErrorQuery string `json:"errorQuery,omitempty"`
-
-	// URL to use when querying remote metric sources.
+	// Range evaluates the query over the duration of the trial run instead of at the completion time. Currently only
+	// supported for "prometheus", "loki" and "podmetrics" metrics (where the step is the sampling interval).
+	Range *MetricRange `json:"range,omitempty"`
+	// Samples splits the trial run into the specified number of equal intervals and captures the metric once for each
+	// interval. The reported value is the mean of the samples and the error is the standard error of the mean. Sampling
+	// is supported for "prometheus", "datadog", "newrelic", "influxdb" and "loki" metrics; "jsonpath", "elasticsearch"
+	// and "sql" metrics can only be sampled if their templates use the trial start or completion time.
+	Samples int32 `json:"samples,omitempty"`
+
+	// URL to use when querying remote metric sources. For "datadog", the scheme and host select the Datadog site (e.g.
+	// "https://api.datadoghq.eu") and the "aggregator" query parameter selects the aggregation; for "newrelic", the
+	// "region" query parameter selects the New Relic region (e.g. "?region=EU").
 	URL string `json:"url,omitempty"`
+	// SecretRef is a reference to a secret in the trial namespace containing the credentials used when querying remote
+	// metric sources, e.g. "DATADOG_API_KEY" and "DATADOG_APP_KEY" for "datadog", "NEW_RELIC_API_KEY" and
+	// "NEW_RELIC_ACCOUNT_ID" for "newrelic", "INFLUXDB_TOKEN" for "influxdb", "ELASTICSEARCH_API_KEY" for
+	// "elasticsearch", "SQL_DSN" for "sql" or "LOKI_TENANT_ID" for "loki". If not specified, credentials are read from
+	// the controller's environment.
+	// For "prometheus", "jsonpath" and "loki", the secret may contain a "token" (e.g. a service account token secret),
+	// a "username" and "password" (e.g. a basic authentication secret) and TLS certificates.
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+	// TLS configures connections to remote metric sources using HTTPS. Currently only supported by "prometheus",
+	// "jsonpath", "influxdb", "elasticsearch" and "loki".
+	TLS *MetricTLSConfig `json:"tls,omitempty"`
+	// HTTP customizes the request used to query remote metric sources. Currently only supported by "jsonpath".
+	HTTP *MetricHTTPRequest `json:"http,omitempty"`
+	// ScrapeTargets restricts the Prometheus scrape targets that must be healthy and have completed a scrape after
+	// the trial run before the metric is captured, by default all active scrape targets are checked.
+	ScrapeTargets *ScrapeTargetSelector `json:"scrapeTargets,omitempty"`
 	// Target reference of the Kubernetes object to query for metric information.
 	Target *ResourceTarget `json:"target,omitempty"`
 }
@@ -169,6 +265,8 @@ type Metric struct {
 type PatchReadinessGate struct {
 	// ConditionType refers to a condition in the patched target's condition list
 	ConditionType string `json:"conditionType"`
+	// HTTPGet configures the "stormforge.io/http-get" condition type
+	HTTPGet *HTTPGetCheck `json:"httpGet,omitempty"`
 }
 
 // PatchType represents the allowable types of patches
@@ -181,14 +279,19 @@ const (
 	PatchMerge PatchType = "merge"
 	// PatchJSON is the patch type for aJSON patch (RFC 6902)
 	PatchJSON PatchType = "json"
+	// PatchApply is the patch type for a server-side apply configuration
+	PatchApply PatchType = "apply"
 )
 
 // PatchTemplate defines a target resource and a patch template to apply
 type PatchTemplate struct {
-	// The patch type, one of: strategic|merge|json, default: strategic
+	// The patch type, one of: strategic|merge|json|apply, default: strategic
 	Type PatchType `json:"type,omitempty"`
 	// Direct reference to the object the patch should be applied to
 	TargetRef *corev1.ObjectReference `json:"targetRef,omitempty"`
+	// Selector matches the objects the patch should be applied to, the target reference must include the kind of the
+	// matched objects and may not include a name; the namespace defaults to the trial namespace
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
 	// A Go Template that evaluates to valid patch
 	Patch string `json:"patch"`
 	// ReadinessGates will be evaluated for patch target readiness. A patch target is ready if all conditions specified
@@ -197,8 +300,38 @@ type PatchTemplate struct {
 	// is not allowed for a ConfigMap. Condition types starting with "stormforge.io/" may not appear in the patched
 	// target's condition list, but are still evaluated against the resource's state.
 	ReadinessGates []PatchReadinessGate `json:"readinessGates,omitempty"`
+	// Wave orders the application of patches, patches are only applied once the readiness checks of all patches in
+	// lower waves have passed. Patches in the same wave are applied together, default: 0
+	Wave int32 `json:"wave,omitempty"`
 }
 
+// RestorePolicy represents when patched objects should be returned to their original state
+type RestorePolicy string
+
+const (
+	// RestoreNever leaves patched objects in the state of the last trial
+	RestoreNever RestorePolicy = "never"
+	// RestoreExperiment restores patched objects when the experiment completes or is deleted
+	RestoreExperiment RestorePolicy = "experiment"
+	// RestoreTrial restores patched objects after every trial (and therefore also when the experiment completes)
+	RestoreTrial RestorePolicy = "trial"
+)
+
+// DriftPolicy represents what happens when a patched object no longer matches the patch while the trial is running
+type DriftPolicy string
+
+const (
+	// DriftIgnore does not check patched objects for drift
+	DriftIgnore DriftPolicy = "ignore"
+	// DriftRecord records drift in the trial status without taking any action
+	DriftRecord DriftPolicy = "record"
+	// DriftReapply records drift in the trial status and applies the patch again; apply patches force ownership of
+	// the drifted fields and JSON patches only restore the drifted fields
+	DriftReapply DriftPolicy = "reapply"
+	// DriftFail records drift in the trial status and fails the trial
+	DriftFail DriftPolicy = "fail"
+)
+
 // NamespaceTemplateSpec is used as a template for creating new namespaces
 type NamespaceTemplateSpec struct {
 	// Standard object metadata
@@ -256,6 +389,12 @@ type ExperimentSpec struct {
 	// Patches is a sequence of templates written against the experiment parameters that will be used to put the
 	// cluster into the desired state
 	Patches []PatchTemplate `json:"patches,omitempty"`
+	// RestorePolicy determines when the original state of patched objects is restored, one of:
+	// never|experiment|trial, default: never; patched secrets are never restored
+	RestorePolicy RestorePolicy `json:"restorePolicy,omitempty"`
+	// DriftPolicy determines what happens when a patched object changes while the trial is running, one of:
+	// ignore|record|reapply|fail, default: ignore
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
 	// NamespaceSelector is used to locate existing namespaces for trials
 	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
 	// NamespaceTemplate can be specified to create new namespaces for trials; if specified created namespaces must be
@@ -48,7 +48,7 @@ type TrialReadinessGate struct {
 	// ConditionTypes are the status conditions that must be "True"
 	ConditionTypes []string `json:"conditionTypes,omitempty"`
 	// InitialDelaySeconds is the approximate number of seconds after all of the patches have been applied to start
-	// evaluating this check
+	// evaluating this check; checks which hold back a later wave of patches start after the patches of their wave
 	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
 	// PeriodSeconds is the approximate amount of time in between evaluation attempts of this check;
 	// defaults to 10 seconds, minimum value is 1 second
@@ -56,6 +56,18 @@ type TrialReadinessGate struct {
 	// FailureThreshold is number of times that any of the specified ready conditions may be "False";
 	// defaults to 3, minimum value is 1
 	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+	// HTTPGet configures the "stormforge.io/http-get" condition type
+	HTTPGet *HTTPGetCheck `json:"httpGet,omitempty"`
+}
+
+// HTTPGetCheck is an HTTP request made by the controller to determine if the readiness target is responding
+type HTTPGetCheck struct {
+	// URL is a Go template rendered using the readiness target, e.g. "http://{{ .metadata.name }}:8080/healthz"
+	URL string `json:"url"`
+	// ExpectedStatusCodes are the response status codes indicating the target is ready, default: any 2xx code
+	ExpectedStatusCodes []int32 `json:"expectedStatusCodes,omitempty"`
+	// SuccessThreshold is the number of consecutive successful requests required, default: 1
+	SuccessThreshold int32 `json:"successThreshold,omitempty"`
 }
 
 // HelmValue represents a value in a Helm template
@@ -143,6 +155,11 @@ type PatchOperation struct {
 	// The number of remaining attempts to apply the patch, will be automatically set
 	// to zero if the patch is successfully applied
 	AttemptsRemaining int `json:"attemptsRemaining,omitempty"`
+	// The wave of the patch template, the patch is not applied until the readiness checks of all lower waves pass
+	Wave int32 `json:"wave,omitempty"`
+	// The time the patch was successfully applied, the initial delay of the readiness checks in the same wave is
+	// measured from the latest applied time of the wave
+	AppliedTime *metav1.Time `json:"appliedTime,omitempty"`
 }
 
 // ReadinessCheck represents a check to determine when the patched application is "ready" and it is
@@ -157,7 +174,7 @@ type ReadinessCheck struct {
 	// status of the target object, additional special conditions starting with "stormforge.io/" can be tested
 	ConditionTypes []string `json:"conditionTypes,omitempty"`
 	// InitialDelaySeconds is the approximate number of seconds after all of the patches have been applied to start
-	// evaluating this check
+	// evaluating this check; checks which hold back a later wave of patches start after the patches of their wave
 	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
 	// PeriodSeconds is the approximate amount of time in between evaluation attempts of this check
 	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
@@ -166,6 +183,27 @@ type ReadinessCheck struct {
 	AttemptsRemaining int32 `json:"attemptsRemaining,omitempty"`
 	// LastCheckTime is the timestamp of the last evaluation attempt
 	LastCheckTime *metav1.Time `json:"lastCheckTime,omitempty"`
+	// Wave is the wave of the patch this check was created for, checks must pass before patches in higher waves
+	// are applied
+	Wave int32 `json:"wave,omitempty"`
+	// HTTPGet configures the "stormforge.io/http-get" condition type
+	HTTPGet *HTTPGetCheck `json:"httpGet,omitempty"`
+	// Successes is the number of consecutive evaluations where the targets were ready, only tracked when the
+	// HTTP check requires more than one success
+	Successes int32 `json:"successes,omitempty"`
+}
+
+// PatchDrift represents changes to a patched object that were detected while the trial was running
+type PatchDrift struct {
+	// The reference to the object that no longer matched the patch
+	TargetRef corev1.ObjectReference `json:"targetRef"`
+	// The number of times the object was found to no longer match the patch, the same change is only counted again
+	// after several minutes
+	Count int32 `json:"count"`
+	// The time at which the object was last found to no longer match the patch
+	LastDetectionTime metav1.Time `json:"lastDetectionTime"`
+	// A human readable description of the last detected change
+	Message string `json:"message,omitempty"`
 }
 
 // Value represents an observed metric value after a trial run has completed successfully. Value names
@@ -200,6 +238,8 @@ const (
 	TrialReady TrialConditionType = "stormforge.io/trial-ready"
 	// TrialObserved is a condition that indicates a trial has had metrics collected
 	TrialObserved TrialConditionType = "stormforge.io/trial-observed"
+	// TrialRestored is a condition that indicates patched objects have been restored to their original state
+	TrialRestored TrialConditionType = "stormforge.io/trial-restored"
 )
 
 // TrialCondition represents an observed condition of a trial
@@ -275,6 +315,8 @@ type TrialStatus struct {
 	PatchOperations []PatchOperation `json:"patchOperations,omitempty"`
 	// ReadinessChecks are the all of the objects whose conditions need to be inspected for this trial
 	ReadinessChecks []ReadinessCheck `json:"readinessChecks,omitempty"`
+	// PatchDrift records the patched objects which changed while the trial was running
+	PatchDrift []PatchDrift `json:"patchDrift,omitempty"`
 }
 
 // +genclient
@@ -38,6 +38,21 @@ const (
 	// AnnotationInitializer is a comma-delimited list of initializing processes. Similar to a "finalizer", the trial
 	// will not start executing until the initializer is empty.
 	AnnotationInitializer = "stormforge.io/initializer"
+	// AnnotationMetricSamples is a JSON representation of a bounded summary of the metric values sampled during the
+	// trial run. The annotation is added to new trials of experiments with sampled metrics, trials without it are not sampled.
+	AnnotationMetricSamples = "stormforge.io/metric-samples"
+	// AnnotationPushedMetrics is a JSON representation of the metric values pushed by the trial job.
+	AnnotationPushedMetrics = "stormforge.io/pushed-metrics"
+	// AnnotationMetricsURL is the URL the trial job uses to push metric values to the controller.
+	AnnotationMetricsURL = "stormforge.io/metrics-url"
+	// AnnotationMetricsSecret is the name of the secret holding the bearer token the trial job uses to authenticate
+	// pushed metric values. The annotation is added with an empty value to new trials of experiments with push
+	// metrics, it is filled in when the secret is created before the trial job.
+	AnnotationMetricsSecret = "stormforge.io/metrics-secret"
+	// AnnotationJobOutput is the termination message, or the tail of the logs, of the main trial job container. The
+	// annotation is added with an empty value to new trials of experiments which parse the output, it is filled in
+	// once the trial job is complete or removed if the job did not produce any output.
+	AnnotationJobOutput = "stormforge.io/job-output"
 
 	// LabelTrial contains the name of the trial associated with an object
 	LabelTrial = "stormforge.io/trial"
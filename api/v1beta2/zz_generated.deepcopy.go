@@ -254,6 +254,26 @@ func (in *ExperimentStatus) DeepCopy() *ExperimentStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPGetCheck) DeepCopyInto(out *HTTPGetCheck) {
+	*out = *in
+	if in.ExpectedStatusCodes != nil {
+		in, out := &in.ExpectedStatusCodes, &out.ExpectedStatusCodes
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPGetCheck.
+func (in *HTTPGetCheck) DeepCopy() *HTTPGetCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPGetCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HelmValue) DeepCopyInto(out *HelmValue) {
 	*out = *in
@@ -333,6 +353,31 @@ func (in *Metric) DeepCopyInto(out *Metric) {
 		*out = new(bool)
 		**out = **in
 	}
+	if in.Range != nil {
+		in, out := &in.Range, &out.Range
+		*out = new(MetricRange)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(MetricTLSConfig)
+		**out = **in
+	}
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(MetricHTTPRequest)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ScrapeTargets != nil {
+		in, out := &in.ScrapeTargets, &out.ScrapeTargets
+		*out = new(ScrapeTargetSelector)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Target != nil {
 		in, out := &in.Target, &out.Target
 		*out = new(ResourceTarget)
@@ -350,6 +395,76 @@ func (in *Metric) DeepCopy() *Metric {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricHTTPHeader) DeepCopyInto(out *MetricHTTPHeader) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricHTTPHeader.
+func (in *MetricHTTPHeader) DeepCopy() *MetricHTTPHeader {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricHTTPHeader)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricHTTPRequest) DeepCopyInto(out *MetricHTTPRequest) {
+	*out = *in
+	if in.Headers != nil {
+		in, out := &in.Headers, &out.Headers
+		*out = make([]MetricHTTPHeader, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricHTTPRequest.
+func (in *MetricHTTPRequest) DeepCopy() *MetricHTTPRequest {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricHTTPRequest)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricRange) DeepCopyInto(out *MetricRange) {
+	*out = *in
+	if in.Step != nil {
+		in, out := &in.Step, &out.Step
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricRange.
+func (in *MetricRange) DeepCopy() *MetricRange {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricRange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricTLSConfig) DeepCopyInto(out *MetricTLSConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricTLSConfig.
+func (in *MetricTLSConfig) DeepCopy() *MetricTLSConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricTLSConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NamespaceTemplateSpec) DeepCopyInto(out *NamespaceTemplateSpec) {
 	*out = *in
@@ -437,6 +552,23 @@ func (in *ParameterSelector) DeepCopy() *ParameterSelector {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PatchDrift) DeepCopyInto(out *PatchDrift) {
+	*out = *in
+	out.TargetRef = in.TargetRef
+	in.LastDetectionTime.DeepCopyInto(&out.LastDetectionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PatchDrift.
+func (in *PatchDrift) DeepCopy() *PatchDrift {
+	if in == nil {
+		return nil
+	}
+	out := new(PatchDrift)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PatchOperation) DeepCopyInto(out *PatchOperation) {
 	*out = *in
@@ -446,6 +578,10 @@ func (in *PatchOperation) DeepCopyInto(out *PatchOperation) {
 		*out = make([]byte, len(*in))
 		copy(*out, *in)
 	}
+	if in.AppliedTime != nil {
+		in, out := &in.AppliedTime, &out.AppliedTime
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PatchOperation.
@@ -461,6 +597,11 @@ func (in *PatchOperation) DeepCopy() *PatchOperation {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PatchReadinessGate) DeepCopyInto(out *PatchReadinessGate) {
 	*out = *in
+	if in.HTTPGet != nil {
+		in, out := &in.HTTPGet, &out.HTTPGet
+		*out = new(HTTPGetCheck)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PatchReadinessGate.
@@ -481,10 +622,17 @@ func (in *PatchTemplate) DeepCopyInto(out *PatchTemplate) {
 		*out = new(corev1.ObjectReference)
 		**out = **in
 	}
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.ReadinessGates != nil {
 		in, out := &in.ReadinessGates, &out.ReadinessGates
 		*out = make([]PatchReadinessGate, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 }
 
@@ -516,6 +664,11 @@ func (in *ReadinessCheck) DeepCopyInto(out *ReadinessCheck) {
 		in, out := &in.LastCheckTime, &out.LastCheckTime
 		*out = (*in).DeepCopy()
 	}
+	if in.HTTPGet != nil {
+		in, out := &in.HTTPGet, &out.HTTPGet
+		*out = new(HTTPGetCheck)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReadinessCheck.
@@ -548,6 +701,26 @@ func (in *ResourceTarget) DeepCopy() *ResourceTarget {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScrapeTargetSelector) DeepCopyInto(out *ScrapeTargetSelector) {
+	*out = *in
+	if in.LabelSelector != nil {
+		in, out := &in.LabelSelector, &out.LabelSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrapeTargetSelector.
+func (in *ScrapeTargetSelector) DeepCopy() *ScrapeTargetSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(ScrapeTargetSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SetupTask) DeepCopyInto(out *SetupTask) {
 	*out = *in
@@ -736,6 +909,11 @@ func (in *TrialReadinessGate) DeepCopyInto(out *TrialReadinessGate) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.HTTPGet != nil {
+		in, out := &in.HTTPGet, &out.HTTPGet
+		*out = new(HTTPGetCheck)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrialReadinessGate.
@@ -868,6 +1046,13 @@ func (in *TrialStatus) DeepCopyInto(out *TrialStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.PatchDrift != nil {
+		in, out := &in.PatchDrift, &out.PatchDrift
+		*out = make([]PatchDrift, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrialStatus.
@@ -31,6 +31,9 @@ import (
 	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
 	"github.com/thestormforge/optimize-controller/v2/cli/internal/commander"
 	"github.com/thestormforge/optimize-controller/v2/internal/experiment"
+	"github.com/thestormforge/optimize-controller/v2/internal/metric"
+	"github.com/thestormforge/optimize-controller/v2/internal/patch"
+	"github.com/thestormforge/optimize-controller/v2/internal/ready"
 	"github.com/thestormforge/optimize-controller/v2/internal/template"
 	"github.com/thestormforge/optimize-controller/v2/internal/validation"
 	"go.uber.org/zap"
@@ -147,6 +150,31 @@ func (l *linter) Visit(ctx context.Context, obj interface{}) experiment.Visitor
 			}
 		}
 
+	case *optimizev1beta2.ExperimentSpec:
+		switch o.RestorePolicy {
+		case "", optimizev1beta2.RestoreNever, optimizev1beta2.RestoreExperiment:
+		case optimizev1beta2.RestoreTrial:
+			if o.Replicas != nil && *o.Replicas > 1 {
+				lint.V(vWarn).Info("Restoring patched objects between trials may interfere with concurrent trials", "replicas", *o.Replicas)
+			}
+		default:
+			lint.V(vError).Info("Restore policy must be one of: never, experiment, trial", "restorePolicy", o.RestorePolicy)
+		}
+
+		if o.RestorePolicy == optimizev1beta2.RestoreExperiment || o.RestorePolicy == optimizev1beta2.RestoreTrial {
+			for i := range o.Patches {
+				if ref := o.Patches[i].TargetRef; ref != nil && !patch.IsSnapshotSupported(ref) {
+					lint.V(vWarn).Info("Patched secrets are not restored", "name", ref.Name)
+				}
+			}
+		}
+
+		switch o.DriftPolicy {
+		case "", optimizev1beta2.DriftIgnore, optimizev1beta2.DriftRecord, optimizev1beta2.DriftReapply, optimizev1beta2.DriftFail:
+		default:
+			lint.V(vError).Info("Drift policy must be one of: ignore, record, reapply, fail", "driftPolicy", o.DriftPolicy)
+		}
+
 	case *optimizev1beta2.Optimization:
 		switch o.Name {
 		case "experimentBudget":
@@ -167,6 +195,8 @@ func (l *linter) Visit(ctx context.Context, obj interface{}) experiment.Visitor
 	case []optimizev1beta2.Metric:
 		if len(o) == 0 {
 			lint.V(vError).Info("Metrics are required")
+		} else if err := metric.CheckDependencies(o); err != nil {
+			lint.Error(err, "Metric dependencies cannot be resolved")
 		}
 
 	case *optimizev1beta2.Parameter:
@@ -180,16 +210,8 @@ func (l *linter) Visit(ctx context.Context, obj interface{}) experiment.Visitor
 		}
 
 	case *optimizev1beta2.Metric:
-		switch o.Type {
-		case
-			optimizev1beta2.MetricKubernetes,
-			optimizev1beta2.MetricPrometheus,
-			optimizev1beta2.MetricJSONPath,
-			optimizev1beta2.MetricDatadog,
-			optimizev1beta2.MetricNewRelic,
-			"": // Type is valid
-		default:
-			lint.V(vError).Info("Metric type is invalid", "type", o.Type)
+		if _, ok := metric.Lookup(o.Type); !ok {
+			lint.V(vError).Info("Metric type is invalid", "type", o.Type, "expected", metric.Types())
 		}
 
 		if o.Query == "" {
@@ -198,6 +220,14 @@ func (l *linter) Visit(ctx context.Context, obj interface{}) experiment.Visitor
 			checkQuery(lint, o)
 		}
 
+		if o.SecretRef != nil && o.SecretRef.Name == "" {
+			lint.V(vError).Info("Metric secret name is required")
+		}
+
+		if o.Samples < 0 {
+			lint.V(vError).Info("Metric samples must not be negative")
+		}
+
 		if o.Min != nil && o.Max != nil && o.Min.Cmp(*o.Max) <= 0 {
 			lint.V(vError).Info("Metric minimum must be strictly less then maximum")
 		}
@@ -209,6 +239,14 @@ func (l *linter) Visit(ctx context.Context, obj interface{}) experiment.Visitor
 		}
 
 	case *optimizev1beta2.PatchTemplate:
+		if o.Selector != nil {
+			if o.TargetRef == nil || o.TargetRef.Kind == "" {
+				lint.V(vError).Info("Patch selector requires a target kind")
+			} else if o.TargetRef.Name != "" {
+				lint.V(vError).Info("Patch target name and selector are mutually exclusive")
+			}
+		}
+
 		if o.TargetRef != nil {
 			if o.TargetRef.Kind == "" {
 				// TODO Is kind required? Can you just have the namespace and the rest of the ref in the patch?
@@ -223,6 +261,19 @@ func (l *linter) Visit(ctx context.Context, obj interface{}) experiment.Visitor
 			}
 		}
 
+		var httpGets int
+		for _, rg := range o.ReadinessGates {
+			if rg.ConditionType == ready.ConditionTypeHTTPGet && (rg.HTTPGet == nil || rg.HTTPGet.URL == "") {
+				lint.V(vError).Info("HTTP readiness gate requires a URL", "conditionType", rg.ConditionType)
+			}
+			if rg.HTTPGet != nil {
+				httpGets++
+			}
+		}
+		if httpGets > 1 {
+			lint.V(vError).Info("Patch readiness gates only support a single HTTP GET configuration", "count", httpGets)
+		}
+
 		if ok, _ := regexp.MatchString(`(?m) +$`, o.Patch); ok {
 			lint.V(vWarn).Info("Patch lines contains trailing space which may cause formatting issues")
 		}
@@ -230,6 +281,15 @@ func (l *linter) Visit(ctx context.Context, obj interface{}) experiment.Visitor
 			lint.Error(err, "Patch is not valid")
 		}
 
+	case *optimizev1beta2.TrialTemplateSpec:
+		for _, rg := range o.Spec.ReadinessGates {
+			for _, c := range rg.ConditionTypes {
+				if c == ready.ConditionTypeHTTPGet && (rg.HTTPGet == nil || rg.HTTPGet.URL == "") {
+					lint.V(vError).Info("HTTP readiness gate requires a URL", "conditionType", c)
+				}
+			}
+		}
+
 	case *batchv1beta1.JobTemplateSpec:
 		if o.Spec.BackoffLimit != nil && *o.Spec.BackoffLimit != 0 {
 			lint.V(vWarn).Info("Job backoffLimit should be 0", "backoffLimit", *o.Spec.BackoffLimit)
@@ -290,19 +350,30 @@ func checkQuery(lint logr.Logger, m *optimizev1beta2.Metric) {
 		target.SetGroupVersionKind(optimizev1beta2.GroupVersion.WithKind("Trial"))
 	}
 
-	q, _, err := template.New().RenderMetricQueries(m, &optimizev1beta2.Trial{}, target)
+	te := template.New()
+	q, eq, err := te.RenderMetricQueries(m, &optimizev1beta2.Trial{}, target)
 	if err != nil {
 		lint.Error(err, "Metric query failed to render", "query", m.Query)
 	}
+	req, err := te.RenderMetricRequest(m, &optimizev1beta2.Trial{}, target)
+	if err != nil {
+		lint.Error(err, "Metric HTTP request failed to render")
+		req = m.HTTP
+	}
 
-	switch m.Type {
-	case optimizev1beta2.MetricJSONPath:
-		if !strings.Contains(q, "{") {
-			lint.V(vWarn).Info("JSON Path query should contain an {} expression", "query", m.Query)
-		}
-	case optimizev1beta2.MetricPrometheus:
-		if !strings.Contains(q, "scalar") {
-			lint.V(vWarn).Info("Prometheus query may require explicit scalar conversion", "query", m.Query)
+	// Let the provider validate the rendered metric
+	p, ok := metric.Lookup(m.Type)
+	if !ok {
+		return
+	}
+
+	rm := m.DeepCopy()
+	rm.Query, rm.ErrorQuery, rm.HTTP = q, eq, req
+	for _, err := range p.Validate(rm) {
+		if _, ok := err.(metric.Warning); ok {
+			lint.V(vWarn).Info(err.Error(), "query", m.Query)
+		} else {
+			lint.Error(err, "Metric is not valid", "query", m.Query)
 		}
 	}
 }
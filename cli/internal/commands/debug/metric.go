@@ -17,7 +17,9 @@ limitations under the License.
 package debug
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"regexp"
 	"strconv"
 	"strings"
@@ -27,10 +29,17 @@ import (
 	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
 	"github.com/thestormforge/optimize-controller/v2/cli/internal/commander"
 	"github.com/thestormforge/optimize-controller/v2/internal/experiment"
+	"github.com/thestormforge/optimize-controller/v2/internal/metric"
 	"github.com/thestormforge/optimize-controller/v2/internal/template"
 	"github.com/thestormforge/optimize-go/pkg/config"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/kustomize/kyaml/kio"
 	"sigs.k8s.io/kustomize/kyaml/yaml"
 )
@@ -41,11 +50,13 @@ type MetricQueryOptions struct {
 	commander.IOStreams
 
 	Filename       string
+	Objects        string
 	TrialName      string
 	MetricName     string
 	StartTime      string
 	Duration       string
 	CompletionTime string
+	Connect        bool
 }
 
 // NewMetricQueryCommand create
@@ -59,13 +70,16 @@ func NewMetricQueryCommand(o *MetricQueryOptions) *cobra.Command {
 	}
 
 	cmd.Flags().StringVarP(&o.Filename, "filename", "f", "", "`file` containing the experiment definition")
+	cmd.Flags().StringVar(&o.Objects, "objects", "", "`file` containing the objects visible to template lookups")
 	cmd.Flags().StringVar(&o.TrialName, "trial", "", "trial `name` to use")
 	cmd.Flags().StringVar(&o.MetricName, "metric", "", "metric `name` to print or empty for all metrics")
 	cmd.Flags().StringVar(&o.StartTime, "start", "", "trial start `time`")
 	cmd.Flags().StringVar(&o.Duration, "duration", "", "trial `duration` (instead of completion)")
 	cmd.Flags().StringVar(&o.CompletionTime, "completion", "", "trial end `time`")
+	cmd.Flags().BoolVar(&o.Connect, "connect", false, "verify Prometheus connections using secrets from the objects file")
 
 	_ = cmd.MarkFlagFilename("filename", "yml", "yaml")
+	_ = cmd.MarkFlagFilename("objects", "yml", "yaml")
 	_ = cmd.MarkFlagRequired("filename")
 
 	return cmd
@@ -106,7 +120,14 @@ func (o *MetricQueryOptions) Debug() error {
 		return err
 	}
 
-	eng := template.New()
+	// Template lookups only see the objects we were given, never the cluster
+	c, err := o.lookupClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	eng := template.New().WithLookup(ctx, c)
 	for i := range exp.Spec.Metrics {
 		m := &exp.Spec.Metrics[i]
 		if o.MetricName != "" && m.Name != o.MetricName {
@@ -132,6 +153,12 @@ func (o *MetricQueryOptions) Debug() error {
 			continue
 		}
 
+		// Record how Prometheus will be accessed, the test itself does not connect
+		pt.addConnectionComment(m)
+		if o.Connect {
+			pt.addConnectionResultComment(m, o.checkConnection(ctx, c, t, m))
+		}
+
 		// Add the PromQL to the test
 		if err := pt.addPromqlExprTest(t, m, q); err != nil {
 			return err
@@ -142,6 +169,63 @@ func (o *MetricQueryOptions) Debug() error {
 	return pt.writeTo(o.YAMLWriter())
 }
 
+// lookupClient returns a fake client populated with the objects from the objects file.
+func (o *MetricQueryOptions) lookupClient() (client.Reader, error) {
+	var objs []runtime.Object
+	if o.Objects != "" {
+		r, err := o.IOStreams.OpenFile(o.Objects)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		d := k8syaml.NewYAMLOrJSONDecoder(r, 4096)
+		for {
+			u := &unstructured.Unstructured{}
+			if err := d.Decode(&u.Object); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if len(u.Object) == 0 {
+				continue
+			}
+
+			// Prefer typed objects so the fake client can list them
+			obj, err := scheme.Scheme.New(u.GroupVersionKind())
+			if err != nil {
+				objs = append(objs, u)
+				continue
+			}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, obj); err != nil {
+				return nil, err
+			}
+			objs = append(objs, obj)
+		}
+	}
+
+	return fake.NewFakeClientWithScheme(scheme.Scheme, objs...), nil
+}
+
+// checkConnection connects to the Prometheus server of the metric using the authentication and TLS configuration
+// of the metric, secrets are read from the objects file.
+func (o *MetricQueryOptions) checkConnection(ctx context.Context, r client.Reader, t *optimizev1beta2.Trial, m *optimizev1beta2.Metric) error {
+	m = m.DeepCopy()
+	if err := metric.ApplyDefaults(t, m); err != nil {
+		return err
+	}
+
+	var secret *corev1.Secret
+	if m.SecretRef != nil {
+		secret = &corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: t.Namespace, Name: m.SecretRef.Name}, secret); err != nil {
+			return err
+		}
+	}
+
+	return metric.CheckPrometheusConnection(ctx, m, secret)
+}
+
 func (o *MetricQueryOptions) populateTrialTime(exp *optimizev1beta2.Experiment, t *optimizev1beta2.Trial) error {
 	startTime, err := parseTime(o.StartTime, time.Now())
 	if err != nil {
@@ -312,6 +396,36 @@ func (p *promTest) addQueryComment(m *optimizev1beta2.Metric, q string) {
 	}
 }
 
+func (p *promTest) addConnectionComment(m *optimizev1beta2.Metric) {
+	var opts []string
+	if m.URL != "" {
+		opts = append(opts, "url="+m.URL)
+	}
+	if m.SecretRef != nil {
+		opts = append(opts, "secret="+m.SecretRef.Name)
+	}
+	if m.TLS != nil && m.TLS.ServerName != "" {
+		opts = append(opts, "tlsServerName="+m.TLS.ServerName)
+	}
+	if m.TLS != nil && m.TLS.InsecureSkipVerify {
+		opts = append(opts, "tlsInsecureSkipVerify=true")
+	}
+	if m.TLS != nil && m.TLS.UseSecretCA {
+		opts = append(opts, "tlsUseSecretCA=true")
+	}
+	if len(opts) > 0 {
+		p.addHeadComment("%s: %s", m.Name, strings.Join(opts, " "))
+	}
+}
+
+func (p *promTest) addConnectionResultComment(m *optimizev1beta2.Metric, err error) {
+	if err != nil {
+		p.addHeadComment("%s: Connection failed: %s", m.Name, err.Error())
+		return
+	}
+	p.addHeadComment("%s: Connection succeeded", m.Name)
+}
+
 func (p *promTest) addErrorComment(m *optimizev1beta2.Metric, err error) {
 	p.addHeadComment("%s: Error: %s", m.Name, err.Error())
 }
@@ -48,6 +48,7 @@ import (
 	"github.com/thestormforge/optimize-go/pkg/config"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/kustomize/api/filesys"
@@ -342,7 +343,7 @@ func (o *Options) runner(ctx context.Context) error {
 		server.ToClusterTrial(trial, trialDetails.Assignments)
 
 		// render patches
-		if pp, err := createTrialKustomizePatches(o.experiment.Spec.Patches, trial); err != nil {
+		if pp, err := createTrialKustomizePatches(o.experiment, trial); err != nil {
 			return err
 		} else {
 			patches = append(patches, pp...)
@@ -511,11 +512,11 @@ func (o *Options) getTrialDetails(ctx context.Context) (*trialDetails, error) {
 }
 
 // createTrialKustomizePatches translates a patchTemplate into a kustomize (json) patch
-func createTrialKustomizePatches(patchSpec []optimizev1beta2.PatchTemplate, trial *optimizev1beta2.Trial) ([]types.Patch, error) {
-	te := template.New()
-	patches := make([]types.Patch, len(patchSpec))
+func createTrialKustomizePatches(exp *optimizev1beta2.Experiment, trial *optimizev1beta2.Trial) ([]types.Patch, error) {
+	te := template.New().WithExperiment(exp)
+	patches := make([]types.Patch, len(exp.Spec.Patches))
 
-	for idx, expPatch := range patchSpec {
+	for idx, expPatch := range exp.Spec.Patches {
 		ref, data, err := patch.RenderTemplate(te, trial, &expPatch)
 		if err != nil {
 			return nil, err
@@ -571,6 +572,11 @@ func createTrialKustomizePatches(patchSpec []optimizev1beta2.PatchTemplate, tria
 				},
 			},
 		}
+
+		// Let kustomize match the objects selected by the patch
+		if expPatch.Selector != nil {
+			patches[idx].Target.LabelSelector = metav1.FormatLabelSelector(expPatch.Selector)
+		}
 	}
 
 	return patches, nil
@@ -28,6 +28,7 @@ import (
 	"github.com/spf13/cobra"
 	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
 	"github.com/thestormforge/optimize-controller/v2/cli/internal/commander"
+	"github.com/thestormforge/optimize-controller/v2/internal/template"
 	"github.com/thestormforge/optimize-go/pkg/config"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
@@ -90,19 +91,53 @@ func NewRBACCommand(o *RBACOptions) *cobra.Command {
 	return cmd
 }
 
+// clusterScopedKinds are the built-in types which are not namespaced, the scheme does not record the scope of a type.
+var clusterScopedKinds = map[schema.GroupKind]bool{
+	{Group: "", Kind: "ComponentStatus"}:                                            true,
+	{Group: "", Kind: "Namespace"}:                                                  true,
+	{Group: "", Kind: "Node"}:                                                       true,
+	{Group: "", Kind: "PersistentVolume"}:                                           true,
+	{Group: "admissionregistration.k8s.io", Kind: "MutatingWebhookConfiguration"}:   true,
+	{Group: "admissionregistration.k8s.io", Kind: "ValidatingWebhookConfiguration"}: true,
+	{Group: "auditregistration.k8s.io", Kind: "AuditSink"}:                          true,
+	{Group: "certificates.k8s.io", Kind: "CertificateSigningRequest"}:               true,
+	{Group: "extensions", Kind: "PodSecurityPolicy"}:                                true,
+	{Group: "flowcontrol.apiserver.k8s.io", Kind: "FlowSchema"}:                     true,
+	{Group: "flowcontrol.apiserver.k8s.io", Kind: "PriorityLevelConfiguration"}:     true,
+	{Group: "node.k8s.io", Kind: "RuntimeClass"}:                                    true,
+	{Group: "policy", Kind: "PodSecurityPolicy"}:                                    true,
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole"}:                       true,
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"}:                true,
+	{Group: "scheduling.k8s.io", Kind: "PriorityClass"}:                             true,
+	{Group: "storage.k8s.io", Kind: "CSIDriver"}:                                    true,
+	{Group: "storage.k8s.io", Kind: "CSINode"}:                                      true,
+	{Group: "storage.k8s.io", Kind: "StorageClass"}:                                 true,
+	{Group: "storage.k8s.io", Kind: "VolumeAttachment"}:                             true,
+}
+
 func (o *RBACOptions) Complete(ctx context.Context) {
 	// Create a REST mapper to convert from GroupVersionKind (used on patch targets) to GroupVersionResource (used in policy rules)
+	rm := newRESTMapper()
+	o.addInstalledCRDs(ctx, rm)
+	o.mapper = rm
+}
+
+// newRESTMapper returns a REST mapper for the built-in types
+func newRESTMapper() *meta.DefaultRESTMapper {
 	rm := meta.NewDefaultRESTMapper(scheme.Scheme.PreferredVersionAllGroups())
 	for gvk := range scheme.Scheme.AllKnownTypes() {
-		rm.Add(gvk, meta.RESTScopeRoot)
+		if clusterScopedKinds[gvk.GroupKind()] {
+			rm.Add(gvk, meta.RESTScopeRoot)
+		} else {
+			rm.Add(gvk, meta.RESTScopeNamespace)
+		}
 	}
-	o.addInstalledCRDs(ctx, rm)
-	o.mapper = rm
+	return rm
 }
 
 func (o *RBACOptions) addInstalledCRDs(ctx context.Context, rm *meta.DefaultRESTMapper) {
 	cmd, err := o.Config.Kubectl(ctx, "get", "crds", "--output", "jsonpath", "--template",
-		`{range .items[*].spec}{.group}/{.version} {.names.kind} {.names.plural} {.names.singular}{"\n"}{end}`)
+		`{range .items[*].spec}{.group}/{.version} {.names.kind} {.names.plural} {.names.singular} {.scope}{"\n"}{end}`)
 	if err != nil {
 		return
 	}
@@ -121,7 +156,11 @@ func (o *RBACOptions) addInstalledCRDs(ctx context.Context, rm *meta.DefaultREST
 			continue
 		}
 
-		rm.AddSpecific(gv.WithKind(f[1]), gv.WithResource(f[2]), gv.WithResource(f[3]), meta.RESTScopeRoot)
+		scope := meta.RESTScopeNamespace
+		if len(f) > 4 && f[4] == "Cluster" {
+			scope = meta.RESTScopeRoot
+		}
+		rm.AddSpecific(gv.WithKind(f[1]), gv.WithResource(f[2]), gv.WithResource(f[3]), scope)
 	}
 }
 
@@ -271,16 +310,66 @@ func buildRBAC(roleRef *rbacv1.RoleRef, subject *rbacv1.Subject, rules []rbacv1.
 	return result
 }
 
-// appendRules finds the patch and readiness targets from an experiment
+// appendRules finds the patch, metric credential, template lookup and readiness targets from an experiment
 func (o *RBACOptions) appendRules(rules []*rbacv1.PolicyRule, exp *optimizev1beta2.Experiment) []*rbacv1.PolicyRule {
-	// Patches require "get" and "patch" permissions
+	// Patches require "get" and "patch" permissions, patches with a selector also require "list" permissions
 	for i := range exp.Spec.Patches {
 		// TODO This needs to use patch_controller.go `renderTemplate` to get the correct reference (e.g. SMP may have the ref in the payload)
 		// NOTE: Technically we can not get the target reference without an actual trial; in most cases a dummy trial should work
 		ref := exp.Spec.Patches[i].TargetRef
-		if ref != nil {
+		if ref != nil && exp.Spec.Patches[i].Selector != nil {
+			rules = append(rules, o.newPolicyRule(ref, "get", "list", "patch"))
+		} else if ref != nil {
 			rules = append(rules, o.newPolicyRule(ref, "get", "patch"))
 		}
+
+		// Patches to configuration objects restart the workloads which consume them
+		if ref != nil && ref.APIVersion == "v1" && (ref.Kind == "ConfigMap" || ref.Kind == "Secret") {
+			for _, kind := range []string{"Deployment", "StatefulSet", "DaemonSet"} {
+				rules = append(rules, o.newPolicyRule(&corev1.ObjectReference{APIVersion: "apps/v1", Kind: kind}, "get", "list", "patch"))
+			}
+		}
+	}
+
+	// Metric credentials require "get" permissions on the referenced secret, pushed metrics require "create" and "get"
+	// permissions on the (generated) secrets holding the trial push tokens
+	for i := range exp.Spec.Metrics {
+		if secretRef := exp.Spec.Metrics[i].SecretRef; secretRef != nil {
+			ref := &corev1.ObjectReference{APIVersion: "v1", Kind: "Secret", Name: secretRef.Name}
+			rules = append(rules, o.newPolicyRule(ref, "get"))
+		}
+		if exp.Spec.Metrics[i].Type == optimizev1beta2.MetricPush {
+			ref := &corev1.ObjectReference{APIVersion: "v1", Kind: "Secret"}
+			rules = append(rules, o.newPolicyRule(ref, "create", "get"))
+		}
+	}
+
+	// Template lookups with a name require "get" permissions, no name requires "list" permissions; a computed name can
+	// be any object so the "get" permission is not restricted to a name
+	for _, ref := range lookupReferences(exp) {
+		ref := ref
+		switch {
+		case ref.DynamicType:
+			o.warnf("Unable to determine the objects read by a template lookup, no permissions were generated for it")
+			continue
+		case ref.DynamicName || ref.Name != "":
+			rules = append(rules, o.newPolicyRule(&ref.ObjectReference, "get"))
+		default:
+			rules = append(rules, o.newPolicyRule(&ref.ObjectReference, "list"))
+		}
+
+		// A role only grants access to namespaced objects in the namespace of the experiment
+		if o.ClusterRole {
+			continue
+		}
+		switch {
+		case o.restMapping(&ref.ObjectReference).Scope.Name() == meta.RESTScopeNameRoot:
+			o.warnf("A template lookup of %s is cluster scoped, the generated role cannot grant access to it (consider using --cluster-role)", ref.Kind)
+		case ref.DynamicNamespace:
+			o.warnf("A template lookup of %s uses a computed namespace, the generated role only grants access in the experiment namespace (consider using --cluster-role)", ref.Kind)
+		case ref.Namespace != "" && exp.Namespace != "" && ref.Namespace != exp.Namespace:
+			o.warnf("A template lookup of %s reads from namespace %q, the generated role only grants access in namespace %q (consider using --cluster-role)", ref.Kind, ref.Namespace, exp.Namespace)
+		}
 	}
 
 	// Readiness gates with a name require "get" permissions, no name requires "list" permissions
@@ -301,6 +390,35 @@ func (o *RBACOptions) appendRules(rules []*rbacv1.PolicyRule, exp *optimizev1bet
 	return rules
 }
 
+// lookupReferences returns the objects read by the `lookup` function from the patch and metric templates
+func lookupReferences(exp *optimizev1beta2.Experiment) []template.LookupReference {
+	var texts []string
+	for i := range exp.Spec.Patches {
+		texts = append(texts, exp.Spec.Patches[i].Patch)
+	}
+	for i := range exp.Spec.Metrics {
+		m := &exp.Spec.Metrics[i]
+		texts = append(texts, m.Query, m.ErrorQuery)
+		if m.HTTP != nil {
+			for _, h := range m.HTTP.Headers {
+				texts = append(texts, h.Value)
+			}
+			texts = append(texts, m.HTTP.Body)
+		}
+	}
+
+	var refs []template.LookupReference
+	for _, text := range texts {
+		// Templates which do not parse will fail at runtime anyway, there is nothing to grant
+		r, err := template.LookupReferences(text)
+		if err != nil {
+			continue
+		}
+		refs = append(refs, r...)
+	}
+	return refs
+}
+
 // newPolicyRule creates a new policy rule for the specified object reference and list of verbs
 func (o *RBACOptions) newPolicyRule(ref *corev1.ObjectReference, verbs ...string) *rbacv1.PolicyRule {
 	// Start with the requested verbs
@@ -309,13 +427,7 @@ func (o *RBACOptions) newPolicyRule(ref *corev1.ObjectReference, verbs ...string
 	}
 
 	// Get the mapping from GVK to GVR
-	gvk := ref.GroupVersionKind()
-	m, err := o.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
-	if err != nil {
-		// TODO If this is guessing wrong too often we may need to allow additional mappings in the configuration
-		m = &meta.RESTMapping{GroupVersionKind: gvk, Scope: meta.RESTScopeRoot}
-		m.Resource, _ = meta.UnsafeGuessKindToResource(gvk)
-	}
+	m := o.restMapping(ref)
 	r.APIGroups = []string{m.Resource.Group}
 	r.Resources = []string{m.Resource.Resource}
 
@@ -327,6 +439,25 @@ func (o *RBACOptions) newPolicyRule(ref *corev1.ObjectReference, verbs ...string
 	return r
 }
 
+// restMapping returns the mapping for the specified object reference, guessing if the type is not known
+func (o *RBACOptions) restMapping(ref *corev1.ObjectReference) *meta.RESTMapping {
+	gvk := ref.GroupVersionKind()
+	m, err := o.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		// TODO If this is guessing wrong too often we may need to allow additional mappings in the configuration
+		m = &meta.RESTMapping{GroupVersionKind: gvk, Scope: meta.RESTScopeNamespace}
+		m.Resource, _ = meta.UnsafeGuessKindToResource(gvk)
+	}
+	return m
+}
+
+// warnf reports a problem with the generated rules that cannot be fixed automatically
+func (o *RBACOptions) warnf(format string, args ...interface{}) {
+	if o.ErrOut != nil {
+		_, _ = fmt.Fprintf(o.ErrOut, "Warning: "+format+"\n", args...)
+	}
+}
+
 // roleName attempts to generate a semi-unique role name
 func roleName(filename string, experimentList *optimizev1beta2.ExperimentList) string {
 	// If there is a single experiment, incorporate it's name into the role name
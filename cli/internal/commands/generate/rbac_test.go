@@ -0,0 +1,184 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestRBACOptions_AppendRules_Lookup(t *testing.T) {
+	rm := newRESTMapper()
+
+	testCases := []struct {
+		desc          string
+		query         string
+		clusterRole   bool
+		expected      []rbacv1.PolicyRule
+		expectedWarns []string
+	}{
+		{
+			desc:  "literal name",
+			query: `{{ $limits := lookup "v1" "ConfigMap" "my-namespace" "limits" }}`,
+			expected: []rbacv1.PolicyRule{
+				{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{"limits"}},
+			},
+		},
+		{
+			desc:  "list",
+			query: `{{ range (lookup "apps/v1" "Deployment" "my-namespace" "").items }}{{ end }}`,
+			expected: []rbacv1.PolicyRule{
+				{Verbs: []string{"list"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}},
+			},
+		},
+		{
+			desc:  "computed name",
+			query: `{{ $limits := lookup "v1" "ConfigMap" "my-namespace" .Values.name }}`,
+			expected: []rbacv1.PolicyRule{
+				{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"configmaps"}},
+			},
+		},
+		{
+			desc:  "computed namespace",
+			query: `{{ $limits := lookup "v1" "ConfigMap" .Values.namespace "limits" }}`,
+			expected: []rbacv1.PolicyRule{
+				{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{"limits"}},
+			},
+			expectedWarns: []string{"A template lookup of ConfigMap uses a computed namespace"},
+		},
+		{
+			desc:        "computed namespace cluster role",
+			query:       `{{ $limits := lookup "v1" "ConfigMap" .Values.namespace "limits" }}`,
+			clusterRole: true,
+			expected: []rbacv1.PolicyRule{
+				{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{"limits"}},
+			},
+		},
+		{
+			desc:  "other namespace",
+			query: `{{ $limits := lookup "v1" "ConfigMap" "default" "limits" }}`,
+			expected: []rbacv1.PolicyRule{
+				{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{"limits"}},
+			},
+			expectedWarns: []string{`A template lookup of ConfigMap reads from namespace "default"`},
+		},
+		{
+			desc:  "cluster scoped",
+			query: `{{ range (lookup "v1" "Node" "" "").items }}{{ end }}`,
+			expected: []rbacv1.PolicyRule{
+				{Verbs: []string{"list"}, APIGroups: []string{""}, Resources: []string{"nodes"}},
+			},
+			expectedWarns: []string{"A template lookup of Node is cluster scoped"},
+		},
+		{
+			desc:        "cluster scoped cluster role",
+			query:       `{{ range (lookup "v1" "Node" "" "").items }}{{ end }}`,
+			clusterRole: true,
+			expected: []rbacv1.PolicyRule{
+				{Verbs: []string{"list"}, APIGroups: []string{""}, Resources: []string{"nodes"}},
+			},
+		},
+		{
+			desc:  "cluster scoped group",
+			query: `{{ $sc := lookup "storage.k8s.io/v1" "StorageClass" "" "standard" }}`,
+			expected: []rbacv1.PolicyRule{
+				{Verbs: []string{"get"}, APIGroups: []string{"storage.k8s.io"}, Resources: []string{"storageclasses"}, ResourceNames: []string{"standard"}},
+			},
+			expectedWarns: []string{"A template lookup of StorageClass is cluster scoped"},
+		},
+		{
+			desc:          "computed kind",
+			query:         `{{ $limits := lookup "v1" .Values.kind "my-namespace" "limits" }}`,
+			expectedWarns: []string{"Unable to determine the objects read by a template lookup"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			errOut := &bytes.Buffer{}
+			o := &RBACOptions{IncludeNames: true, ClusterRole: tc.clusterRole, mapper: rm}
+			o.ErrOut = errOut
+
+			exp := &optimizev1beta2.Experiment{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-experiment", Namespace: "my-namespace"},
+				Spec: optimizev1beta2.ExperimentSpec{
+					Metrics: []optimizev1beta2.Metric{{Name: "test", Query: tc.query}},
+				},
+			}
+
+			var rules []rbacv1.PolicyRule
+			for _, r := range o.appendRules(nil, exp) {
+				rules = mergeRule(rules, r)
+			}
+			assert.Equal(t, tc.expected, rules)
+
+			warns := strings.Split(strings.TrimSpace(errOut.String()), "\n")
+			if len(tc.expectedWarns) == 0 {
+				assert.Empty(t, errOut.String())
+			} else if assert.Len(t, warns, len(tc.expectedWarns)) {
+				for i := range tc.expectedWarns {
+					assert.Contains(t, warns[i], tc.expectedWarns[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRBACOptions_AppendRules_Push(t *testing.T) {
+	rm := newRESTMapper()
+
+	o := &RBACOptions{IncludeNames: true, mapper: rm}
+	exp := &optimizev1beta2.Experiment{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-experiment", Namespace: "my-namespace"},
+		Spec: optimizev1beta2.ExperimentSpec{
+			Metrics: []optimizev1beta2.Metric{
+				{Name: "throughput", Type: optimizev1beta2.MetricPush, Query: "throughput"},
+				{Name: "latency", Type: optimizev1beta2.MetricPush, Query: "latency"},
+			},
+		},
+	}
+
+	var rules []rbacv1.PolicyRule
+	for _, r := range o.appendRules(nil, exp) {
+		rules = mergeRule(rules, r)
+	}
+	assert.Equal(t, []rbacv1.PolicyRule{
+		{Verbs: []string{"create", "get"}, APIGroups: []string{""}, Resources: []string{"secrets"}},
+	}, rules)
+}
+
+func TestNewRESTMapper(t *testing.T) {
+	rm := newRESTMapper()
+	for gk := range clusterScopedKinds {
+		m, err := rm.RESTMapping(gk)
+		if assert.NoError(t, err, gk.String()) {
+			assert.Equal(t, meta.RESTScopeNameRoot, m.Scope.Name(), gk.String())
+		}
+	}
+
+	m, err := rm.RESTMapping(schema.GroupKind{Kind: "ConfigMap"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, meta.RESTScopeNameNamespace, m.Scope.Name())
+	}
+}
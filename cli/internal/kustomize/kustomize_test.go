@@ -96,7 +96,7 @@ func Test(t *testing.T) {
 
 			res, err := k.Run(k.fs, k.Base)
 			assert.NoError(t, err)
-			assert.Equal(t, res.Size(), 6)
+			assert.Equal(t, res.Size(), 7)
 
 			r, err := res.Select(types.Selector{KrmId: types.KrmId{Name: "optimize-controller-manager"}})
 			assert.NoError(t, err)
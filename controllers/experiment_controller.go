@@ -24,9 +24,11 @@ import (
 	"github.com/thestormforge/optimize-controller/v2/internal/controller"
 	"github.com/thestormforge/optimize-controller/v2/internal/experiment"
 	"github.com/thestormforge/optimize-controller/v2/internal/meta"
+	"github.com/thestormforge/optimize-controller/v2/internal/patch"
 	"github.com/thestormforge/optimize-controller/v2/internal/trial"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -38,10 +40,15 @@ import (
 type ExperimentReconciler struct {
 	client.Client
 	Log logr.Logger
+
+	// Keep the raw API reader for restoring patched objects. We are only expected to have "get" permission on the
+	// patched objects, using the caching reader would require list/watch permissions.
+	apiReader client.Reader
 }
 
 // +kubebuilder:rbac:groups=optimize.stormforge.io,resources=experiments;experiments/finalizers,verbs=get;list;watch;update
 // +kubebuilder:rbac:groups=optimize.stormforge.io,resources=trials,verbs=list;watch;update;delete
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;delete
 
 func (r *ExperimentReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -68,10 +75,15 @@ func (r *ExperimentReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 		return *result, err
 	}
 
+	if result, err := r.restorePatches(ctx, exp, trialList); result != nil {
+		return *result, err
+	}
+
 	return ctrl.Result{}, nil
 }
 
 func (r *ExperimentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.apiReader = mgr.GetAPIReader()
 	return ctrl.NewControllerManagedBy(mgr).
 		Named("experiment").
 		For(&optimizev1beta2.Experiment{}).
@@ -158,6 +170,61 @@ func (r *ExperimentReconciler) cleanupTrials(ctx context.Context, exp *optimizev
 	return nil, nil
 }
 
+// restorePatches will return patched objects to their original state once the experiment is finished or deleted
+func (r *ExperimentReconciler) restorePatches(ctx context.Context, exp *optimizev1beta2.Experiment, trialList *optimizev1beta2.TrialList) (*ctrl.Result, error) {
+	// If the patched objects are not being restored, make sure we are not blocking deletion
+	if !patch.NeedsSnapshot(exp) {
+		if meta.RemoveFinalizer(exp, patch.RestoreFinalizer) {
+			err := r.Update(ctx, exp)
+			return controller.RequeueConflict(err)
+		}
+		return nil, nil
+	}
+
+	// Keep a finalizer on running experiments so we can restore the patched objects if they are deleted
+	if !experiment.IsFinished(exp) && exp.DeletionTimestamp.IsZero() {
+		if meta.AddFinalizer(exp, patch.RestoreFinalizer) {
+			err := r.Update(ctx, exp)
+			return controller.RequeueConflict(err)
+		}
+		return nil, nil
+	}
+
+	// Wait for the trials to stop patching (or restoring) objects
+	if !meta.HasFinalizer(exp, patch.RestoreFinalizer) {
+		return nil, nil
+	}
+	for i := range trialList.Items {
+		if trial.IsActive(&trialList.Items[i]) {
+			return nil, nil
+		}
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := r.apiReader.Get(ctx, patch.SnapshotNamespacedName(types.NamespacedName{Namespace: exp.Namespace, Name: exp.Name}), cm); controller.IgnoreNotFound(err) != nil {
+		return &ctrl.Result{}, err
+	} else if err == nil {
+		originals, err := patch.Snapshots(cm)
+		if err != nil {
+			return &ctrl.Result{}, err
+		}
+		for _, original := range originals {
+			if err := patch.Restore(ctx, r.apiReader, r, original); err != nil {
+				return &ctrl.Result{}, err
+			}
+		}
+
+		// Discard the snapshot so nothing is restored twice
+		if err := r.Delete(ctx, cm); controller.IgnoreNotFound(err) != nil {
+			return &ctrl.Result{}, err
+		}
+	}
+
+	meta.RemoveFinalizer(exp, patch.RestoreFinalizer)
+	err := r.Update(ctx, exp)
+	return controller.RequeueConflict(err)
+}
+
 // listTrials retrieves the list of trial objects matching the specified selector
 func (r *ExperimentReconciler) listTrials(ctx context.Context, trialList *optimizev1beta2.TrialList, selector *metav1.LabelSelector) error {
 	matchingSelector, err := meta.MatchingSelector(selector)
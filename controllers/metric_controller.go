@@ -21,6 +21,8 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	batchv1 "k8s.io/api/batch/v1"
@@ -35,6 +37,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -44,11 +47,22 @@ type MetricReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// Keep the raw API reader for fetching metric credentials and looking up objects from metric templates. We are
+	// only expected to have "get" permission on the referenced objects, using the caching reader would require
+	// list/watch permissions.
+	apiReader client.Reader
+
+	// Track when metrics that requested a retry delay can be attempted again, indexed by trial and metric name. This
+	// is not persisted, a restart of the controller just results in an early retry.
+	retryMu    sync.Mutex
+	retryTimes map[types.NamespacedName]map[string]time.Time
 }
 
 // +kubebuilder:rbac:groups=optimize.stormforge.io,resources=experiments,verbs=get;list;watch
 // +kubebuilder:rbac:groups=optimize.stormforge.io,resources=trials,verbs=get;list;watch;update
 // +kubebuilder:rbac:groups="",resources=pods,verbs=list
+// +kubebuilder:rbac:groups=metrics.k8s.io,resources=pods,verbs=list
 
 func (r *MetricReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -56,9 +70,15 @@ func (r *MetricReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 	t := &optimizev1beta2.Trial{}
 	if err := r.Get(ctx, req.NamespacedName, t); err != nil || r.ignoreTrial(t) {
+		// Retry delays are not needed once the trial is gone or finished
+		r.clearRetryAfter(req.NamespacedName)
 		return ctrl.Result{}, controller.IgnoreNotFound(err)
 	}
 
+	if result, err := r.sampleMetrics(ctx, t, &now); result != nil {
+		return *result, err
+	}
+
 	if result, err := r.evaluateMetrics(ctx, t, &now); result != nil {
 		return *result, err
 	}
@@ -71,6 +91,7 @@ func (r *MetricReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 }
 
 func (r *MetricReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.apiReader = mgr.GetAPIReader()
 	return ctrl.NewControllerManagedBy(mgr).
 		Named("metric").
 		For(&optimizev1beta2.Trial{}).
@@ -88,12 +109,18 @@ func (r *MetricReconciler) ignoreTrial(t *optimizev1beta2.Trial) bool {
 		return true
 	}
 
-	// Ignore trials to do not have defined start/completion times
+	// Ignore trials to do not have defined start times
 	// NOTE: This checks the status to prevent needing to reproduce job start/completion lookup logic
-	if t.Status.StartTime == nil || t.Status.CompletionTime == nil {
+	if t.Status.StartTime == nil {
 		return true
 	}
 
+	// Only running trials with metrics that are sampled during the trial run need to be reconciled
+	if t.Status.CompletionTime == nil {
+		_, ok := t.Annotations[optimizev1beta2.AnnotationMetricSamples]
+		return !ok
+	}
+
 	// Do not ignore trials that have metrics pending collection
 	for i := range t.Spec.Values {
 		if t.Spec.Values[i].AttemptsRemaining > 0 {
@@ -110,6 +137,55 @@ func (r *MetricReconciler) ignoreTrial(t *optimizev1beta2.Trial) bool {
 	return true
 }
 
+// sampleMetrics records samples for metrics that are collected during the trial run.
+func (r *MetricReconciler) sampleMetrics(ctx context.Context, t *optimizev1beta2.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
+	// Samples are only recorded while the trial is running
+	if t.Status.CompletionTime != nil {
+		return nil, nil
+	}
+
+	// Samples are only recorded for trials created with sampled metrics
+	samples, ok := t.Annotations[optimizev1beta2.AnnotationMetricSamples]
+	if !ok {
+		return &ctrl.Result{}, nil
+	}
+
+	// Get the experiment
+	exp := &optimizev1beta2.Experiment{}
+	if err := r.Get(ctx, t.ExperimentNamespacedName(), exp); err != nil {
+		return &ctrl.Result{}, err
+	}
+
+	// Sample the metrics, requeue for the next sample that is due
+	result := &ctrl.Result{}
+	for i := range exp.Spec.Metrics {
+		if exp.Spec.Metrics[i].Type != optimizev1beta2.MetricPodMetrics {
+			continue
+		}
+
+		m := exp.Spec.Metrics[i].DeepCopy()
+		if err := r.applyMetricDefaults(ctx, t, m); err != nil {
+			return &ctrl.Result{}, err
+		}
+
+		next, err := metric.SamplePodMetrics(ctx, r.apiReader, t, m, probeTime.Time)
+		if err != nil {
+			return &ctrl.Result{}, err
+		}
+
+		requeueAfter(result, next)
+	}
+
+	// Record any new samples
+	if t.Annotations[optimizev1beta2.AnnotationMetricSamples] != samples {
+		if err := r.Update(ctx, t); err != nil {
+			return controller.RequeueConflict(err)
+		}
+	}
+
+	return result, nil
+}
+
 func (r *MetricReconciler) evaluateMetrics(ctx context.Context, t *optimizev1beta2.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
 	// TODO This check precludes manual additions of Values
 	if len(t.Spec.Values) > 0 {
@@ -160,38 +236,99 @@ func (r *MetricReconciler) collectMetrics(ctx context.Context, t *optimizev1beta
 		"completionTime", t.Status.CompletionTime.Time,
 	)
 
-	// Iterate over the metric values, looking for remaining attempts
-	for i := range t.Spec.Values {
-		v := &t.Spec.Values[i]
-		if v.AttemptsRemaining <= 0 {
-			continue
+	// Collect the metric values in rounds: each round concurrently captures every value that is not waiting on a
+	// retry or a dependency, a value is only attempted once per reconcile
+	result := &ctrl.Result{}
+	attempted := make(map[string]bool, len(t.Spec.Values))
+	updated := false
+	var blocked *optimizev1beta2.Value
+	for !trial.CheckCondition(&t.Status, optimizev1beta2.TrialFailed, corev1.ConditionTrue) {
+		var batch []*optimizev1beta2.Value
+		blocked = nil
+		for i := range t.Spec.Values {
+			v := &t.Spec.Values[i]
+			if v.AttemptsRemaining <= 0 || attempted[v.Name] {
+				continue
+			}
+
+			// Wait for the previously requested retry delay
+			if retryAfter := r.retryAfter(t, v.Name, probeTime.Time); retryAfter > 0 {
+				requeueAfter(result, retryAfter)
+				continue
+			}
+
+			// Wait until the values this metric depends on have been collected
+			if pending, err := pendingDependencies(t, metrics[v.Name]); err != nil {
+				attempted[v.Name] = true
+				updated = true
+				r.collectionAttempt(log, t, v, probeTime, err)
+				continue
+			} else if pending {
+				if blocked == nil {
+					blocked = v
+				}
+				continue
+			}
+
+			attempted[v.Name] = true
+			batch = append(batch, v)
 		}
 
-		// Apply defaults to our local copy of the metric definition
-		m := metrics[v.Name]
-		if err := r.applyMetricDefaults(ctx, t, m); err != nil {
-			return r.collectionAttempt(ctx, log, t, v, probeTime, err)
+		if len(batch) == 0 {
+			break
 		}
 
-		// Do any Kube API lookups while we have the API client
-		target, err := r.target(ctx, t, m)
-		if err != nil {
-			return r.collectionAttempt(ctx, log, t, v, probeTime, err)
+		// Capture the values concurrently, the trial must not be modified until all the captures are finished
+		values := make([]float64, len(batch))
+		valueErrors := make([]float64, len(batch))
+		errs := make([]error, len(batch))
+		var wg sync.WaitGroup
+		for i := range batch {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				values[i], valueErrors[i], errs[i] = r.captureValue(ctx, log, t, metrics[batch[i].Name])
+			}(i)
 		}
+		wg.Wait()
+
+		for i := range batch {
+			if errs[i] == nil {
+				batch[i].Value = strconv.FormatFloat(values[i], 'f', -1, 64)
+				if !math.IsNaN(valueErrors[i]) {
+					batch[i].Error = strconv.FormatFloat(valueErrors[i], 'f', -1, 64)
+				}
+			}
 
-		// Capture the metric value
-		value, valueError, err := metric.CaptureMetric(ctx, log, t, m, target)
-		if err != nil {
-			return r.collectionAttempt(ctx, log, t, v, probeTime, err)
+			// Retries do not change the trial, they only need to be requeued
+			if retryAfter := r.collectionAttempt(log, t, batch[i], probeTime, errs[i]); retryAfter > 0 {
+				requeueAfter(result, r.setRetryAfter(t, batch[i].Name, probeTime.Time, retryAfter))
+			} else {
+				updated = true
+			}
 		}
+	}
+
+	// If the only remaining metrics are waiting on each other, they will never be collected
+	if blocked != nil && len(attempted) == 0 && result.RequeueAfter == 0 {
+		attempted[blocked.Name] = true
+		updated = true
+		r.collectionAttempt(log, t, blocked, probeTime, fmt.Errorf("metric %q has unresolved dependencies", blocked.Name))
+	}
 
-		// Success, record the value
-		v.Value = strconv.FormatFloat(value, 'f', -1, 64)
-		if !math.IsNaN(valueError) {
-			v.Error = strconv.FormatFloat(valueError, 'f', -1, 64)
+	// Record the outcome of all the attempts in a single update
+	if updated {
+		// Update the probe time and ensure that trial observed is still explicitly false (i.e. we have started observation but it is not complete)
+		trial.ApplyCondition(&t.Status, optimizev1beta2.TrialObserved, corev1.ConditionFalse, "", "", probeTime)
+		if err := r.Update(ctx, t); err != nil {
+			return controller.RequeueConflict(err)
 		}
+		return result, nil
+	}
 
-		return r.collectionAttempt(ctx, log, t, v, probeTime, nil)
+	// Wait for the remaining values
+	if result.RequeueAfter > 0 {
+		return result, nil
 	}
 
 	// Wait until all metrics have been collected to fail the trial for an out of bounds metric
@@ -208,16 +345,41 @@ func (r *MetricReconciler) collectMetrics(ctx context.Context, t *optimizev1beta
 	}
 
 	// We made it through all of the metrics without needing additional changes
+	r.clearRetryAfter(types.NamespacedName{Namespace: t.Namespace, Name: t.Name})
 	trial.ApplyCondition(&t.Status, optimizev1beta2.TrialObserved, corev1.ConditionTrue, "", "", probeTime)
 	err := r.Update(ctx, t)
 	return controller.RequeueConflict(err)
 }
 
-// collectionAttempt updates the status of the trial based on the outcome of an attempt to collect metric values.
-func (r *MetricReconciler) collectionAttempt(ctx context.Context, log logr.Logger, t *optimizev1beta2.Trial, v *optimizev1beta2.Value, probeTime *metav1.Time, err error) (*ctrl.Result, error) {
+// captureValue captures the value of a single metric. The supplied metric is modified, the trial is only read.
+func (r *MetricReconciler) captureValue(ctx context.Context, log logr.Logger, t *optimizev1beta2.Trial, m *optimizev1beta2.Metric) (float64, float64, error) {
+	// Apply defaults to our local copy of the metric definition
+	if err := r.applyMetricDefaults(ctx, t, m); err != nil {
+		return 0, 0, err
+	}
+
+	// Do any Kube API lookups while we have the API client
+	target, err := r.target(ctx, t, m)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Resolve the credentials used to capture the metric
+	secret, err := r.secret(ctx, t, m)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Capture the metric value
+	return metric.CaptureMetric(ctx, log.WithValues("metric", m.Name), r.apiReader, t, m, target, secret)
+}
+
+// collectionAttempt updates the trial based on the outcome of an attempt to collect a metric value. If the attempt
+// should be retried without counting against the remaining attempts, the requested delay is returned.
+func (r *MetricReconciler) collectionAttempt(log logr.Logger, t *optimizev1beta2.Trial, v *optimizev1beta2.Value, probeTime *metav1.Time, err error) time.Duration {
 	// Do not count retries against the remaining attempts
 	if merr, ok := err.(*metric.CaptureError); ok && merr.RetryAfter > 0 {
-		return &ctrl.Result{RequeueAfter: merr.RetryAfter}, nil
+		return merr.RetryAfter
 	}
 
 	// Update the number of remaining attempts
@@ -226,21 +388,61 @@ func (r *MetricReconciler) collectionAttempt(ctx context.Context, log logr.Logge
 		v.AttemptsRemaining = 0
 	}
 
-	// Update the probe time and ensure that trial observed is still explicitly false (i.e. we have started observation but it is not complete)
-	trial.ApplyCondition(&t.Status, optimizev1beta2.TrialObserved, corev1.ConditionFalse, "", "", probeTime)
-
 	// Fail the trial if there is an error and no attempts are left
 	if err != nil && v.AttemptsRemaining == 0 {
 		trial.ApplyCondition(&t.Status, optimizev1beta2.TrialFailed, corev1.ConditionTrue, "MetricFailed", err.Error(), probeTime)
 
 		// Metric errors contain additional information which should be logged for debugging
 		if merr, ok := err.(*metric.CaptureError); ok {
-			log.Error(merr, "Metric collection failed", "address", merr.Address, "query", merr.Query)
+			log.Error(merr, "Metric collection failed", "metric", v.Name, "address", merr.Address, "query", merr.Query)
 		}
 	}
 
-	// Record the update
-	return controller.RequeueConflict(r.Update(ctx, t))
+	return 0
+}
+
+// retryAfter returns the amount of time remaining before a metric value should be retried.
+func (r *MetricReconciler) retryAfter(t *optimizev1beta2.Trial, name string, now time.Time) time.Duration {
+	r.retryMu.Lock()
+	defer r.retryMu.Unlock()
+
+	key := types.NamespacedName{Namespace: t.Namespace, Name: t.Name}
+	if d := r.retryTimes[key][name].Sub(now); d > 0 {
+		return d
+	}
+	delete(r.retryTimes[key], name)
+	return 0
+}
+
+// setRetryAfter records the delay before a metric value should be retried.
+func (r *MetricReconciler) setRetryAfter(t *optimizev1beta2.Trial, name string, now time.Time, retryAfter time.Duration) time.Duration {
+	r.retryMu.Lock()
+	defer r.retryMu.Unlock()
+
+	key := types.NamespacedName{Namespace: t.Namespace, Name: t.Name}
+	if r.retryTimes == nil {
+		r.retryTimes = make(map[types.NamespacedName]map[string]time.Time)
+	}
+	if r.retryTimes[key] == nil {
+		r.retryTimes[key] = make(map[string]time.Time)
+	}
+	r.retryTimes[key][name] = now.Add(retryAfter)
+	return retryAfter
+}
+
+// clearRetryAfter discards the retry delays recorded for a trial.
+func (r *MetricReconciler) clearRetryAfter(key types.NamespacedName) {
+	r.retryMu.Lock()
+	defer r.retryMu.Unlock()
+
+	delete(r.retryTimes, key)
+}
+
+// requeueAfter updates the result to requeue after the shortest requested delay.
+func requeueAfter(result *ctrl.Result, d time.Duration) {
+	if result.RequeueAfter == 0 || d < result.RequeueAfter {
+		result.RequeueAfter = d
+	}
 }
 
 // target looks up the Kubernetes object (if any) associated with a metric.
@@ -285,11 +487,49 @@ func (r *MetricReconciler) target(ctx context.Context, t *optimizev1beta2.Trial,
 	return target, nil
 }
 
+// secret looks up the Kubernetes secret (if any) containing the credentials for a metric.
+func (r *MetricReconciler) secret(ctx context.Context, t *optimizev1beta2.Trial, m *optimizev1beta2.Metric) (*corev1.Secret, error) {
+	if m.SecretRef == nil {
+		return nil, nil
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.apiReader.Get(ctx, types.NamespacedName{Namespace: t.Namespace, Name: m.SecretRef.Name}, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// pendingDependencies checks if any of the values required to capture the supplied metric are still being collected.
+func pendingDependencies(t *optimizev1beta2.Trial, m *optimizev1beta2.Metric) (bool, error) {
+	deps, err := metric.Dependencies(m)
+	if err != nil {
+		return false, err
+	}
+
+	values := make(map[string]*optimizev1beta2.Value, len(t.Spec.Values))
+	for i := range t.Spec.Values {
+		values[t.Spec.Values[i].Name] = &t.Spec.Values[i]
+	}
+
+	for _, name := range deps {
+		v, ok := values[name]
+		if !ok {
+			return false, fmt.Errorf("metric %q references unknown metric %q", m.Name, name)
+		}
+		if v.AttemptsRemaining > 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
 // applyMetricDefaults fills in default values for the supplied metric.
 func (r *MetricReconciler) applyMetricDefaults(ctx context.Context, t *optimizev1beta2.Trial, m *optimizev1beta2.Metric) error {
-	// Give Prometheus metrics a default URL
-	if m.Type == optimizev1beta2.MetricPrometheus && m.URL == "" {
-		m.URL = fmt.Sprintf("http://optimize-%[1]s-prometheus.%[1]s:9090/", t.Namespace)
+	// Allow the metric provider to fill in type specific defaults
+	if err := metric.ApplyDefaults(t, m); err != nil {
+		return err
 	}
 
 	if m.Target != nil {
@@ -0,0 +1,244 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	"github.com/thestormforge/optimize-controller/v2/internal/trial"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestMetricReconciler_CollectMetrics(t *testing.T) {
+	// Slow responses are used to verify the values are captured concurrently
+	var throttled int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+			_, _ = w.Write([]byte(`{"value":2}`))
+		case "/throttled":
+			if atomic.AddInt32(&throttled, 1) <= 2 {
+				w.Header().Set("Retry-After", "30")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			_, _ = w.Write([]byte(`{"value":3}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, optimizev1beta2.AddToScheme(scheme))
+
+	now := metav1.Now()
+	start := metav1.NewTime(now.Add(-10 * time.Second))
+	exp := &optimizev1beta2.Experiment{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-exp", Namespace: "default"},
+		Spec: optimizev1beta2.ExperimentSpec{
+			Metrics: []optimizev1beta2.Metric{
+				{Name: "ratio", Type: optimizev1beta2.MetricDerived, Query: "slow1 / duration"},
+				{Name: "duration", Query: "{{ duration .StartTime .CompletionTime }}"},
+				{Name: "slow1", Type: optimizev1beta2.MetricJSONPath, URL: srv.URL + "/slow", Query: "{.value}"},
+				{Name: "slow2", Type: optimizev1beta2.MetricJSONPath, URL: srv.URL + "/slow", Query: "{.value}"},
+				{Name: "slow3", Type: optimizev1beta2.MetricJSONPath, URL: srv.URL + "/slow", Query: "{.value}"},
+				{Name: "throttled", Type: optimizev1beta2.MetricJSONPath, URL: srv.URL + "/throttled", Query: "{.value}"},
+			},
+		},
+	}
+	t0 := &optimizev1beta2.Trial{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-trial",
+			Namespace: "default",
+			UID:       "1234",
+			Labels:    map[string]string{optimizev1beta2.LabelExperiment: "my-exp"},
+		},
+		Status: optimizev1beta2.TrialStatus{
+			StartTime:      &start,
+			CompletionTime: &now,
+		},
+	}
+
+	c := fake.NewFakeClientWithScheme(scheme, exp, t0)
+	r := &MetricReconciler{
+		Client:    c,
+		Log:       zap.New(zap.UseDevMode(true)),
+		Scheme:    scheme,
+		apiReader: c,
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "my-trial"}}
+	var tt *optimizev1beta2.Trial
+
+	// The first reconcile just initializes the values
+	_, err := r.Reconcile(req)
+	require.NoError(t, err)
+	tt = &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(context.TODO(), req.NamespacedName, tt))
+	require.Len(t, tt.Spec.Values, 6)
+
+	// The second reconcile captures everything it can in one pass
+	started := time.Now()
+	result, err := r.Reconcile(req)
+	require.NoError(t, err)
+	assert.Less(t, int64(time.Since(started)), int64(500*time.Millisecond), "values should be captured concurrently")
+	assert.Equal(t, 30*time.Second, result.RequeueAfter)
+
+	tt = &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(context.TODO(), req.NamespacedName, tt))
+	assert.Equal(t, []optimizev1beta2.Value{
+		{Name: "ratio", Value: "0.2"},
+		{Name: "duration", Value: "10"},
+		{Name: "slow1", Value: "2"},
+		{Name: "slow2", Value: "2"},
+		{Name: "slow3", Value: "2"},
+		{Name: "throttled", AttemptsRemaining: 3},
+	}, tt.Spec.Values)
+	assert.True(t, trial.CheckCondition(&tt.Status, optimizev1beta2.TrialObserved, corev1.ConditionFalse))
+
+	// The throttled value is not retried until the requested delay has passed
+	result, err = r.Reconcile(req)
+	require.NoError(t, err)
+	assert.InDelta(t, float64(30*time.Second), float64(result.RequeueAfter), float64(5*time.Second))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&throttled))
+
+	// A retry that is throttled again does not update the trial
+	r.retryTimes = nil
+	result, err = r.Reconcile(req)
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, result.RequeueAfter)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&throttled))
+	retried := &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(context.TODO(), req.NamespacedName, retried))
+	assert.Equal(t, tt.ResourceVersion, retried.ResourceVersion)
+
+	r.retryTimes = nil
+	_, err = r.Reconcile(req)
+	require.NoError(t, err)
+	tt = &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(context.TODO(), req.NamespacedName, tt))
+	assert.Equal(t, optimizev1beta2.Value{Name: "throttled", Value: "3"}, tt.Spec.Values[5])
+
+	// Once everything is collected, the trial is observed
+	_, err = r.Reconcile(req)
+	require.NoError(t, err)
+	tt = &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(context.TODO(), req.NamespacedName, tt))
+	assert.True(t, trial.CheckCondition(&tt.Status, optimizev1beta2.TrialObserved, corev1.ConditionTrue))
+
+	// Retry delays are discarded once the trial is observed
+	r.setRetryAfter(tt, "throttled", time.Now(), time.Minute)
+	_, err = r.Reconcile(req)
+	require.NoError(t, err)
+	assert.Empty(t, r.retryTimes)
+}
+
+func TestMetricReconciler_CollectMetricsSecret(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api_key") != "testApiKey" || r.URL.Query().Get("application_key") != "testAppKey" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"ok","series":[{"pointlist":[[1595471900000,2],[1595471910000,4]]}]}`))
+	}))
+	defer srv.Close()
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, optimizev1beta2.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	now := metav1.Now()
+	start := metav1.NewTime(now.Add(-10 * time.Second))
+	exp := &optimizev1beta2.Experiment{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-exp", Namespace: "default"},
+		Spec: optimizev1beta2.ExperimentSpec{
+			Metrics: []optimizev1beta2.Metric{
+				{Name: "cpu", Type: optimizev1beta2.MetricDatadog, URL: srv.URL, Query: "avg:cpu{*}", SecretRef: &corev1.LocalObjectReference{Name: "datadog"}},
+				{Name: "missing", Type: optimizev1beta2.MetricDatadog, URL: srv.URL, Query: "avg:cpu{*}", SecretRef: &corev1.LocalObjectReference{Name: "missing"}},
+			},
+		},
+	}
+	t0 := &optimizev1beta2.Trial{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-trial",
+			Namespace: "default",
+			Labels:    map[string]string{optimizev1beta2.LabelExperiment: "my-exp"},
+		},
+		Status: optimizev1beta2.TrialStatus{
+			StartTime:      &start,
+			CompletionTime: &now,
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "datadog", Namespace: "default"},
+		Data: map[string][]byte{
+			"DATADOG_API_KEY": []byte("testApiKey"),
+			"DATADOG_APP_KEY": []byte("testAppKey"),
+		},
+	}
+
+	// Secrets are not cached, they are only available through the API reader
+	c := fake.NewFakeClientWithScheme(scheme, exp, t0)
+	r := &MetricReconciler{
+		Client:    c,
+		Log:       zap.New(zap.UseDevMode(true)),
+		Scheme:    scheme,
+		apiReader: fake.NewFakeClientWithScheme(scheme, secret),
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "my-trial"}}
+
+	_, err := r.Reconcile(req)
+	require.NoError(t, err)
+	_, err = r.Reconcile(req)
+	require.NoError(t, err)
+
+	tt := &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(context.TODO(), req.NamespacedName, tt))
+	assert.Equal(t, []optimizev1beta2.Value{
+		{Name: "cpu", Value: "3"},
+		{Name: "missing", AttemptsRemaining: 2},
+	}, tt.Spec.Values)
+}
+
+func TestMetricReconciler_IgnoreTrial(t *testing.T) {
+	now := metav1.Now()
+	r := &MetricReconciler{}
+
+	running := &optimizev1beta2.Trial{Status: optimizev1beta2.TrialStatus{StartTime: &now}}
+	assert.True(t, r.ignoreTrial(running), "running trials without sampled metrics are ignored")
+
+	running.Annotations = map[string]string{optimizev1beta2.AnnotationMetricSamples: "{}"}
+	assert.False(t, r.ignoreTrial(running), "running trials with sampled metrics are reconciled")
+
+	completed := &optimizev1beta2.Trial{Status: optimizev1beta2.TrialStatus{StartTime: &now, CompletionTime: &now}}
+	assert.False(t, r.ignoreTrial(completed), "completed trials are reconciled until observed")
+}
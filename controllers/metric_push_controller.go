@@ -0,0 +1,214 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	"github.com/thestormforge/optimize-controller/v2/internal/controller"
+	"github.com/thestormforge/optimize-controller/v2/internal/metric"
+	"github.com/thestormforge/optimize-controller/v2/internal/setup"
+	"github.com/thestormforge/optimize-controller/v2/internal/trial"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxPushBodySize limits the size of a single request to the metric push endpoint.
+const maxPushBodySize = 1 << 20
+
+// MetricPushServer accepts metric values POSTed by trial jobs. Values are recorded on the trial so they can be captured
+// by the "push" metric type.
+//
+// NOTE: The endpoint is plain HTTP, the bearer token is only protected by the cluster network; do not expose the push
+// service outside of the cluster and use a network policy if other workloads in the cluster are not trusted.
+type MetricPushServer struct {
+	client.Client
+	Log  logr.Logger
+	Addr string
+
+	apiReader client.Reader
+}
+
+// +kubebuilder:rbac:groups=optimize.stormforge.io,resources=trials,verbs=get;update
+
+func (s *MetricPushServer) SetupWithManager(mgr ctrl.Manager) error {
+	if s.Addr == "" {
+		s.Log.Info("Metric push endpoint is disabled")
+		return nil
+	}
+
+	s.apiReader = mgr.GetAPIReader()
+	return mgr.Add(s)
+}
+
+// Start listens for pushed metric values until the supplied channel is closed.
+// Start satisfies the controller-runtime/manager.Runnable interface.
+func (s *MetricPushServer) Start(ch <-chan struct{}) error {
+	s.Log.Info("Starting metric push endpoint", "addr", s.Addr)
+
+	srv := &http.Server{
+		Addr:         s.Addr,
+		Handler:      s,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ch
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// NeedLeaderElection allows every replica to accept pushed values, all updates go through the API server. The push
+// service may route a request to any replica so each of them must be able to read the trial secrets.
+func (s *MetricPushServer) NeedLeaderElection() bool {
+	return false
+}
+
+// ServeHTTP records the values in a JSON object (e.g. `{"throughput": 1234.5}`) on the trial identified by the path.
+func (s *MetricPushServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	nn, ok := parseMetricPushPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	values := make(map[string]float64)
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPushBodySize)).Decode(&values); err != nil {
+		http.Error(w, fmt.Sprintf("invalid metric values: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if len(values) == 0 {
+		http.Error(w, "no metric values", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	status, message := http.StatusNoContent, ""
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		t := &optimizev1beta2.Trial{}
+		if err := s.apiReader.Get(ctx, nn, t); err != nil {
+			return err
+		}
+
+		expected, err := s.metricsToken(ctx, t)
+		if err != nil {
+			return err
+		}
+		if len(expected) == 0 || subtle.ConstantTimeCompare([]byte(token), expected) != 1 {
+			status, message = http.StatusForbidden, "invalid bearer token"
+			return nil
+		}
+
+		if trial.CheckCondition(&t.Status, optimizev1beta2.TrialObserved, corev1.ConditionTrue) ||
+			trial.CheckCondition(&t.Status, optimizev1beta2.TrialFailed, corev1.ConditionTrue) {
+			status, message = http.StatusConflict, "trial is no longer accepting metric values"
+			return nil
+		}
+
+		if err := metric.PushValues(t, values); err != nil {
+			status, message = http.StatusBadRequest, err.Error()
+			return nil
+		}
+
+		return s.Update(ctx, t)
+	})
+
+	switch {
+	case apierrs.IsNotFound(err):
+		http.NotFound(w, r)
+	case err != nil:
+		s.Log.Error(err, "Failed to record pushed metric values", "trial", nn.String())
+		http.Error(w, "unable to record metric values", http.StatusInternalServerError)
+	case message != "":
+		http.Error(w, message, status)
+	default:
+		w.WriteHeader(status)
+	}
+}
+
+// metricsToken returns the bearer token expected from the trial job, or nil if the trial job was not given one.
+func (s *MetricPushServer) metricsToken(ctx context.Context, t *optimizev1beta2.Trial) ([]byte, error) {
+	name := t.Annotations[optimizev1beta2.AnnotationMetricsSecret]
+	if name == "" {
+		return nil, nil
+	}
+
+	// RBAC: We assume that we have "get" permission from a customer defined role, same as creating the secret
+	secret := &corev1.Secret{}
+	if err := s.apiReader.Get(ctx, types.NamespacedName{Namespace: t.Namespace, Name: name}, secret); err != nil {
+		return nil, controller.IgnoreNotFound(err)
+	}
+	return secret.Data[setup.MetricsTokenKey], nil
+}
+
+// metricPushPath returns the path of the metric push endpoint for a trial.
+func metricPushPath(t *optimizev1beta2.Trial) string {
+	return fmt.Sprintf("/namespaces/%s/trials/%s/metrics", t.Namespace, t.Name)
+}
+
+// parseMetricPushPath returns the trial name from a metric push endpoint path.
+func parseMetricPushPath(path string) (types.NamespacedName, bool) {
+	p := strings.Split(strings.Trim(path, "/"), "/")
+	if len(p) != 5 || p[0] != "namespaces" || p[2] != "trials" || p[4] != "metrics" || p[1] == "" || p[3] == "" {
+		return types.NamespacedName{}, false
+	}
+	return types.NamespacedName{Namespace: p[1], Name: p[3]}, true
+}
+
+// newMetricsToken returns a new random bearer token for pushing metric values.
+func newMetricsToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
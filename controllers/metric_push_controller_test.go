@@ -0,0 +1,98 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	"github.com/thestormforge/optimize-controller/v2/internal/setup"
+	"github.com/thestormforge/optimize-controller/v2/internal/trial"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestMetricPushServer(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, optimizev1beta2.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewFakeClientWithScheme(scheme,
+		&optimizev1beta2.Trial{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "my-trial",
+				Namespace:   "default",
+				Annotations: map[string]string{optimizev1beta2.AnnotationMetricsSecret: "my-trial-metrics"},
+			},
+		},
+		&optimizev1beta2.Trial{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "missing-secret",
+				Namespace:   "default",
+				Annotations: map[string]string{optimizev1beta2.AnnotationMetricsSecret: "missing-secret-metrics"},
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-trial-metrics", Namespace: "default"},
+			Data:       map[string][]byte{setup.MetricsTokenKey: []byte("s3cr3t")},
+		},
+	)
+	s := &MetricPushServer{Client: c, Log: zap.New(zap.UseDevMode(true)), apiReader: c}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	push := func(method, path, token, body string) int {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	const path = "/namespaces/default/trials/my-trial/metrics"
+	assert.Equal(t, http.StatusMethodNotAllowed, push(http.MethodGet, path, "s3cr3t", ""))
+	assert.Equal(t, http.StatusNotFound, push(http.MethodPost, "/trials/my-trial", "s3cr3t", `{"rps":1}`))
+	assert.Equal(t, http.StatusUnauthorized, push(http.MethodPost, path, "", `{"rps":1}`))
+	assert.Equal(t, http.StatusForbidden, push(http.MethodPost, path, "guess", `{"rps":1}`))
+	assert.Equal(t, http.StatusNotFound, push(http.MethodPost, "/namespaces/default/trials/other/metrics", "s3cr3t", `{"rps":1}`))
+	assert.Equal(t, http.StatusForbidden, push(http.MethodPost, "/namespaces/default/trials/missing-secret/metrics", "s3cr3t", `{"rps":1}`))
+	assert.Equal(t, http.StatusBadRequest, push(http.MethodPost, path, "s3cr3t", `{"rps":"fast"}`))
+	assert.Equal(t, http.StatusNoContent, push(http.MethodPost, path, "s3cr3t", `{"rps":120.5,"errors":2}`))
+
+	tt := &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "my-trial"}, tt))
+	assert.JSONEq(t, `{"rps":120.5,"errors":2}`, tt.Annotations[optimizev1beta2.AnnotationPushedMetrics])
+
+	// Values are rejected once the metrics have been collected
+	trial.ApplyCondition(&tt.Status, optimizev1beta2.TrialObserved, corev1.ConditionTrue, "", "", nil)
+	require.NoError(t, c.Update(context.TODO(), tt))
+	assert.Equal(t, http.StatusConflict, push(http.MethodPost, path, "s3cr3t", `{"rps":130}`))
+}
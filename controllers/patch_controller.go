@@ -17,23 +17,38 @@ limitations under the License.
 package controllers
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"sort"
+	"time"
 
 	"github.com/go-logr/logr"
 	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
 	"github.com/thestormforge/optimize-controller/v2/internal/controller"
+	"github.com/thestormforge/optimize-controller/v2/internal/meta"
 	"github.com/thestormforge/optimize-controller/v2/internal/patch"
 	"github.com/thestormforge/optimize-controller/v2/internal/ready"
 	"github.com/thestormforge/optimize-controller/v2/internal/template"
 	"github.com/thestormforge/optimize-controller/v2/internal/trial"
 	"github.com/thestormforge/optimize-controller/v2/internal/validation"
 	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// driftCheckInterval is how often patched objects are checked for drift while the trial is running
+	driftCheckInterval = 30 * time.Second
+	// driftRecordInterval is how often the same drift is recorded again for a patched object
+	driftRecordInterval = 5 * time.Minute
 )
 
 // PatchReconciler reconciles the patches on a Trial object
@@ -41,10 +56,15 @@ type PatchReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// Keep the raw API reader for looking up objects from patch templates, using the caching reader would require
+	// list/watch permissions on anything a template might reference.
+	apiReader client.Reader
 }
 
 // +kubebuilder:rbac:groups=optimize.stormforge.io,resources=experiments,verbs=get;list;watch
 // +kubebuilder:rbac:groups=optimize.stormforge.io,resources=trials,verbs=get;list;watch;update
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;create;update
 
 // Reconcile inspects a trial to see if patches need to be applied. The "trial patched" status condition
 // is used to control what actions need to be taken. If the status is "unknown" then the experiment is fetched
@@ -56,10 +76,22 @@ func (r *PatchReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	now := metav1.Now()
 
 	t := &optimizev1beta2.Trial{}
-	if err := r.Get(ctx, req.NamespacedName, t); err != nil || r.ignoreTrial(t) {
+	if err := r.Get(ctx, req.NamespacedName, t); err != nil {
 		return ctrl.Result{}, controller.IgnoreNotFound(err)
 	}
 
+	if result, err := r.restorePatches(ctx, t, &now); result != nil {
+		return *result, err
+	}
+
+	if result, err := r.checkDrift(ctx, t, &now); result != nil {
+		return *result, err
+	}
+
+	if r.ignoreTrial(t) {
+		return ctrl.Result{}, nil
+	}
+
 	if result, err := r.evaluatePatchOperations(ctx, t, &now); result != nil {
 		return *result, err
 	}
@@ -73,6 +105,7 @@ func (r *PatchReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 // SetupWithManager registers a new patch reconciler with the supplied manager
 func (r *PatchReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.apiReader = mgr.GetAPIReader()
 	return ctrl.NewControllerManagedBy(mgr).
 		Named("patch").
 		For(&optimizev1beta2.Trial{}).
@@ -134,40 +167,76 @@ func (r *PatchReconciler) evaluatePatchOperations(ctx context.Context, t *optimi
 	t.Status.ReadinessChecks = nil
 
 	// Evaluate the patches
-	te := template.New()
+	te := template.New().WithExperiment(exp).WithLookup(ctx, r.apiReader)
 	for i := range exp.Spec.Patches {
-		p := &exp.Spec.Patches[i]
-
-		// Render the patch template
-		ref, data, err := patch.RenderTemplate(te, t, p)
-		if err != nil {
+		// Expand the patch template to the objects matching its selector
+		pts, err := patch.ExpandTemplate(ctx, r.apiReader, t, &exp.Spec.Patches[i])
+		if errors.Is(err, patch.ErrNoMatchingTargets) {
+			// Running the trial without the patch would produce misleading results, fail the trial instead
+			trial.ApplyCondition(&t.Status, optimizev1beta2.TrialFailed, corev1.ConditionTrue, "NoPatchTargets", err.Error(), probeTime)
+			err := r.Update(ctx, t)
+			return controller.RequeueConflict(err)
+		} else if err != nil {
 			return &ctrl.Result{}, err
 		}
 
-		// Add a patch operation if necessary
-		if po, err := patch.CreatePatchOperation(t, p, ref, data); err != nil {
-			return &ctrl.Result{}, err
-		} else if po != nil {
-			t.Status.PatchOperations = append(t.Status.PatchOperations, *po)
-		}
+		for j := range pts {
+			p := &pts[j]
 
-		// Add a readiness check if necessary
-		if rc, err := r.createReadinessCheck(t, ref, p.ReadinessGates); err != nil {
-			return &ctrl.Result{}, err
-		} else if rc != nil {
-			t.Status.ReadinessChecks = append(t.Status.ReadinessChecks, *rc)
+			// Render the patch template
+			ref, data, err := patch.RenderTemplate(te, t, p)
+			if err != nil {
+				return &ctrl.Result{}, err
+			}
+
+			// Add a patch operation if necessary
+			if po, err := patch.CreatePatchOperation(t, p, ref, data); err != nil {
+				return &ctrl.Result{}, err
+			} else if po != nil {
+				po.Wave = p.Wave
+				t.Status.PatchOperations = append(t.Status.PatchOperations, *po)
+
+				// Running pods do not see changes to configuration objects, restart the workloads that consume them
+				if isConfigReference(ref) {
+					if err := r.restartConsumers(ctx, t, ref, p.Wave, probeTime); err != nil {
+						return &ctrl.Result{}, err
+					}
+				}
+			}
+
+			// Add a readiness check if necessary
+			if rc, err := r.createReadinessCheck(t, ref, p.ReadinessGates); err != nil {
+				return &ctrl.Result{}, err
+			} else if rc != nil {
+				rc.Wave = p.Wave
+				t.Status.ReadinessChecks = append(t.Status.ReadinessChecks, *rc)
+			}
 		}
 	}
 
-	// Sort the patch operations so configuration patches are applied first
+	// Sort the patch operations by wave so configuration patches are applied first within each wave
 	sort.SliceStable(t.Status.PatchOperations, func(i, j int) bool {
-		return isConfigReference(&t.Status.PatchOperations[i].TargetRef) &&
-			!isConfigReference(&t.Status.PatchOperations[j].TargetRef)
+		pi, pj := &t.Status.PatchOperations[i], &t.Status.PatchOperations[j]
+		if pi.Wave != pj.Wave {
+			return pi.Wave < pj.Wave
+		}
+		return isConfigReference(&pi.TargetRef) && !isConfigReference(&pj.TargetRef)
 	})
 
-	// Add back any pre-existing readiness checks
+	// Add back any pre-existing readiness checks, moving them to the last wave so they do not hold back any patches
+	if n := len(t.Status.PatchOperations); n > 0 {
+		for i := range readinessChecks {
+			readinessChecks[i].Wave = t.Status.PatchOperations[n-1].Wave
+		}
+	}
 	t.Status.ReadinessChecks = append(t.Status.ReadinessChecks, readinessChecks...)
 
+	// Patched objects must be restored before the trial is considered inactive
+	if exp.Spec.RestorePolicy == optimizev1beta2.RestoreTrial && len(t.Status.PatchOperations) > 0 {
+		meta.AddFinalizer(t, patch.RestoreFinalizer)
+		trial.ApplyCondition(&t.Status, optimizev1beta2.TrialRestored, corev1.ConditionUnknown, "", "", probeTime)
+	}
+
 	// Update the status to indicate that patches are evaluated
 	trial.ApplyCondition(&t.Status, optimizev1beta2.TrialPatched, corev1.ConditionFalse, "", "", probeTime)
 	err := r.Update(ctx, t)
@@ -181,6 +250,12 @@ func (r *PatchReconciler) applyPatches(ctx context.Context, t *optimizev1beta2.T
 		return nil, nil
 	}
 
+	// Get the experiment to determine if the original state of the patch targets must be recorded
+	exp := &optimizev1beta2.Experiment{}
+	if err := r.Get(ctx, t.ExperimentNamespacedName(), exp); err != nil {
+		return &ctrl.Result{}, err
+	}
+
 	// Iterate over the patches, looking for remaining attempts
 	for i := range t.Status.PatchOperations {
 		p := &t.Status.PatchOperations[i]
@@ -188,13 +263,35 @@ func (r *PatchReconciler) applyPatches(ctx context.Context, t *optimizev1beta2.T
 			continue
 		}
 
+		// Wait for the readiness checks of the lower waves, the ready reconciler will update the trial when they pass
+		if !trial.IsWaveReady(t, p.Wave) {
+			return &ctrl.Result{}, nil
+		}
+
+		// Record the original state of the target before the first time it is patched
+		var err error
+		if patch.NeedsSnapshot(exp) && patch.IsSnapshotSupported(&p.TargetRef) {
+			err = r.snapshot(ctx, exp, &p.TargetRef)
+		}
+
 		// Construct a patch on an unstructured object
 		// RBAC: We assume that we have "patch" permission from a customer defined role so we do not limit what types we can patch
 		u := &unstructured.Unstructured{}
 		u.SetName(p.TargetRef.Name)
 		u.SetNamespace(p.TargetRef.Namespace)
 		u.SetGroupVersionKind(p.TargetRef.GroupVersionKind())
-		if err := r.Patch(ctx, u, client.RawPatch(p.PatchType, p.Data)); err != nil {
+		if err == nil {
+			err = r.Patch(ctx, u, client.RawPatch(p.PatchType, p.Data), client.FieldOwner(patch.FieldManager))
+		}
+		if err != nil && p.PatchType == types.ApplyPatchType && apierrs.IsConflict(err) {
+			// Retrying will not resolve a conflict with another field manager, fail the trial
+			p.AttemptsRemaining = 0
+			trial.ApplyCondition(&t.Status, optimizev1beta2.TrialFailed, corev1.ConditionTrue, "PatchConflict", err.Error(), probeTime)
+		} else if errors.Is(err, patch.ErrSnapshotTooLarge) {
+			// Retrying will not make the target smaller, fail the trial instead of patching an object we cannot restore
+			p.AttemptsRemaining = 0
+			trial.ApplyCondition(&t.Status, optimizev1beta2.TrialFailed, corev1.ConditionTrue, "SnapshotTooLarge", err.Error(), probeTime)
+		} else if err != nil {
 			p.AttemptsRemaining = p.AttemptsRemaining - 1
 			if p.AttemptsRemaining == 0 {
 				// There are no remaining patch attempts remaining, fail the trial
@@ -202,10 +299,11 @@ func (r *PatchReconciler) applyPatches(ctx context.Context, t *optimizev1beta2.T
 			}
 		} else {
 			p.AttemptsRemaining = 0
+			p.AppliedTime = probeTime.DeepCopy()
 		}
 
 		// Update the patch operation status
-		err := r.Update(ctx, t)
+		err = r.Update(ctx, t)
 		return controller.RequeueConflict(err)
 	}
 
@@ -215,6 +313,219 @@ func (r *PatchReconciler) applyPatches(ctx context.Context, t *optimizev1beta2.T
 	return controller.RequeueConflict(err)
 }
 
+// snapshot records the original state of a patch target, only the first recorded state of each target is kept
+func (r *PatchReconciler) snapshot(ctx context.Context, exp *optimizev1beta2.Experiment, ref *corev1.ObjectReference) error {
+	// RBAC: The "get" permission required to patch the target is also sufficient to record it
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(ref.GroupVersionKind())
+	if err := r.apiReader.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, u); err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := r.apiReader.Get(ctx, patch.SnapshotNamespacedName(types.NamespacedName{Namespace: exp.Namespace, Name: exp.Name}), cm); apierrs.IsNotFound(err) {
+		cm = patch.NewSnapshot(exp)
+		if err := controllerutil.SetControllerReference(exp, cm, r.Scheme); err != nil {
+			return err
+		}
+		if _, err := patch.AddSnapshot(cm, u); err != nil {
+			return err
+		}
+		return r.Create(ctx, cm)
+	} else if err != nil {
+		return err
+	}
+
+	if added, err := patch.AddSnapshot(cm, u); err != nil || !added {
+		return err
+	}
+	return r.Update(ctx, cm)
+}
+
+// restorePatches returns the objects patched for a finished (or deleted) trial to their original state
+func (r *PatchReconciler) restorePatches(ctx context.Context, t *optimizev1beta2.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
+	// Only restore trials which are no longer running and have not been restored yet
+	if !meta.HasFinalizer(t, patch.RestoreFinalizer) || (!trial.IsFinished(t) && t.DeletionTimestamp.IsZero()) {
+		return nil, nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := r.apiReader.Get(ctx, patch.SnapshotNamespacedName(t.ExperimentNamespacedName()), cm); controller.IgnoreNotFound(err) != nil {
+		return &ctrl.Result{}, err
+	}
+
+	for i := range t.Status.PatchOperations {
+		original, err := patch.Snapshot(cm, &t.Status.PatchOperations[i].TargetRef)
+		if err != nil {
+			return &ctrl.Result{}, err
+		}
+		if original == nil {
+			continue
+		}
+		if err := patch.Restore(ctx, r.apiReader, r, original); err != nil {
+			return &ctrl.Result{}, err
+		}
+	}
+
+	trial.ApplyCondition(&t.Status, optimizev1beta2.TrialRestored, corev1.ConditionTrue, "", "", probeTime)
+	meta.RemoveFinalizer(t, patch.RestoreFinalizer)
+	err := r.Update(ctx, t)
+	return controller.RequeueConflict(err)
+}
+
+// checkDrift verifies that the patched objects still match the patches while the trial is running
+func (r *PatchReconciler) checkDrift(ctx context.Context, t *optimizev1beta2.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
+	// Only check trials which are running
+	if !t.DeletionTimestamp.IsZero() || trial.IsFinished(t) || !trial.CheckCondition(&t.Status, optimizev1beta2.TrialReady, corev1.ConditionTrue) {
+		return nil, nil
+	}
+
+	// Get the experiment to determine the drift policy
+	exp := &optimizev1beta2.Experiment{}
+	if err := r.Get(ctx, t.ExperimentNamespacedName(), exp); err != nil {
+		return &ctrl.Result{}, controller.IgnoreNotFound(err)
+	}
+	if exp.Spec.DriftPolicy == "" || exp.Spec.DriftPolicy == optimizev1beta2.DriftIgnore {
+		return nil, nil
+	}
+
+	var updated bool
+	for i := range t.Status.PatchOperations {
+		p := &t.Status.PatchOperations[i]
+		if trial.IsTrialJobReference(t, &p.TargetRef) {
+			continue
+		}
+
+		// RBAC: We assume that we have "get" permission from a customer defined role, same as applying the patch
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(p.TargetRef.GroupVersionKind())
+		if err := r.apiReader.Get(ctx, client.ObjectKey{Namespace: p.TargetRef.Namespace, Name: p.TargetRef.Name}, u); err != nil {
+			if controller.IgnoreNotFound(err) != nil {
+				return &ctrl.Result{}, err
+			}
+			continue
+		}
+
+		data, err := patch.Drift(u, p)
+		if err != nil {
+			return &ctrl.Result{}, err
+		}
+		if data == nil {
+			continue
+		}
+
+		// Persistent drift (or a controller reverting the patch) would otherwise update the trial on every pass
+		msg := fmt.Sprintf("%s %q no longer matches the patch: %s", p.TargetRef.Kind, p.TargetRef.Name, data)
+		if exp.Spec.DriftPolicy == optimizev1beta2.DriftFail || !recentDrift(t, &p.TargetRef, msg, probeTime) {
+			updated = true
+			recordDrift(t, &p.TargetRef, msg, probeTime)
+			r.Log.Info("Patch drift detected", "trial", t.Name, "namespace", t.Namespace, "kind", p.TargetRef.Kind, "name", p.TargetRef.Name)
+		}
+
+		switch exp.Spec.DriftPolicy {
+		case optimizev1beta2.DriftFail:
+			trial.ApplyCondition(&t.Status, optimizev1beta2.TrialFailed, corev1.ConditionTrue, "PatchDrift", msg, probeTime)
+		case optimizev1beta2.DriftReapply:
+			if err := r.reapplyPatch(ctx, u, p, data); err != nil {
+				updated = true
+				trial.ApplyCondition(&t.Status, optimizev1beta2.TrialFailed, corev1.ConditionTrue, "PatchDrift", err.Error(), probeTime)
+			}
+		}
+	}
+
+	// Record the drift
+	if updated {
+		if err := r.Update(ctx, t); err != nil {
+			return controller.RequeueConflict(err)
+		}
+	}
+
+	// Check again later since we are not watching the patched objects
+	return &ctrl.Result{RequeueAfter: driftCheckInterval}, nil
+}
+
+// reapplyPatch brings a drifted object back to the patched state
+func (r *PatchReconciler) reapplyPatch(ctx context.Context, u *unstructured.Unstructured, p *optimizev1beta2.PatchOperation, drift []byte) error {
+	switch p.PatchType {
+	case types.JSONPatchType:
+		// JSON patches are not idempotent (e.g. appending to an array), only restore the drifted fields
+		return r.Patch(ctx, u, client.RawPatch(types.MergePatchType, drift), client.FieldOwner(patch.FieldManager))
+	case types.ApplyPatchType:
+		// Whoever changed the object now owns the drifted fields, without forcing the apply would always conflict
+		return r.Patch(ctx, u, client.RawPatch(p.PatchType, p.Data), client.FieldOwner(patch.FieldManager), client.ForceOwnership)
+	default:
+		return r.Patch(ctx, u, client.RawPatch(p.PatchType, p.Data), client.FieldOwner(patch.FieldManager))
+	}
+}
+
+// recentDrift checks if the same drift was already recorded for a patched object within the drift record interval
+func recentDrift(t *optimizev1beta2.Trial, ref *corev1.ObjectReference, msg string, probeTime *metav1.Time) bool {
+	for i := range t.Status.PatchDrift {
+		if t.Status.PatchDrift[i].TargetRef == *ref {
+			return t.Status.PatchDrift[i].Message == msg &&
+				probeTime.Sub(t.Status.PatchDrift[i].LastDetectionTime.Time) < driftRecordInterval
+		}
+	}
+	return false
+}
+
+// recordDrift adds or updates the drift status for a patched object
+func recordDrift(t *optimizev1beta2.Trial, ref *corev1.ObjectReference, msg string, probeTime *metav1.Time) {
+	for i := range t.Status.PatchDrift {
+		if t.Status.PatchDrift[i].TargetRef == *ref {
+			t.Status.PatchDrift[i].Count++
+			t.Status.PatchDrift[i].LastDetectionTime = *probeTime
+			t.Status.PatchDrift[i].Message = msg
+			return
+		}
+	}
+
+	t.Status.PatchDrift = append(t.Status.PatchDrift, optimizev1beta2.PatchDrift{
+		TargetRef:         *ref,
+		Count:             1,
+		LastDetectionTime: *probeTime,
+		Message:           msg,
+	})
+}
+
+// restartConsumers adds patch operations and readiness checks to roll out the workloads consuming a configuration object
+func (r *PatchReconciler) restartConsumers(ctx context.Context, t *optimizev1beta2.Trial, ref *corev1.ObjectReference, wave int32, probeTime *metav1.Time) error {
+	refs, err := patch.FindConsumers(ctx, r.apiReader, ref)
+	if apierrs.IsForbidden(err) {
+		// Without permission to list the workloads we cannot restart them, the trial may not see the patched values
+		r.Log.Info("Unable to find consumers of patched configuration", "kind", ref.Kind, "name", ref.Name, "error", err.Error())
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for i := range refs {
+		po, err := patch.CreateRestartOperation(&refs[i], probeTime)
+		if err != nil {
+			return err
+		}
+		po.Wave = wave
+
+		// Multiple configuration objects may be consumed by the same workload, it only needs to be restarted once
+		if !hasPatchOperation(t, po) {
+			t.Status.PatchOperations = append(t.Status.PatchOperations, *po)
+		}
+		if hasReadinessCheck(t, &refs[i]) {
+			continue
+		}
+
+		// Wait for the restarted workload using the default readiness gates
+		if rc, err := r.createReadinessCheck(t, &refs[i], nil); err != nil {
+			return err
+		} else if rc != nil {
+			rc.Wave = wave
+			t.Status.ReadinessChecks = append(t.Status.ReadinessChecks, *rc)
+		}
+	}
+
+	return nil
+}
+
 // createReadinessCheck creates a readiness check for a patch operation
 func (r *PatchReconciler) createReadinessCheck(t *optimizev1beta2.Trial, ref *corev1.ObjectReference, readinessGates []optimizev1beta2.PatchReadinessGate) (*optimizev1beta2.ReadinessCheck, error) {
 	// Do not create a readiness check on the trial job or if there is already an explicit readiness gate
@@ -234,6 +545,9 @@ func (r *PatchReconciler) createReadinessCheck(t *optimizev1beta2.Trial, ref *co
 	// Add configured and default readiness conditions
 	for i := range readinessGates {
 		rc.ConditionTypes = append(rc.ConditionTypes, readinessGates[i].ConditionType)
+		if readinessGates[i].HTTPGet != nil {
+			rc.HTTPGet = readinessGates[i].HTTPGet.DeepCopy()
+		}
 	}
 
 	// Check for a "legacy" patch that has no explicit (not even empty) readiness gates and apply settings consistent
@@ -273,6 +587,27 @@ func hasTrialReadinessGate(t *optimizev1beta2.Trial, ref *corev1.ObjectReference
 	return false
 }
 
+// hasPatchOperation checks to see if the trial already has an identical patch operation
+func hasPatchOperation(t *optimizev1beta2.Trial, po *optimizev1beta2.PatchOperation) bool {
+	for i := range t.Status.PatchOperations {
+		p := &t.Status.PatchOperations[i]
+		if p.TargetRef == po.TargetRef && p.PatchType == po.PatchType && bytes.Equal(p.Data, po.Data) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasReadinessCheck checks to see if the trial already has a readiness check for the supplied object reference
+func hasReadinessCheck(t *optimizev1beta2.Trial, ref *corev1.ObjectReference) bool {
+	for i := range t.Status.ReadinessChecks {
+		if t.Status.ReadinessChecks[i].TargetRef == *ref {
+			return true
+		}
+	}
+	return false
+}
+
 // isConfigReference returns true if the object reference points to a "configuration object". By identifying
 // configuration objects we can move them earlier in the patching process: this helps ensure subsequent objects
 // referencing the configuration objects get the correct state.
@@ -0,0 +1,532 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	"github.com/thestormforge/optimize-controller/v2/internal/meta"
+	"github.com/thestormforge/optimize-controller/v2/internal/patch"
+	"github.com/thestormforge/optimize-controller/v2/internal/trial"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestPatchReconciler_RestoreTrial(t *testing.T) {
+	now := metav1.Now()
+	exp := &optimizev1beta2.Experiment{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-exp", Namespace: "default", UID: "5678"},
+		Spec: optimizev1beta2.ExperimentSpec{
+			RestorePolicy: optimizev1beta2.RestoreTrial,
+			Parameters:    []optimizev1beta2.Parameter{{Name: "threads", Min: 1, Max: 32}},
+			Patches: []optimizev1beta2.PatchTemplate{
+				{
+					Type:           optimizev1beta2.PatchMerge,
+					TargetRef:      &corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Name: "app-config"},
+					Patch:          `{"data":{"threads":"{{ .Values.threads }}"}}`,
+					ReadinessGates: []optimizev1beta2.PatchReadinessGate{},
+				},
+			},
+		},
+	}
+	t0 := newPatchTrial(optimizev1beta2.Assignment{Name: "threads", Value: intstr.FromInt(16)})
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-config", Namespace: "default"},
+		Data:       map[string]string{"threads": "4"},
+	}
+
+	r, req := newPatchReconcilerTest(t, exp, t0, cm)
+	c := r.Client
+	ctx := context.TODO()
+
+	// Evaluate and apply the patches
+	for i := 0; i < 3; i++ {
+		_, err := r.Reconcile(req)
+		require.NoError(t, err)
+	}
+
+	tt := &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(ctx, req.NamespacedName, tt))
+	assert.True(t, trial.CheckCondition(&tt.Status, optimizev1beta2.TrialPatched, corev1.ConditionTrue))
+	assert.True(t, trial.CheckCondition(&tt.Status, optimizev1beta2.TrialRestored, corev1.ConditionUnknown))
+	assert.True(t, meta.HasFinalizer(tt, patch.RestoreFinalizer))
+
+	patched := &corev1.ConfigMap{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "app-config"}, patched))
+	assert.Equal(t, "16", patched.Data["threads"])
+
+	snapshot := &corev1.ConfigMap{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "my-exp-snapshot"}, snapshot))
+	assert.Len(t, snapshot.Data, 1)
+
+	// The trial is still active until the patched objects are restored
+	trial.ApplyCondition(&tt.Status, optimizev1beta2.TrialComplete, corev1.ConditionTrue, "", "", &now)
+	require.NoError(t, c.Update(ctx, tt))
+	assert.True(t, trial.IsActive(tt))
+
+	_, err := r.Reconcile(req)
+	require.NoError(t, err)
+
+	tt = &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(ctx, req.NamespacedName, tt))
+	assert.True(t, trial.CheckCondition(&tt.Status, optimizev1beta2.TrialRestored, corev1.ConditionTrue))
+	assert.False(t, meta.HasFinalizer(tt, patch.RestoreFinalizer))
+	assert.False(t, trial.IsActive(tt))
+
+	restored := &corev1.ConfigMap{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "app-config"}, restored))
+	assert.Equal(t, "4", restored.Data["threads"])
+}
+
+func TestPatchReconciler_Selector(t *testing.T) {
+	exp := &optimizev1beta2.Experiment{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-exp", Namespace: "default"},
+		Spec: optimizev1beta2.ExperimentSpec{
+			Parameters: []optimizev1beta2.Parameter{{Name: "heap", Min: 256, Max: 1024}},
+			Patches: []optimizev1beta2.PatchTemplate{
+				{
+					Type:      optimizev1beta2.PatchMerge,
+					TargetRef: &corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment"},
+					Selector:  &metav1.LabelSelector{MatchLabels: map[string]string{"runtime": "jvm"}},
+					Patch:     `{"metadata":{"annotations":{"heap":"{{ .Values.heap }}"}}}`,
+				},
+			},
+		},
+	}
+	t0 := newPatchTrial(optimizev1beta2.Assignment{Name: "heap", Value: intstr.FromInt(512)})
+	jvm := map[string]string{"runtime": "jvm"}
+	objs := []runtime.Object{exp, t0,
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "default", Labels: jvm}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "billing", Namespace: "default", Labels: jvm}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "default"}},
+	}
+
+	r, req := newPatchReconcilerTest(t, objs...)
+	c := r.Client
+
+	_, err := r.Reconcile(req)
+	require.NoError(t, err)
+
+	tt := &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(context.TODO(), req.NamespacedName, tt))
+
+	var patched, checked []string
+	for _, po := range tt.Status.PatchOperations {
+		patched = append(patched, po.TargetRef.Name)
+	}
+	for _, rc := range tt.Status.ReadinessChecks {
+		checked = append(checked, rc.TargetRef.Name)
+	}
+	assert.Equal(t, []string{"billing", "orders"}, patched)
+	assert.Equal(t, []string{"billing", "orders"}, checked)
+}
+
+func TestPatchReconciler_SelectorNoMatch(t *testing.T) {
+	exp := &optimizev1beta2.Experiment{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-exp", Namespace: "default"},
+		Spec: optimizev1beta2.ExperimentSpec{
+			Parameters: []optimizev1beta2.Parameter{{Name: "heap", Min: 256, Max: 1024}},
+			Patches: []optimizev1beta2.PatchTemplate{
+				{
+					Type:      optimizev1beta2.PatchMerge,
+					TargetRef: &corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment"},
+					Selector:  &metav1.LabelSelector{MatchLabels: map[string]string{"runtime": "jvm"}},
+					Patch:     `{"metadata":{"annotations":{"heap":"{{ .Values.heap }}"}}}`,
+				},
+			},
+		},
+	}
+	t0 := newPatchTrial(optimizev1beta2.Assignment{Name: "heap", Value: intstr.FromInt(512)})
+
+	r, req := newPatchReconcilerTest(t, exp, t0,
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "default"}},
+	)
+	c := r.Client
+
+	_, err := r.Reconcile(req)
+	require.NoError(t, err)
+
+	tt := &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(context.TODO(), req.NamespacedName, tt))
+	assert.True(t, trial.CheckCondition(&tt.Status, optimizev1beta2.TrialFailed, corev1.ConditionTrue))
+	assert.Empty(t, tt.Status.PatchOperations)
+}
+
+func TestPatchReconciler_Waves(t *testing.T) {
+	exp := &optimizev1beta2.Experiment{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-exp", Namespace: "default"},
+		Spec: optimizev1beta2.ExperimentSpec{
+			Parameters: []optimizev1beta2.Parameter{{Name: "connections", Min: 10, Max: 100}},
+			Patches: []optimizev1beta2.PatchTemplate{
+				{
+					Type:      optimizev1beta2.PatchMerge,
+					TargetRef: &corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Name: "app"},
+					Patch:     `{"metadata":{"annotations":{"connections":"{{ .Values.connections }}"}}}`,
+					Wave:      1,
+				},
+				{
+					Type:           optimizev1beta2.PatchMerge,
+					TargetRef:      &corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Name: "db"},
+					Patch:          `{"metadata":{"annotations":{"max-connections":"{{ .Values.connections }}"}}}`,
+					ReadinessGates: []optimizev1beta2.PatchReadinessGate{{ConditionType: "stormforge.io/status-phase-running"}},
+				},
+			},
+		},
+	}
+	t0 := newPatchTrial(optimizev1beta2.Assignment{Name: "connections", Value: intstr.FromInt(50)})
+	db := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+		Status:     corev1.PodStatus{Phase: corev1.PodPending},
+	}
+	app := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+	}
+
+	pr, req := newPatchReconcilerTest(t, exp, t0, db, app)
+	c := pr.Client
+	rr := &ReadyReconciler{Client: c, Log: pr.Log, Scheme: pr.Scheme, apiReader: c}
+	ctx := context.TODO()
+
+	// Evaluate the patches and apply the first wave, the second wave waits for the first to be ready
+	for i := 0; i < 3; i++ {
+		_, err := pr.Reconcile(req)
+		require.NoError(t, err)
+	}
+
+	tt := &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(ctx, req.NamespacedName, tt))
+	require.Len(t, tt.Status.PatchOperations, 2)
+	assert.Equal(t, "db", tt.Status.PatchOperations[0].TargetRef.Name)
+	assert.Equal(t, 0, tt.Status.PatchOperations[0].AttemptsRemaining)
+	assert.NotNil(t, tt.Status.PatchOperations[0].AppliedTime)
+	assert.Equal(t, "app", tt.Status.PatchOperations[1].TargetRef.Name)
+	assert.NotEqual(t, 0, tt.Status.PatchOperations[1].AttemptsRemaining)
+	assert.Nil(t, tt.Status.PatchOperations[1].AppliedTime)
+	assert.True(t, trial.CheckCondition(&tt.Status, optimizev1beta2.TrialPatched, corev1.ConditionFalse))
+
+	// The ready reconciler waits for the first wave
+	_, err := rr.Reconcile(req)
+	require.NoError(t, err)
+
+	tt = &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(ctx, req.NamespacedName, tt))
+	require.Len(t, tt.Status.ReadinessChecks, 2)
+	assert.Equal(t, "app", tt.Status.ReadinessChecks[0].TargetRef.Name)
+	assert.Equal(t, int32(36), tt.Status.ReadinessChecks[0].AttemptsRemaining)
+	assert.Equal(t, "db", tt.Status.ReadinessChecks[1].TargetRef.Name)
+	assert.Equal(t, int32(35), tt.Status.ReadinessChecks[1].AttemptsRemaining)
+	assert.False(t, trial.CheckCondition(&tt.Status, optimizev1beta2.TrialFailed, corev1.ConditionTrue))
+
+	_, err = pr.Reconcile(req)
+	require.NoError(t, err)
+	patched := &corev1.Pod{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "app"}, patched))
+	assert.Empty(t, patched.Annotations)
+
+	// Once the first wave is ready, the second wave is applied
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "db"}, db))
+	db.Status.Phase = corev1.PodRunning
+	require.NoError(t, c.Update(ctx, db))
+
+	tt = &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(ctx, req.NamespacedName, tt))
+	tt.Status.ReadinessChecks[1].LastCheckTime = nil
+	require.NoError(t, c.Update(ctx, tt))
+
+	_, err = rr.Reconcile(req)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err := pr.Reconcile(req)
+		require.NoError(t, err)
+	}
+
+	tt = &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(ctx, req.NamespacedName, tt))
+	assert.True(t, trial.CheckCondition(&tt.Status, optimizev1beta2.TrialPatched, corev1.ConditionTrue))
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "app"}, patched))
+	assert.Equal(t, "50", patched.Annotations["connections"])
+}
+
+func TestPatchReconciler_Drift(t *testing.T) {
+	exp := &optimizev1beta2.Experiment{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-exp", Namespace: "default"},
+		Spec: optimizev1beta2.ExperimentSpec{
+			DriftPolicy: optimizev1beta2.DriftReapply,
+		},
+	}
+	t0 := newPatchTrial()
+	t0.Status = optimizev1beta2.TrialStatus{
+		Conditions: []optimizev1beta2.TrialCondition{
+			{Type: optimizev1beta2.TrialPatched, Status: corev1.ConditionTrue},
+			{Type: optimizev1beta2.TrialReady, Status: corev1.ConditionTrue},
+		},
+		PatchOperations: []optimizev1beta2.PatchOperation{
+			{
+				TargetRef: corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "app-config"},
+				PatchType: types.MergePatchType,
+				Data:      []byte(`{"data":{"threads":"16"}}`),
+			},
+		},
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-config", Namespace: "default"},
+		Data:       map[string]string{"threads": "16"},
+	}
+
+	r, req := newPatchReconcilerTest(t, exp, t0, cm)
+	c := r.Client
+	cmKey := types.NamespacedName{Namespace: "default", Name: "app-config"}
+	ctx := context.TODO()
+
+	// No drift, check again later
+	result, err := r.Reconcile(req)
+	require.NoError(t, err)
+	assert.NotZero(t, result.RequeueAfter)
+
+	// Drift is re-applied
+	require.NoError(t, c.Get(ctx, cmKey, cm))
+	cm.Data["threads"] = "4"
+	require.NoError(t, c.Update(ctx, cm))
+
+	_, err = r.Reconcile(req)
+	require.NoError(t, err)
+
+	tt := &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(ctx, req.NamespacedName, tt))
+	if assert.Len(t, tt.Status.PatchDrift, 1) {
+		assert.Equal(t, int32(1), tt.Status.PatchDrift[0].Count)
+		assert.Equal(t, "app-config", tt.Status.PatchDrift[0].TargetRef.Name)
+	}
+	assert.False(t, trial.CheckCondition(&tt.Status, optimizev1beta2.TrialFailed, corev1.ConditionTrue))
+	require.NoError(t, c.Get(ctx, cmKey, cm))
+	assert.Equal(t, "16", cm.Data["threads"])
+
+	// Drift is only recorded, the same drift is not recorded again right away
+	tt.Status.PatchDrift[0].LastDetectionTime = metav1.NewTime(tt.Status.PatchDrift[0].LastDetectionTime.Add(-10 * time.Minute))
+	require.NoError(t, c.Update(ctx, tt))
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "my-exp"}, exp))
+	exp.Spec.DriftPolicy = optimizev1beta2.DriftRecord
+	require.NoError(t, c.Update(ctx, exp))
+	cm.Data["threads"] = "4"
+	require.NoError(t, c.Update(ctx, cm))
+
+	for i := 0; i < 2; i++ {
+		result, err = r.Reconcile(req)
+		require.NoError(t, err)
+		assert.NotZero(t, result.RequeueAfter)
+	}
+
+	tt = &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(ctx, req.NamespacedName, tt))
+	if assert.Len(t, tt.Status.PatchDrift, 1) {
+		assert.Equal(t, int32(2), tt.Status.PatchDrift[0].Count)
+	}
+	assert.False(t, trial.CheckCondition(&tt.Status, optimizev1beta2.TrialFailed, corev1.ConditionTrue))
+	require.NoError(t, c.Get(ctx, cmKey, cm))
+	assert.Equal(t, "4", cm.Data["threads"])
+
+	// Drift fails the trial
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "my-exp"}, exp))
+	exp.Spec.DriftPolicy = optimizev1beta2.DriftFail
+	require.NoError(t, c.Update(ctx, exp))
+
+	_, err = r.Reconcile(req)
+	require.NoError(t, err)
+
+	tt = &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(ctx, req.NamespacedName, tt))
+	if assert.Len(t, tt.Status.PatchDrift, 1) {
+		assert.Equal(t, int32(3), tt.Status.PatchDrift[0].Count)
+	}
+	assert.True(t, trial.CheckCondition(&tt.Status, optimizev1beta2.TrialFailed, corev1.ConditionTrue))
+	for _, cc := range tt.Status.Conditions {
+		if cc.Type == optimizev1beta2.TrialFailed {
+			assert.Equal(t, "PatchDrift", cc.Reason)
+		}
+	}
+	require.NoError(t, c.Get(ctx, cmKey, cm))
+	assert.Equal(t, "4", cm.Data["threads"])
+}
+
+func TestPatchReconciler_DriftJSONPatch(t *testing.T) {
+	exp := &optimizev1beta2.Experiment{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-exp", Namespace: "default"},
+		Spec: optimizev1beta2.ExperimentSpec{
+			DriftPolicy: optimizev1beta2.DriftReapply,
+		},
+	}
+	t0 := newPatchTrial()
+	t0.Status = optimizev1beta2.TrialStatus{
+		Conditions: []optimizev1beta2.TrialCondition{
+			{Type: optimizev1beta2.TrialPatched, Status: corev1.ConditionTrue},
+			{Type: optimizev1beta2.TrialReady, Status: corev1.ConditionTrue},
+		},
+		PatchOperations: []optimizev1beta2.PatchOperation{
+			{
+				TargetRef: corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "default", Name: "app"},
+				PatchType: types.JSONPatchType,
+				Data: []byte(`[
+  {"op":"add","path":"/spec/containers/-","value":{"name":"sidecar","image":"sidecar:1"}},
+  {"op":"replace","path":"/metadata/labels/tier","value":"gold"},
+  {"op":"remove","path":"/metadata/labels/canary"}
+]`),
+			},
+		},
+	}
+
+	// The pod already reflects the patch: the sidecar was appended and the canary label was removed
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Labels: map[string]string{"tier": "gold"}},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app", Image: "app:1"}, {Name: "sidecar", Image: "sidecar:1"}},
+		},
+	}
+
+	r, req := newPatchReconcilerTest(t, exp, t0, pod)
+	c := r.Client
+	podKey := types.NamespacedName{Namespace: "default", Name: "app"}
+	ctx := context.TODO()
+
+	// Operations which cannot be verified are not reported as drift
+	result, err := r.Reconcile(req)
+	require.NoError(t, err)
+	assert.NotZero(t, result.RequeueAfter)
+
+	// Only the drifted fields are restored
+	require.NoError(t, c.Get(ctx, podKey, pod))
+	pod.Labels["tier"] = "bronze"
+	require.NoError(t, c.Update(ctx, pod))
+
+	_, err = r.Reconcile(req)
+	require.NoError(t, err)
+
+	tt := &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(ctx, req.NamespacedName, tt))
+	assert.Len(t, tt.Status.PatchDrift, 1)
+	assert.False(t, trial.CheckCondition(&tt.Status, optimizev1beta2.TrialFailed, corev1.ConditionTrue))
+	require.NoError(t, c.Get(ctx, podKey, pod))
+	assert.Equal(t, map[string]string{"tier": "gold"}, pod.Labels)
+	assert.Len(t, pod.Spec.Containers, 2)
+
+	// The restored object no longer drifts
+	result, err = r.Reconcile(req)
+	require.NoError(t, err)
+	assert.NotZero(t, result.RequeueAfter)
+}
+
+func TestPatchReconciler_RestartConsumers(t *testing.T) {
+	exp := &optimizev1beta2.Experiment{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-exp", Namespace: "default"},
+		Spec: optimizev1beta2.ExperimentSpec{
+			Parameters: []optimizev1beta2.Parameter{{Name: "threads", Min: 1, Max: 32}},
+			Patches: []optimizev1beta2.PatchTemplate{
+				{
+					Type:      optimizev1beta2.PatchMerge,
+					TargetRef: &corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Name: "app-config"},
+					Patch:     `{"data":{"threads":"{{ .Values.threads }}"}}`,
+				},
+			},
+		},
+	}
+	t0 := newPatchTrial(optimizev1beta2.Assignment{Name: "threads", Value: intstr.FromInt(16)})
+	app := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:    "app",
+			EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "app-config"}}}},
+		}}}}},
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-config", Namespace: "default"},
+		Data:       map[string]string{"threads": "4"},
+	}
+
+	r, req := newPatchReconcilerTest(t, exp, t0, app, cm)
+	c := r.Client
+	ctx := context.TODO()
+
+	// Evaluate and apply the patches
+	for i := 0; i < 4; i++ {
+		_, err := r.Reconcile(req)
+		require.NoError(t, err)
+	}
+
+	tt := &optimizev1beta2.Trial{}
+	require.NoError(t, c.Get(ctx, req.NamespacedName, tt))
+	assert.True(t, trial.CheckCondition(&tt.Status, optimizev1beta2.TrialPatched, corev1.ConditionTrue))
+	if assert.Len(t, tt.Status.PatchOperations, 2) {
+		assert.Equal(t, "ConfigMap", tt.Status.PatchOperations[0].TargetRef.Kind)
+		assert.Equal(t, corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "app"}, tt.Status.PatchOperations[1].TargetRef)
+	}
+	var checked []string
+	for _, rc := range tt.Status.ReadinessChecks {
+		checked = append(checked, rc.TargetRef.Kind)
+	}
+	assert.ElementsMatch(t, []string{"ConfigMap", "Deployment"}, checked)
+
+	restarted := &appsv1.Deployment{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "app"}, restarted))
+	assert.Contains(t, restarted.Spec.Template.Annotations, patch.RestartAnnotation)
+}
+
+// newPatchReconcilerTest returns a patch reconciler backed by a fake client containing the supplied objects along
+// with a request for the trial created by `newPatchTrial`.
+func newPatchReconcilerTest(t *testing.T, objs ...runtime.Object) (*PatchReconciler, ctrl.Request) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, optimizev1beta2.AddToScheme(scheme))
+
+	c := fake.NewFakeClientWithScheme(scheme, objs...)
+	r := &PatchReconciler{
+		Client:    c,
+		Log:       zap.New(zap.UseDevMode(true)),
+		Scheme:    scheme,
+		apiReader: c,
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "my-trial"}}
+	return r, req
+}
+
+// newPatchTrial returns a trial of the "my-exp" experiment that is waiting to be patched.
+func newPatchTrial(assignments ...optimizev1beta2.Assignment) *optimizev1beta2.Trial {
+	return &optimizev1beta2.Trial{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-trial",
+			Namespace: "default",
+			Labels:    map[string]string{optimizev1beta2.LabelExperiment: "my-exp"},
+		},
+		Spec: optimizev1beta2.TrialSpec{
+			Assignments: assignments,
+		},
+		Status: optimizev1beta2.TrialStatus{
+			Conditions: []optimizev1beta2.TrialCondition{{Type: optimizev1beta2.TrialPatched, Status: corev1.ConditionUnknown}},
+		},
+	}
+}
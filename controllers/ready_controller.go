@@ -18,6 +18,7 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"time"
 
@@ -61,6 +62,10 @@ func (r *ReadyReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, controller.IgnoreNotFound(err)
 	}
 
+	if result, err := r.checkPatchWaves(ctx, t, &now); result != nil {
+		return *result, err
+	}
+
 	if result, err := r.evaluateReadinessChecks(ctx, t, &now); result != nil {
 		return *result, err
 	}
@@ -93,8 +98,9 @@ func (r *ReadyReconciler) ignoreTrial(t *optimizev1beta2.Trial) bool {
 		return true
 	}
 
-	// Ignore unpatched trials
-	if !trial.CheckCondition(&t.Status, optimizev1beta2.TrialPatched, corev1.ConditionTrue) {
+	// Ignore unpatched trials, unless the patches are being applied in waves
+	if !trial.CheckCondition(&t.Status, optimizev1beta2.TrialPatched, corev1.ConditionTrue) &&
+		!trial.CheckCondition(&t.Status, optimizev1beta2.TrialPatched, corev1.ConditionFalse) {
 		return true
 	}
 
@@ -107,6 +113,31 @@ func (r *ReadyReconciler) ignoreTrial(t *optimizev1beta2.Trial) bool {
 	return false
 }
 
+// checkPatchWaves will evaluate the readiness checks which must pass before the next wave of patches is applied
+func (r *ReadyReconciler) checkPatchWaves(ctx context.Context, t *optimizev1beta2.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
+	// Only check waves while the patches are still being applied
+	if !trial.CheckCondition(&t.Status, optimizev1beta2.TrialPatched, corev1.ConditionFalse) {
+		return nil, nil
+	}
+
+	// If the next wave is not waiting on anything, leave it to the patch reconciler
+	wave, ok := trial.PendingPatchWave(t)
+	if !ok || trial.IsWaveReady(t, wave) {
+		return &ctrl.Result{}, nil
+	}
+
+	// Only consider the checks from the lower waves
+	checker := newReadinessChecker(r.Client, t)
+	checker.wave = &wave
+	if result, err := r.runReadinessChecks(ctx, t, checker, probeTime); result != nil {
+		return result, err
+	}
+
+	// Updating the trial allows the patch reconciler to apply the next wave
+	err := r.Update(ctx, t)
+	return controller.RequeueConflict(err)
+}
+
 // evaluateReadinessChecks will prepare all of the readiness checks for the trial
 func (r *ReadyReconciler) evaluateReadinessChecks(ctx context.Context, t *optimizev1beta2.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
 	// Only evaluate readiness checks if the "ready" status is "unknown"
@@ -131,6 +162,7 @@ func (r *ReadyReconciler) evaluateReadinessChecks(ctx context.Context, t *optimi
 			InitialDelaySeconds: c.InitialDelaySeconds,
 			PeriodSeconds:       c.PeriodSeconds,
 			AttemptsRemaining:   c.FailureThreshold,
+			HTTPGet:             c.HTTPGet,
 		}
 
 		// Adjust for defaults/minimums
@@ -163,6 +195,21 @@ func (r *ReadyReconciler) checkReadiness(ctx context.Context, t *optimizev1beta2
 
 	// Create a new "checker" to maintain state while looping over the readiness checks
 	checker := newReadinessChecker(r.Client, t)
+	if result, err := r.runReadinessChecks(ctx, t, checker, probeTime); result != nil {
+		return result, err
+	}
+
+	// Update the trial (and the status, if all the checks are complete)
+	if checker.ready {
+		trial.ApplyCondition(&t.Status, optimizev1beta2.TrialReady, corev1.ConditionTrue, "", "", probeTime)
+	}
+	err := r.Update(ctx, t)
+	return controller.RequeueConflict(err)
+}
+
+// runReadinessChecks evaluates the readiness checks accepted by the checker, returning a non-nil result if the
+// trial failed or the checks need to be attempted again later
+func (r *ReadyReconciler) runReadinessChecks(ctx context.Context, t *optimizev1beta2.Trial, checker *readinessChecker, probeTime *metav1.Time) (*ctrl.Result, error) {
 	for i := range t.Status.ReadinessChecks {
 		c := &t.Status.ReadinessChecks[i]
 		if checker.skipCheck(c, probeTime) {
@@ -182,6 +229,9 @@ func (r *ReadyReconciler) checkReadiness(ctx context.Context, t *optimizev1beta2
 			readinessCheckFailed(t, probeTime, err)
 			err := r.Update(ctx, t)
 			return controller.RequeueConflict(err)
+		} else if !isReady && checker.wave != nil {
+			// The trial is not "ready" until all of the patches are applied, report waiting on the patches instead
+			trial.ApplyCondition(&t.Status, optimizev1beta2.TrialPatched, corev1.ConditionFalse, "Waiting", msg, probeTime)
 		} else if !isReady {
 			// This will get overwritten with anything that isn't ready as we progress through the loop
 			trial.ApplyCondition(&t.Status, optimizev1beta2.TrialReady, corev1.ConditionFalse, "Waiting", msg, probeTime)
@@ -193,12 +243,7 @@ func (r *ReadyReconciler) checkReadiness(ctx context.Context, t *optimizev1beta2
 		return &ctrl.Result{RequeueAfter: checker.after}, nil
 	}
 
-	// Update the trial (and the status, if all the checks are complete)
-	if checker.ready {
-		trial.ApplyCondition(&t.Status, optimizev1beta2.TrialReady, corev1.ConditionTrue, "", "", probeTime)
-	}
-	err := r.Update(ctx, t)
-	return controller.RequeueConflict(err)
+	return nil, nil
 }
 
 // getCheckTargets returns the list of target objects for the readiness check
@@ -256,12 +301,16 @@ type readinessChecker struct {
 	checker ready.ReadinessChecker
 	// epoch is the time at which readiness checks can be evaluated (i.e. when the trial transitioned into a "patched" status)
 	epoch metav1.Time
+	// waveEpochs are the times at which the readiness checks gating the next wave can be evaluated (i.e. when the last patch of their wave was applied)
+	waveEpochs map[int32]metav1.Time
 	// ready is a flag indicating that all of readiness checks have been evaluated
 	ready bool
 	// requeue is a flag indicating that none of the readiness checks can be evaluated
 	requeue bool
 	// after is the delay after which all of the readiness checks can be evaluated
 	after time.Duration
+	// wave limits evaluation to the readiness checks of the lower waves, if set
+	wave *int32
 }
 
 // newReadinessChecker returns a new checker for the supplied trial
@@ -273,7 +322,7 @@ func newReadinessChecker(reader client.Reader, t *optimizev1beta2.Trial) *readin
 			epoch = t.Status.Conditions[i].LastTransitionTime
 		}
 	}
-	return &readinessChecker{checker: checker, epoch: epoch, ready: true, requeue: true}
+	return &readinessChecker{checker: checker, epoch: epoch, waveEpochs: trial.WaveAppliedTimes(t), ready: true, requeue: true}
 }
 
 // skipCheck determines if a check should be evaluated, recording the results internally
@@ -283,6 +332,11 @@ func (rc *readinessChecker) skipCheck(c *optimizev1beta2.ReadinessCheck, now *me
 		return true
 	}
 
+	// Determine if the check is for a wave that is not being considered
+	if rc.wave != nil && c.Wave >= *rc.wave {
+		return true
+	}
+
 	// At least one check still has remaining attempts, DO NOT mark the trial as ready
 	rc.ready = false
 
@@ -317,7 +371,7 @@ func (rc *readinessChecker) check(ctx context.Context, c *optimizev1beta2.Readin
 	var ok bool
 	var err error
 	for i := range ul.Items {
-		msg, ok, err = rc.checker.CheckConditions(ctx, &ul.Items[i], c.ConditionTypes)
+		msg, ok, err = rc.checker.CheckConditions(ctx, &ul.Items[i], c.ConditionTypes, c.HTTPGet)
 		if !ok || err != nil {
 			break
 		}
@@ -329,6 +383,16 @@ func (rc *readinessChecker) check(ctx context.Context, c *optimizev1beta2.Readin
 		ok = true
 	}
 
+	// HTTP checks may require multiple consecutive successes
+	if c.HTTPGet != nil && c.HTTPGet.SuccessThreshold > 1 && err == nil {
+		if !ok {
+			c.Successes = 0
+		} else if c.Successes++; c.Successes < c.HTTPGet.SuccessThreshold {
+			c.LastCheckTime = now
+			return fmt.Sprintf("Waiting for %d consecutive successful requests", c.HTTPGet.SuccessThreshold), false, nil
+		}
+	}
+
 	// Check is done, it is either ok or had a hard failure
 	if ok || err != nil {
 		c.AttemptsRemaining = 0
@@ -376,5 +440,10 @@ func (rc *readinessChecker) nextCheckTime(c *optimizev1beta2.ReadinessCheck) *me
 		return &metav1.Time{Time: c.LastCheckTime.Add(time.Duration(periodSeconds) * time.Second)}
 	}
 
-	return &metav1.Time{Time: rc.epoch.Add(time.Duration(c.InitialDelaySeconds) * time.Second)}
+	// While patches are applied in waves, the "patched" transition only marks the start of the first wave
+	epoch := rc.epoch
+	if t, ok := rc.waveEpochs[c.Wave]; ok && rc.wave != nil {
+		epoch = t
+	}
+	return &metav1.Time{Time: epoch.Add(time.Duration(c.InitialDelaySeconds) * time.Second)}
 }
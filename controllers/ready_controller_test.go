@@ -0,0 +1,56 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReadinessChecker_NextCheckTime(t *testing.T) {
+	patchedTime := metav1.NewTime(time.Date(2022, time.January, 1, 12, 0, 0, 0, time.UTC))
+	appliedTime := metav1.NewTime(patchedTime.Add(time.Minute))
+
+	tt := &optimizev1beta2.Trial{
+		Status: optimizev1beta2.TrialStatus{
+			Conditions: []optimizev1beta2.TrialCondition{
+				{Type: optimizev1beta2.TrialPatched, Status: corev1.ConditionFalse, LastTransitionTime: patchedTime},
+			},
+			PatchOperations: []optimizev1beta2.PatchOperation{
+				{Wave: 0, AppliedTime: &patchedTime},
+				{Wave: 0, AppliedTime: &appliedTime},
+				{Wave: 1},
+			},
+		},
+	}
+	c := &optimizev1beta2.ReadinessCheck{Wave: 0, InitialDelaySeconds: 30}
+
+	// Checks gating the next wave are delayed from the last patch of their wave
+	checker := newReadinessChecker(nil, tt)
+	wave := int32(1)
+	checker.wave = &wave
+	assert.Equal(t, appliedTime.Add(30*time.Second), checker.nextCheckTime(c).Time)
+
+	// Otherwise the delay is from the "patched" transition
+	checker = newReadinessChecker(nil, tt)
+	assert.Equal(t, patchedTime.Add(30*time.Second), checker.nextCheckTime(c).Time)
+}
@@ -43,6 +43,7 @@ type SetupReconciler struct {
 	Scheme *runtime.Scheme
 }
 
+// +kubebuilder:rbac:groups=optimize.stormforge.io,resources=experiments,verbs=get;list;watch
 // +kubebuilder:rbac:groups=optimize.stormforge.io,resources=trials;trials/finalizers,verbs=get;list;watch;update
 // +kubebuilder:rbac:groups="",resources=pods,verbs=list
 // +kubebuilder:rbac:groups=batch;extensions,resources=jobs,verbs=list;watch;create
@@ -198,7 +199,15 @@ func (r *SetupReconciler) createSetupJob(ctx context.Context, t *optimizev1beta2
 
 	// Create a setup job if necessary
 	if mode != "" {
-		job, err := setup.NewJob(t, mode)
+		// The experiment describes the parameters to Helm value templates, it may already be gone for delete jobs
+		exp := &optimizev1beta2.Experiment{}
+		if err := r.Get(ctx, t.ExperimentNamespacedName(), exp); apierrs.IsNotFound(err) {
+			exp = nil
+		} else if err != nil {
+			return &ctrl.Result{}, err
+		}
+
+		job, err := setup.NewJob(exp, t, mode)
 		if err != nil {
 			return &ctrl.Result{}, err
 		}
@@ -19,33 +19,52 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/go-logr/logr"
 	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
 	"github.com/thestormforge/optimize-controller/v2/internal/controller"
 	"github.com/thestormforge/optimize-controller/v2/internal/meta"
+	"github.com/thestormforge/optimize-controller/v2/internal/setup"
 	"github.com/thestormforge/optimize-controller/v2/internal/trial"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
+const (
+	// jobOutputTailLines is the number of log lines recorded per container when there is no termination message
+	jobOutputTailLines = 100
+	// jobOutputLimit is the maximum number of bytes of trial job output recorded on the trial
+	jobOutputLimit = 32 * 1024
+)
+
 // TrialJobReconciler reconciles a Trial's job
 type TrialJobReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// PushURL is the base URL of the metric push endpoint, trial jobs are not given push credentials if it is empty
+	PushURL string
+
+	logs corev1client.PodsGetter
 }
 
 // +kubebuilder:rbac:groups=optimize.stormforge.io,resources=trials,verbs=get;list;watch;update
 // +kubebuilder:rbac:groups=batch;extensions,resources=jobs,verbs=get;list;watch;create;patch
 // +kubebuilder:rbac:groups="",resources=pods,verbs=list
+// +kubebuilder:rbac:groups="",resources=pods/log,verbs=get
 
 func (r *TrialJobReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -68,7 +87,7 @@ func (r *TrialJobReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	}
 
 	// Create a new job if necessary
-	if len(jobList.Items) > 0 {
+	if len(jobList.Items) > 0 || t.Status.CompletionTime != nil {
 		return ctrl.Result{}, nil
 	}
 
@@ -93,6 +112,12 @@ func (r *TrialJobReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 }
 
 func (r *TrialJobReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	cs, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	r.logs = cs.CoreV1()
+
 	return ctrl.NewControllerManagedBy(mgr).
 		Named("trial-job").
 		For(&optimizev1beta2.Trial{}).
@@ -116,9 +141,9 @@ func (r *TrialJobReconciler) ignoreTrial(t *optimizev1beta2.Trial) bool {
 		return true
 	}
 
-	// Ignore trials that already have a start and completion time
+	// Ignore trials that already have a start and completion time, unless the job output is still needed
 	if t.Status.StartTime != nil && t.Status.CompletionTime != nil {
-		return true
+		return !needsJobOutput(t)
 	}
 
 	// Reconcile everything else
@@ -142,6 +167,23 @@ func (r *TrialJobReconciler) updateStatus(ctx context.Context, t *optimizev1beta
 
 // createJob will create a new trial run job
 func (r *TrialJobReconciler) createJob(ctx context.Context, t *optimizev1beta2.Trial) (*ctrl.Result, error) {
+	// Record the credentials the trial job uses to push metric values before the job is created
+	if r.PushURL != "" && needsMetricsSecret(t) {
+		secret, err := r.createMetricsSecret(ctx, t)
+		if err != nil {
+			return &ctrl.Result{}, err
+		}
+
+		if t.Annotations == nil {
+			t.Annotations = make(map[string]string)
+		}
+		t.Annotations[optimizev1beta2.AnnotationMetricsURL] = strings.TrimSuffix(r.PushURL, "/") + metricPushPath(t)
+		t.Annotations[optimizev1beta2.AnnotationMetricsSecret] = secret.Name
+		if err := r.Update(ctx, t); err != nil {
+			return controller.RequeueConflict(err)
+		}
+	}
+
 	job := trial.NewJob(t)
 	if err := controllerutil.SetControllerReference(t, job, r.Scheme); err != nil {
 		return &ctrl.Result{}, err
@@ -151,6 +193,39 @@ func (r *TrialJobReconciler) createJob(ctx context.Context, t *optimizev1beta2.T
 	return &ctrl.Result{}, err
 }
 
+// createMetricsSecret creates the secret holding the bearer token the trial job uses to push metric values, the
+// secret is owned by the trial so it is removed along with it.
+func (r *TrialJobReconciler) createMetricsSecret(ctx context.Context, t *optimizev1beta2.Trial) (*corev1.Secret, error) {
+	token, err := newMetricsToken()
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      t.Name + "-metrics",
+			Namespace: t.Namespace,
+			Labels: map[string]string{
+				optimizev1beta2.LabelExperiment: t.ExperimentNamespacedName().Name,
+				optimizev1beta2.LabelTrial:      t.Name,
+			},
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: map[string]string{setup.MetricsTokenKey: token},
+	}
+	if err := controllerutil.SetControllerReference(t, secret, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	// A previous attempt may have created the secret without recording it on the trial
+	// RBAC: We assume that we have "create" permission from a customer defined role, only experiments with push
+	// metrics need it
+	if err := r.Create(ctx, secret); err != nil && !apierrs.IsAlreadyExists(err) {
+		return nil, err
+	}
+	return secret, nil
+}
+
 // listJobs will return all of the jobs for the trial
 func (r *TrialJobReconciler) listJobs(ctx context.Context, jobList *batchv1.JobList, namespace string, selector *metav1.LabelSelector) error {
 	matchingSelector, err := meta.MatchingSelector(selector)
@@ -209,8 +284,25 @@ func (r *TrialJobReconciler) applyJobStatus(ctx context.Context, t *optimizev1be
 			// Check if the job has a start/completion time, but it is not yet reflected in the pod state we are seeing
 			startedAt, finishedAt = containerTime(podList)
 			if (startedAt == nil && job.Status.StartTime != nil) || (finishedAt == nil && job.Status.CompletionTime != nil) {
+				// Pods removed after the job completed will never be consistent, there is no output left to record
+				if len(podList.Items) == 0 && needsJobOutput(t) && isJobComplete(job) {
+					delete(t.Annotations, optimizev1beta2.AnnotationJobOutput)
+					dirty = true
+				}
 				return dirty, true
 			}
+
+			// Record the output of the main container for metrics which parse it, other containers in the pod (e.g.
+			// sidecars) and other pods of the job may still be running until the job is complete. If there is no
+			// output the annotation is removed so we stop looking for it, the metric will fail to capture.
+			if needsJobOutput(t) && isJobComplete(job) {
+				if output := r.jobOutput(ctx, job, podList); output != "" {
+					t.Annotations[optimizev1beta2.AnnotationJobOutput] = output
+				} else {
+					delete(t.Annotations, optimizev1beta2.AnnotationJobOutput)
+				}
+				dirty = true
+			}
 		}
 	}
 
@@ -237,6 +329,85 @@ func (r *TrialJobReconciler) applyJobStatus(ctx context.Context, t *optimizev1be
 	return dirty, false
 }
 
+// needsMetricsSecret checks to see if the trial is waiting for the secret used to push metric values.
+func needsMetricsSecret(t *optimizev1beta2.Trial) bool {
+	name, ok := t.Annotations[optimizev1beta2.AnnotationMetricsSecret]
+	return ok && name == ""
+}
+
+// needsJobOutput checks to see if the trial job output must still be recorded, the annotation is initialized with
+// an empty value for trials of experiments with metrics which parse the output.
+func needsJobOutput(t *optimizev1beta2.Trial) bool {
+	output, ok := t.Annotations[optimizev1beta2.AnnotationJobOutput]
+	return ok && output == ""
+}
+
+// isJobComplete checks to see if the job has completed successfully.
+func isJobComplete(job *batchv1.Job) bool {
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobComplete && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// jobOutput returns the termination messages of the main container of each succeeded job pod, falling back to the
+// tail of the logs when the container did not produce a termination message.
+func (r *TrialJobReconciler) jobOutput(ctx context.Context, job *batchv1.Job, pods *corev1.PodList) string {
+	// The main container is the first container of the job template, any others are assumed to be sidecars
+	if len(job.Spec.Template.Spec.Containers) == 0 {
+		return ""
+	}
+	main := job.Spec.Template.Spec.Containers[0].Name
+
+	var output []string
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Name != main || cs.State.Terminated == nil {
+				continue
+			}
+
+			if msg := strings.TrimSpace(cs.State.Terminated.Message); msg != "" {
+				output = append(output, msg)
+				continue
+			}
+
+			if r.logs == nil {
+				continue
+			}
+
+			tailLines, limitBytes := int64(jobOutputTailLines), int64(jobOutputLimit)
+			data, err := r.logs.Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+				Container:  cs.Name,
+				TailLines:  &tailLines,
+				LimitBytes: &limitBytes,
+			}).Do().Raw()
+			if err != nil {
+				r.Log.Error(err, "Failed to read trial job logs", "pod", pod.Name, "container", cs.Name)
+				continue
+			}
+			if logs := strings.TrimSpace(string(data)); logs != "" {
+				output = append(output, logs)
+			}
+		}
+	}
+
+	// Keep the end of the output, that is where benchmarks typically report results
+	result := strings.Join(output, "\n")
+	if len(result) > jobOutputLimit {
+		result = result[len(result)-jobOutputLimit:]
+		for len(result) > 0 && !utf8.RuneStart(result[0]) {
+			result = result[1:]
+		}
+	}
+	return result
+}
+
 func containerTime(pods *corev1.PodList) (startedAt *metav1.Time, finishedAt *metav1.Time) {
 	for i := range pods.Items {
 		for j := range pods.Items[i].Status.ContainerStatuses {
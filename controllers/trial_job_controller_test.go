@@ -0,0 +1,222 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestTrialJobReconciler_JobOutput(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/default/pods/my-trial-abc/log" || r.URL.Query().Get("container") != "benchmark" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		assert.Equal(t, "100", r.URL.Query().Get("tailLines"))
+		_, _ = w.Write([]byte("ops/sec: 1234\n"))
+	}))
+	defer srv.Close()
+
+	cs, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	require.NoError(t, err)
+	r := &TrialJobReconciler{Log: zap.New(zap.UseDevMode(true)), logs: cs.CoreV1()}
+
+	terminated := func(name, message string) corev1.ContainerStatus {
+		return corev1.ContainerStatus{
+			Name:  name,
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: message}},
+		}
+	}
+	job := &batchv1.Job{
+		Spec: batchv1.JobSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "benchmark"}, {Name: "sidecar"}},
+				},
+			},
+		},
+	}
+	pods := &corev1.PodList{
+		Items: []corev1.Pod{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-trial-xyz", Namespace: "default"},
+				Status: corev1.PodStatus{
+					Phase: corev1.PodSucceeded,
+					ContainerStatuses: []corev1.ContainerStatus{
+						terminated("benchmark", `{"throughput": 99.5}`),
+						terminated("sidecar", "ignored"),
+					},
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-trial-abc", Namespace: "default"},
+				Status: corev1.PodStatus{
+					Phase: corev1.PodSucceeded,
+					ContainerStatuses: []corev1.ContainerStatus{
+						terminated("benchmark", ""),
+						{Name: "sidecar", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+					},
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-trial-def", Namespace: "default"},
+				Status: corev1.PodStatus{
+					Phase: corev1.PodFailed,
+					ContainerStatuses: []corev1.ContainerStatus{
+						terminated("benchmark", "failed"),
+					},
+				},
+			},
+		},
+	}
+
+	assert.Equal(t, "{\"throughput\": 99.5}\nops/sec: 1234", r.jobOutput(context.TODO(), job, pods))
+
+	// Truncation must not split a multi-byte rune
+	pods.Items = []corev1.Pod{pods.Items[0]}
+	pods.Items[0].Status.ContainerStatuses = []corev1.ContainerStatus{
+		terminated("benchmark", "x"+strings.Repeat("\u00e9", jobOutputLimit/2)+"y"),
+	}
+	output := r.jobOutput(context.TODO(), job, pods)
+	assert.True(t, utf8.ValidString(output))
+	assert.Equal(t, strings.Repeat("\u00e9", jobOutputLimit/2-1)+"y", output)
+}
+
+func TestTrialJobReconciler_CreateJob(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, optimizev1beta2.AddToScheme(scheme))
+
+	pushTrial := &optimizev1beta2.Trial{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "push-trial",
+			Namespace:   "default",
+			Labels:      map[string]string{optimizev1beta2.LabelExperiment: "my-exp"},
+			Annotations: map[string]string{optimizev1beta2.AnnotationMetricsSecret: ""},
+		},
+	}
+	otherTrial := &optimizev1beta2.Trial{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "other-trial",
+			Namespace: "default",
+			Labels:    map[string]string{optimizev1beta2.LabelExperiment: "my-exp"},
+		},
+	}
+
+	c := fake.NewFakeClientWithScheme(scheme, pushTrial, otherTrial)
+	r := &TrialJobReconciler{Client: c, Log: zap.New(zap.UseDevMode(true)), Scheme: scheme, PushURL: "http://push:8090"}
+	ctx := context.TODO()
+
+	// Only trials of experiments with push metrics get a push token
+	for _, tt := range []*optimizev1beta2.Trial{pushTrial, otherTrial} {
+		_, err := r.createJob(ctx, tt)
+		require.NoError(t, err)
+	}
+
+	secrets := &corev1.SecretList{}
+	require.NoError(t, c.List(ctx, secrets))
+	if assert.Len(t, secrets.Items, 1) {
+		assert.Equal(t, "push-trial-metrics", secrets.Items[0].Name)
+	}
+
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "push-trial"}, pushTrial))
+	assert.Equal(t, "push-trial-metrics", pushTrial.Annotations[optimizev1beta2.AnnotationMetricsSecret])
+	assert.Equal(t, "http://push:8090/namespaces/default/trials/push-trial/metrics", pushTrial.Annotations[optimizev1beta2.AnnotationMetricsURL])
+
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "other-trial"}, otherTrial))
+	assert.NotContains(t, otherTrial.Annotations, optimizev1beta2.AnnotationMetricsURL)
+}
+
+func TestTrialJobReconciler_NoJobOutput(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, optimizev1beta2.AddToScheme(scheme))
+
+	now := metav1.Now()
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-trial", Namespace: "default"},
+		Spec: batchv1.JobSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"job-name": "my-trial"}},
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "benchmark"}}}},
+		},
+		Status: batchv1.JobStatus{
+			StartTime:      &now,
+			CompletionTime: &now,
+			Conditions:     []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-trial-abc", Namespace: "default", Labels: map[string]string{"job-name": "my-trial"}},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodSucceeded,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "benchmark",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{StartedAt: now, FinishedAt: now}},
+			}},
+		},
+	}
+
+	testCases := []struct {
+		desc string
+		objs []runtime.Object
+	}{
+		{
+			desc: "empty output",
+			objs: []runtime.Object{pod},
+		},
+		{
+			desc: "pods removed",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := &TrialJobReconciler{Client: fake.NewFakeClientWithScheme(scheme, tc.objs...), Log: zap.New(zap.UseDevMode(true))}
+			tt := &optimizev1beta2.Trial{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "my-trial",
+					Namespace:   "default",
+					Annotations: map[string]string{optimizev1beta2.AnnotationJobOutput: ""},
+				},
+			}
+
+			// Once the output was looked for the trial is no longer waiting on it
+			dirty, _ := r.applyJobStatus(context.TODO(), tt, job, &now)
+			assert.True(t, dirty)
+			assert.NotContains(t, tt.Annotations, optimizev1beta2.AnnotationJobOutput)
+			assert.False(t, needsJobOutput(tt))
+		})
+	}
+}
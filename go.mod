@@ -3,12 +3,16 @@ module github.com/thestormforge/optimize-controller/v2
 go 1.18
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.0
 	github.com/Masterminds/sprig/v3 v3.2.2
 	github.com/charmbracelet/bubbles v0.7.6
 	github.com/charmbracelet/bubbletea v0.13.1
+	github.com/evanphx/json-patch v4.9.0+incompatible
 	github.com/go-logr/logr v0.1.0
 	github.com/go-logr/zapr v0.1.1
+	github.com/go-sql-driver/mysql v1.7.1
 	github.com/lestrrat-go/jwx v1.0.6
+	github.com/lib/pq v1.10.9
 	github.com/mdp/qrterminal/v3 v3.0.0
 	github.com/mmcdole/gofeed v1.1.3
 	github.com/muesli/termenv v0.7.4
@@ -56,7 +60,6 @@ require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.1 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/emicklei/go-restful v2.9.5+incompatible // indirect
-	github.com/evanphx/json-patch v4.9.0+incompatible // indirect
 	github.com/fatih/camelcase v1.0.0 // indirect
 	github.com/fatih/color v1.9.0 // indirect
 	github.com/ghodss/yaml v1.0.0 // indirect
@@ -51,6 +51,18 @@ func PopulateTrialFromTemplate(exp *optimizev1beta2.Experiment, t *optimizev1bet
 		Namespace: exp.Namespace,
 	}
 
+	// Metrics which are recorded on the trial during or after the trial run need their annotations initialized
+	for _, m := range exp.Spec.Metrics {
+		switch m.Type {
+		case optimizev1beta2.MetricPodMetrics:
+			t.Annotations[optimizev1beta2.AnnotationMetricSamples] = "{}"
+		case optimizev1beta2.MetricJobOutput:
+			t.Annotations[optimizev1beta2.AnnotationJobOutput] = ""
+		case optimizev1beta2.MetricPush:
+			t.Annotations[optimizev1beta2.AnnotationMetricsSecret] = ""
+		}
+	}
+
 	// Default trial name is the experiment name with a random suffix
 	if t.Name == "" && t.GenerateName == "" {
 		t.GenerateName = exp.Name + "-"
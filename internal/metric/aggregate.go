@@ -0,0 +1,111 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// aggregate reduces a series of values to a single value using the named aggregation. The (population) standard
+// deviation of the series is also returned so it can be reported as the error of the aggregated value.
+func aggregate(aggregation string, values []float64) (float64, float64, error) {
+	if len(values) == 0 {
+		return math.NaN(), math.NaN(), nil
+	}
+
+	var value float64
+	switch {
+	case aggregation == "avg" || aggregation == "":
+		value = mean(values)
+	case aggregation == "last":
+		value = values[len(values)-1]
+	case aggregation == "max" || aggregation == "peak":
+		value = values[0]
+		for _, v := range values[1:] {
+			value = math.Max(value, v)
+		}
+	case aggregation == "min":
+		value = values[0]
+		for _, v := range values[1:] {
+			value = math.Min(value, v)
+		}
+	case aggregation == "sum":
+		for _, v := range values {
+			value += v
+		}
+	case strings.HasPrefix(aggregation, "p"):
+		p, err := parsePercentile(aggregation)
+		if err != nil {
+			return 0, 0, err
+		}
+		value = percentile(values, p)
+	default:
+		return 0, 0, fmt.Errorf("unsupported aggregation: %s (expected: avg, last, max, peak, min, sum, p<N>)", aggregation)
+	}
+
+	return value, stddev(values), nil
+}
+
+// parsePercentile returns the percentile (0-100) from an aggregation such as "p95" or "p99.9".
+func parsePercentile(aggregation string) (float64, error) {
+	p, err := strconv.ParseFloat(strings.TrimPrefix(aggregation, "p"), 64)
+	if err != nil || p < 0 || p > 100 {
+		return 0, fmt.Errorf("invalid percentile aggregation: %s (expected: p0 through p100)", aggregation)
+	}
+	return p, nil
+}
+
+// percentile computes the p-th percentile of the values using linear interpolation between the closest ranks.
+func percentile(values []float64, p float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower, upper := int(math.Floor(rank)), int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func stddev(values []float64) float64 {
+	m := mean(values)
+	var ss float64
+	for _, v := range values {
+		ss += (v - m) * (v - m)
+	}
+	return math.Sqrt(ss / float64(len(values)))
+}
+
+// standardError returns the standard error of the mean using the sample standard deviation of the values.
+func standardError(values []float64) float64 {
+	n := float64(len(values))
+	if n < 2 {
+		return math.NaN()
+	}
+	return stddev(values) * math.Sqrt(n/(n-1)) / math.Sqrt(n)
+}
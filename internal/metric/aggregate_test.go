@@ -0,0 +1,65 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregate(t *testing.T) {
+	values := []float64{4, 2, 8, 6}
+
+	testCases := []struct {
+		aggregation string
+		expected    float64
+		expectedErr bool
+	}{
+		{aggregation: "", expected: 5},
+		{aggregation: "avg", expected: 5},
+		{aggregation: "last", expected: 6},
+		{aggregation: "max", expected: 8},
+		{aggregation: "min", expected: 2},
+		{aggregation: "sum", expected: 20},
+		{aggregation: "p0", expected: 2},
+		{aggregation: "p50", expected: 5},
+		{aggregation: "p100", expected: 8},
+		{aggregation: "p90", expected: 7.4},
+		{aggregation: "p101", expectedErr: true},
+		{aggregation: "median", expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.aggregation, func(t *testing.T) {
+			value, valueError, err := aggregate(tc.aggregation, values)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.InDelta(t, tc.expected, value, 1e-9)
+				assert.InDelta(t, math.Sqrt(5), valueError, 1e-9)
+			}
+		})
+	}
+
+	value, valueError, err := aggregate("avg", nil)
+	assert.NoError(t, err)
+	assert.True(t, math.IsNaN(value))
+	assert.True(t, math.IsNaN(valueError))
+}
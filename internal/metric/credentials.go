@@ -0,0 +1,59 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// credential returns the first non-empty value for the supplied keys. If a secret is available, only the secret
+// data is considered; otherwise the values are read from the process environment.
+func credential(secret *corev1.Secret, keys ...string) string {
+	for _, key := range keys {
+		var value string
+		if secret != nil {
+			value = string(secret.Data[key])
+		} else {
+			value = os.Getenv(key)
+		}
+
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// secretData returns the value of a key from the secret, if one is available.
+func secretData(secret *corev1.Secret, key string) []byte {
+	if secret == nil {
+		return nil
+	}
+	return secret.Data[key]
+}
+
+// missingCredential returns an error describing a required credential that could not be found.
+func missingCredential(secret *corev1.Secret, key string) error {
+	if secret != nil {
+		return fmt.Errorf("secret %q is missing required key %s", secret.Name, key)
+	}
+	return fmt.Errorf("%s environment variable missing", key)
+}
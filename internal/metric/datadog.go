@@ -17,43 +17,68 @@ limitations under the License.
 package metric
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"net/url"
-	"os"
 	"time"
 
+	"github.com/go-logr/logr"
 	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
 	"github.com/zorkian/go-datadog-api"
+	corev1 "k8s.io/api/core/v1"
 )
 
-func captureDatadogMetric(m *optimizev1beta2.Metric, startTime, completionTime time.Time) (float64, float64, error) {
-	apiKey := os.Getenv("DATADOG_API_KEY")
-	if apiKey == "" {
-		apiKey = os.Getenv("DD_API_KEY")
-	}
+func init() {
+	Register(optimizev1beta2.MetricDatadog, &datadogProvider{})
+}
+
+// datadogProvider captures values using metric queries against the Datadog service.
+type datadogProvider struct{}
+
+func (p *datadogProvider) ApplyDefaults(*optimizev1beta2.Trial, *optimizev1beta2.Metric) error {
+	return nil
+}
 
-	applicationKey := os.Getenv("DATADOG_APP_KEY")
-	if applicationKey == "" {
-		applicationKey = os.Getenv("DD_APP_KEY")
+func (p *datadogProvider) Validate(m *optimizev1beta2.Metric) []error {
+	errs := validateFeatures(m, featureSamples)
+	if _, err := datadogAggregator(m); err != nil {
+		errs = append(errs, err)
 	}
+	return errs
+}
+
+func (p *datadogProvider) Capture(_ context.Context, _ logr.Logger, t *optimizev1beta2.Trial, m *optimizev1beta2.Metric, secret *corev1.Secret) (float64, float64, error) {
+	return captureDatadogMetric(m, secret, t.Status.StartTime.Time, t.Status.CompletionTime.Time)
+}
+
+func captureDatadogMetric(m *optimizev1beta2.Metric, secret *corev1.Secret, startTime, completionTime time.Time) (float64, float64, error) {
+	apiKey := credential(secret, "DATADOG_API_KEY", "DD_API_KEY")
+	applicationKey := credential(secret, "DATADOG_APP_KEY", "DD_APP_KEY")
 
 	client := datadog.NewClient(apiKey, applicationKey)
 
-	metrics, err := client.QueryMetrics(startTime.Unix(), completionTime.Unix(), m.Query)
+	baseURL, err := datadogBaseURL(m)
 	if err != nil {
 		return 0, 0, err
 	}
+	if baseURL != "" {
+		client.SetBaseUrl(baseURL)
+	}
 
-	if len(metrics) != 1 {
-		return 0, 0, fmt.Errorf("expected one series")
+	aggregator, err := datadogAggregator(m)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	u, err := url.Parse(m.URL)
+	metrics, err := client.QueryMetrics(startTime.Unix(), completionTime.Unix(), m.Query)
 	if err != nil {
 		return 0, 0, err
 	}
-	aggregator := u.Query().Get("aggregator")
+
+	if len(metrics) != 1 {
+		return 0, 0, fmt.Errorf("expected one series")
+	}
 
 	var value, n float64
 	for _, p := range metrics[0].Points {
@@ -84,3 +109,36 @@ func captureDatadogMetric(m *optimizev1beta2.Metric, startTime, completionTime t
 
 	return value, math.NaN(), nil
 }
+
+// datadogBaseURL returns the API endpoint specified using the scheme and host of the metric URL, e.g.
+// "https://api.datadoghq.eu" or "https://api.us3.datadoghq.com" for sites other than the default.
+func datadogBaseURL(m *optimizev1beta2.Metric) (string, error) {
+	u, err := url.Parse(m.URL)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Host == "" {
+		return "", nil
+	}
+	if u.Scheme == "" {
+		u.Scheme = "https"
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// datadogAggregator returns the aggregator specified using the "aggregator" query parameter on the metric URL.
+func datadogAggregator(m *optimizev1beta2.Metric) (string, error) {
+	u, err := url.Parse(m.URL)
+	if err != nil {
+		return "", err
+	}
+
+	aggregator := u.Query().Get("aggregator")
+	switch aggregator {
+	case "avg", "last", "max", "min", "sum", "":
+		return aggregator, nil
+	default:
+		return "", fmt.Errorf("unsupported aggregator: %s (expected: avg, last, max, min, sum)", aggregator)
+	}
+}
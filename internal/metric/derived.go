@@ -0,0 +1,155 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func init() {
+	Register(optimizev1beta2.MetricDerived, &derivedProvider{})
+}
+
+// derivedProvider captures values by evaluating an arithmetic expression over the other metric values of the trial.
+type derivedProvider struct{}
+
+func (p *derivedProvider) ApplyDefaults(*optimizev1beta2.Trial, *optimizev1beta2.Metric) error {
+	return nil
+}
+
+func (p *derivedProvider) Validate(m *optimizev1beta2.Metric) []error {
+	errs := validateFeatures(m, 0)
+	deps, err := Dependencies(m)
+	if err != nil {
+		return append(errs, err)
+	}
+	for _, d := range deps {
+		if d == m.Name {
+			return append(errs, fmt.Errorf("derived metric %q references itself", m.Name))
+		}
+	}
+	return errs
+}
+
+func (p *derivedProvider) Capture(_ context.Context, _ logr.Logger, t *optimizev1beta2.Trial, m *optimizev1beta2.Metric, _ *corev1.Secret) (float64, float64, error) {
+	e, err := parseExpression(m.Query)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Only consider values which have been successfully collected
+	values := make(map[string]float64, len(t.Spec.Values))
+	for _, v := range t.Spec.Values {
+		if v.AttemptsRemaining > 0 || v.Value == "" {
+			continue
+		}
+		fv, err := strconv.ParseFloat(v.Value, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		values[v.Name] = fv
+	}
+
+	value, err := e.eval(values)
+	if err != nil {
+		return 0, 0, err
+	}
+	return value, math.NaN(), nil
+}
+
+// Dependencies returns the names of the metrics whose values are required to capture the supplied metric.
+func Dependencies(m *optimizev1beta2.Metric) ([]string, error) {
+	if m.Type != optimizev1beta2.MetricDerived {
+		return nil, nil
+	}
+
+	e, err := parseExpression(m.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	var deps []string
+	seen := make(map[string]bool)
+	for _, name := range e.refs(nil) {
+		if !seen[name] {
+			seen[name] = true
+			deps = append(deps, name)
+		}
+	}
+	return deps, nil
+}
+
+// CheckDependencies verifies that all the metric dependencies can be resolved, an error is returned if a metric
+// references an unknown metric or if metrics depend on each other.
+func CheckDependencies(metrics []optimizev1beta2.Metric) error {
+	deps := make(map[string][]string, len(metrics))
+	for i := range metrics {
+		d, err := Dependencies(&metrics[i])
+		if err != nil {
+			return fmt.Errorf("metric %q: %w", metrics[i].Name, err)
+		}
+		deps[metrics[i].Name] = d
+	}
+
+	for name, d := range deps {
+		for _, dep := range d {
+			if _, ok := deps[dep]; !ok {
+				return fmt.Errorf("metric %q references unknown metric %q", name, dep)
+			}
+		}
+	}
+
+	// Depth first search for cycles
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(metrics))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("metric dependency cycle: %s", strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		for _, dep := range deps[name] {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for i := range metrics {
+		if err := visit(metrics[i].Name, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
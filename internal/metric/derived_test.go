@@ -0,0 +1,148 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+)
+
+func TestParseExpression(t *testing.T) {
+	values := map[string]float64{"cost": 12, "requests": 3000, "p95-latency": 0.25, "throughput": 50}
+
+	testCases := []struct {
+		expr          string
+		expected      float64
+		expectedRefs  []string
+		expectedError string
+	}{
+		{expr: "cost / requests * 1000", expected: 4, expectedRefs: []string{"cost", "requests"}},
+		{expr: `"p95-latency" / throughput`, expected: 0.005, expectedRefs: []string{"p95-latency", "throughput"}},
+		{expr: "1 + 2 * 3", expected: 7},
+		{expr: "(1 + 2) * 3", expected: 9},
+		{expr: "10 - 4 - 3", expected: 3},
+		{expr: "-cost + 2", expected: -10, expectedRefs: []string{"cost"}},
+		{expr: "1.5e3 / 1e-1", expected: 15000},
+		{expr: "cost / 0", expectedRefs: []string{"cost"}, expectedError: "division by zero"},
+		{expr: "missing * 2", expectedRefs: []string{"missing"}, expectedError: `metric "missing" does not have a value`},
+		{expr: "cost /", expectedError: "invalid expression at position 7: unexpected end of expression"},
+		{expr: "(cost", expectedError: "invalid expression at position 6: missing closing parenthesis"},
+		{expr: "cost requests", expectedError: "invalid expression at position 6: unexpected 'r'"},
+		{expr: `"cost`, expectedError: "invalid expression at position 1: missing closing quote"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.expr, func(t *testing.T) {
+			e, err := parseExpression(tc.expr)
+			if err == nil {
+				assert.Equal(t, tc.expectedRefs, e.refs(nil))
+
+				var v float64
+				v, err = e.eval(values)
+				if tc.expectedError == "" {
+					assert.InDelta(t, tc.expected, v, 1e-9)
+				}
+			}
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDerivedCapture(t *testing.T) {
+	trial := &optimizev1beta2.Trial{
+		Spec: optimizev1beta2.TrialSpec{
+			Values: []optimizev1beta2.Value{
+				{Name: "cost", Value: "12"},
+				{Name: "requests", Value: "3000"},
+				{Name: "latency", AttemptsRemaining: 3},
+			},
+		},
+	}
+
+	value, _, err := CaptureMetric(context.TODO(), nil, nil, trial, &optimizev1beta2.Metric{
+		Name:  "cost-per-1k",
+		Type:  optimizev1beta2.MetricDerived,
+		Query: "cost / requests * 1000",
+	}, nil, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 4.0, value)
+	}
+
+	_, _, err = CaptureMetric(context.TODO(), nil, nil, trial, &optimizev1beta2.Metric{
+		Name:  "latency-per-request",
+		Type:  optimizev1beta2.MetricDerived,
+		Query: "latency / requests",
+	}, nil, nil)
+	assert.EqualError(t, err, `metric "latency" does not have a value`)
+}
+
+func TestCheckDependencies(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		metrics       []optimizev1beta2.Metric
+		expectedError string
+	}{
+		{
+			desc: "valid",
+			metrics: []optimizev1beta2.Metric{
+				{Name: "cost-per-1k", Type: optimizev1beta2.MetricDerived, Query: "cost / requests * 1000"},
+				{Name: "cost", Type: optimizev1beta2.MetricDatadog, Query: "sum:cost{*}"},
+				{Name: "requests", Type: optimizev1beta2.MetricPrometheus, Query: "scalar(sum(requests))"},
+			},
+		},
+		{
+			desc: "unknown",
+			metrics: []optimizev1beta2.Metric{
+				{Name: "cost-per-1k", Type: optimizev1beta2.MetricDerived, Query: "cost / requests * 1000"},
+				{Name: "cost", Type: optimizev1beta2.MetricDatadog, Query: "sum:cost{*}"},
+			},
+			expectedError: `metric "cost-per-1k" references unknown metric "requests"`,
+		},
+		{
+			desc: "cycle",
+			metrics: []optimizev1beta2.Metric{
+				{Name: "a", Type: optimizev1beta2.MetricDerived, Query: "b + 1"},
+				{Name: "b", Type: optimizev1beta2.MetricDerived, Query: "c * 2"},
+				{Name: "c", Type: optimizev1beta2.MetricDerived, Query: "a / 3"},
+			},
+			expectedError: "metric dependency cycle: a -> b -> c -> a",
+		},
+		{
+			desc: "invalid",
+			metrics: []optimizev1beta2.Metric{
+				{Name: "a", Type: optimizev1beta2.MetricDerived, Query: "b +"},
+			},
+			expectedError: `metric "a": invalid expression at position 4: unexpected end of expression`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := CheckDependencies(tc.metrics)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func init() {
+	Register(optimizev1beta2.MetricElasticsearch, &elasticsearchProvider{})
+}
+
+// elasticsearchProvider captures values by running an aggregation query against an Elasticsearch (or OpenSearch)
+// index. The value is read from the aggregation identified by the "path" query parameter on the metric URL, the
+// parameter may be omitted if the query only has a single aggregation.
+type elasticsearchProvider struct{}
+
+func (p *elasticsearchProvider) ApplyDefaults(*optimizev1beta2.Trial, *optimizev1beta2.Metric) error {
+	return nil
+}
+
+func (p *elasticsearchProvider) Validate(m *optimizev1beta2.Metric) []error {
+	errs := validateFeatures(m, featureTLS|featureTemplatedSamples)
+	if m.URL == "" {
+		errs = append(errs, fmt.Errorf("Elasticsearch metric requires a URL"))
+	} else if _, err := url.Parse(m.URL); err != nil {
+		errs = append(errs, err)
+	}
+
+	query := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(m.Query), &query); err != nil {
+		errs = append(errs, fmt.Errorf("Elasticsearch query must be a JSON search request: %w", err))
+	} else if query["aggs"] == nil && query["aggregations"] == nil {
+		errs = append(errs, fmt.Errorf("Elasticsearch query must include an aggregation"))
+	}
+	return errs
+}
+
+func (p *elasticsearchProvider) Capture(ctx context.Context, _ logr.Logger, _ *optimizev1beta2.Trial, m *optimizev1beta2.Metric, secret *corev1.Secret) (float64, float64, error) {
+	return captureElasticsearchMetric(ctx, m, secret)
+}
+
+func captureElasticsearchMetric(ctx context.Context, m *optimizev1beta2.Metric, secret *corev1.Secret) (float64, float64, error) {
+	rt, err := newRoundTripper(m, secret)
+	if err != nil {
+		return 0, 0, err
+	}
+	client := &http.Client{Timeout: httpTimeout, Transport: rt}
+
+	// Build the search request, the "path" parameter is only used locally
+	u, err := url.Parse(m.URL)
+	if err != nil {
+		return 0, 0, err
+	}
+	q := u.Query()
+	aggPath := q.Get("path")
+	q.Del("path")
+	u.RawQuery = q.Encode()
+	if path.Base(u.Path) != "_search" {
+		u.Path = path.Join("/", u.Path, "_search")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(m.Query))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+
+	// API keys are the preferred authentication mechanism, they are sent as the encoded "id:api_key" value
+	if apiKey := credential(secret, "ELASTICSEARCH_API_KEY", "OPENSEARCH_API_KEY"); apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+apiKey)
+	} else if username := credential(secret, "ELASTICSEARCH_USERNAME", "OPENSEARCH_USERNAME"); username != "" {
+		req.SetBasicAuth(username, credential(secret, "ELASTICSEARCH_PASSWORD", "OPENSEARCH_PASSWORD"))
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var result elasticsearchResponse
+	if err := checkHTTPResponse(m, resp); err != nil {
+		// Include the reason reported by Elasticsearch
+		if ce, ok := err.(*CaptureError); ok && ce.RetryAfter == 0 {
+			if json.NewDecoder(resp.Body).Decode(&result) == nil && result.Error.Reason != "" {
+				ce.Message = fmt.Sprintf("%s: %s", ce.Message, result.Error.Reason)
+			}
+		}
+		return 0, 0, err
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, 0, err
+	}
+	if result.TimedOut {
+		return 0, 0, &CaptureError{Message: "Elasticsearch query timed out", Address: m.URL, Query: m.Query}
+	}
+
+	value, err := elasticsearchAggregationValue(result.Aggregations, aggPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	if value == nil {
+		return 0, 0, &CaptureError{Message: "metric data not available", Address: m.URL, Query: m.Query}
+	}
+	return *value, math.NaN(), nil
+}
+
+// elasticsearchResponse is the subset of the search response used to capture values.
+type elasticsearchResponse struct {
+	TimedOut     bool                   `json:"timed_out"`
+	Aggregations map[string]interface{} `json:"aggregations"`
+	Error        struct {
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// elasticsearchAggregationValue returns the value found at the dot separated path of the aggregations. Path segments
+// are matched against the longest possible key so keys containing dots (e.g. percentiles) do not need to be escaped.
+// If the path ends on an aggregation object, the single "value" (or "values" entry) of that aggregation is used.
+// A nil value is returned if the aggregation does not have a value (e.g. there were no matching documents).
+func elasticsearchAggregationValue(aggs map[string]interface{}, aggPath string) (*float64, error) {
+	if len(aggs) == 0 {
+		return nil, fmt.Errorf("Elasticsearch response does not contain any aggregations")
+	}
+
+	var current interface{} = aggs
+	if aggPath == "" {
+		if len(aggs) != 1 {
+			return nil, fmt.Errorf("Elasticsearch response contains multiple aggregations, a path is required")
+		}
+		for _, agg := range aggs {
+			current = agg
+		}
+	}
+
+	for remaining := aggPath; remaining != ""; {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("aggregation path %q does not match the response", aggPath)
+		}
+
+		var found bool
+		for key := remaining; key != ""; {
+			if v, ok := obj[key]; ok {
+				current, found = v, true
+				remaining = strings.TrimPrefix(strings.TrimPrefix(remaining, key), ".")
+				break
+			}
+			if i := strings.LastIndexByte(key, '.'); i >= 0 {
+				key = key[:i]
+			} else {
+				key = ""
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("aggregation path %q does not match the response", aggPath)
+		}
+	}
+
+	// Single value metric aggregations (e.g. "avg") use "value", percentiles use "values"
+	if obj, ok := current.(map[string]interface{}); ok {
+		if v, ok := obj["value"]; ok {
+			current = v
+		} else if values, ok := obj["values"].(map[string]interface{}); ok && len(values) == 1 {
+			for _, v := range values {
+				current = v
+			}
+		} else {
+			keys := make([]string, 0, len(obj))
+			for k := range obj {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return nil, fmt.Errorf("aggregation path %q does not identify a single value (found: %s)", aggPath, strings.Join(keys, ", "))
+		}
+	}
+
+	switch v := current.(type) {
+	case nil:
+		return nil, nil
+	case float64:
+		return &v, nil
+	default:
+		return nil, fmt.Errorf("aggregation path %q does not identify a numeric value", aggPath)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestElasticsearchCapture(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "ApiKey dGVzdDprZXk=" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"reason":"missing authentication credentials"},"status":401}`))
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/apm-*/_search" || r.URL.Query().Get("path") != "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var query struct {
+			Aggs map[string]interface{} `json:"aggs"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"reason":"failed to parse search request"},"status":400}`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case query.Aggs["empty"] != nil:
+			_, _ = w.Write([]byte(`{"timed_out":false,"aggregations":{"empty":{"value":null}}}`))
+		default:
+			_, _ = w.Write([]byte(`{"timed_out":false,"aggregations":{
+				"latency":{"value":125.5},
+				"p95":{"values":{"95.0":310.25}},
+				"by_service":{"doc_count":10,"max_latency":{"value":400}}
+			}}`))
+		}
+	}))
+	defer srv.Close()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "elasticsearch"},
+		Data:       map[string][]byte{"ELASTICSEARCH_API_KEY": []byte("dGVzdDprZXk=")},
+	}
+
+	testCases := []struct {
+		desc          string
+		url           string
+		query         string
+		secret        *corev1.Secret
+		expected      float64
+		expectedError string
+	}{
+		{
+			desc:     "single value",
+			url:      srv.URL + "/apm-*?path=latency",
+			query:    `{"size":0,"aggs":{"latency":{"avg":{"field":"transaction.duration.us"}}}}`,
+			secret:   secret,
+			expected: 125.5,
+		},
+		{
+			desc:     "percentile",
+			url:      srv.URL + "/apm-*/_search?path=p95.values.95.0",
+			query:    `{"size":0,"aggs":{"p95":{"percentiles":{"field":"transaction.duration.us","percents":[95]}}}}`,
+			secret:   secret,
+			expected: 310.25,
+		},
+		{
+			desc:     "nested",
+			url:      srv.URL + "/apm-*?path=by_service.max_latency",
+			query:    `{"size":0,"aggs":{"by_service":{}}}`,
+			secret:   secret,
+			expected: 400,
+		},
+		{
+			desc:          "ambiguous",
+			url:           srv.URL + "/apm-*",
+			query:         `{"size":0,"aggs":{"latency":{}}}`,
+			secret:        secret,
+			expectedError: "Elasticsearch response contains multiple aggregations, a path is required",
+		},
+		{
+			desc:          "not a value",
+			url:           srv.URL + "/apm-*?path=by_service",
+			query:         `{"size":0,"aggs":{"by_service":{}}}`,
+			secret:        secret,
+			expectedError: `aggregation path "by_service" does not identify a single value (found: doc_count, max_latency)`,
+		},
+		{
+			desc:          "no data",
+			url:           srv.URL + "/apm-*",
+			query:         `{"size":0,"aggs":{"empty":{}}}`,
+			secret:        secret,
+			expectedError: "metric data not available",
+		},
+		{
+			desc:          "unauthorized",
+			url:           srv.URL + "/apm-*?path=latency",
+			query:         `{"size":0,"aggs":{"latency":{}}}`,
+			secret:        &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "empty"}},
+			expectedError: "unexpected HTTP response status: 401 Unauthorized: missing authentication credentials",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			value, _, err := captureElasticsearchMetric(context.TODO(), &optimizev1beta2.Metric{
+				Name:  "test",
+				Type:  optimizev1beta2.MetricElasticsearch,
+				URL:   tc.url,
+				Query: tc.query,
+			}, tc.secret)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tc.expected, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expression is a parsed arithmetic expression over metric values.
+type expression interface {
+	// eval returns the value of the expression using the supplied metric values.
+	eval(values map[string]float64) (float64, error)
+	// refs appends the names of the metrics referenced by the expression.
+	refs(names []string) []string
+}
+
+type numberExpr float64
+
+func (e numberExpr) eval(map[string]float64) (float64, error) { return float64(e), nil }
+func (e numberExpr) refs(names []string) []string             { return names }
+
+type refExpr string
+
+func (e refExpr) eval(values map[string]float64) (float64, error) {
+	if v, ok := values[string(e)]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("metric %q does not have a value", string(e))
+}
+
+func (e refExpr) refs(names []string) []string { return append(names, string(e)) }
+
+type negExpr struct{ x expression }
+
+func (e *negExpr) eval(values map[string]float64) (float64, error) {
+	x, err := e.x.eval(values)
+	return -x, err
+}
+
+func (e *negExpr) refs(names []string) []string { return e.x.refs(names) }
+
+type binaryExpr struct {
+	op   byte
+	x, y expression
+}
+
+func (e *binaryExpr) eval(values map[string]float64) (float64, error) {
+	x, err := e.x.eval(values)
+	if err != nil {
+		return 0, err
+	}
+	y, err := e.y.eval(values)
+	if err != nil {
+		return 0, err
+	}
+
+	switch e.op {
+	case '+':
+		return x + y, nil
+	case '-':
+		return x - y, nil
+	case '*':
+		return x * y, nil
+	case '/':
+		if y == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return x / y, nil
+	default:
+		return 0, fmt.Errorf("unknown operator %q", e.op)
+	}
+}
+
+func (e *binaryExpr) refs(names []string) []string { return e.y.refs(e.x.refs(names)) }
+
+// parseExpression parses an arithmetic expression. Expressions consist of numbers, metric names, the binary operators
+// "+", "-", "*" and "/", unary negation and parenthesis. Metric names which are not simple identifiers (e.g. names
+// containing a "-") must be double quoted.
+func parseExpression(s string) (expression, error) {
+	p := &exprParser{s: s}
+	e, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.pos])
+	}
+	return e, nil
+}
+
+// exprParser is a recursive descent parser for arithmetic expressions.
+type exprParser struct {
+	s   string
+	pos int
+}
+
+func (p *exprParser) parseSum() (expression, error) {
+	x, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.skipSpace(); p.pos < len(p.s) && (p.s[p.pos] == '+' || p.s[p.pos] == '-'); p.skipSpace() {
+		op := p.s[p.pos]
+		p.pos++
+		y, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: op, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *exprParser) parseProduct() (expression, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.skipSpace(); p.pos < len(p.s) && (p.s[p.pos] == '*' || p.s[p.pos] == '/'); p.skipSpace() {
+		op := p.s[p.pos]
+		p.pos++
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{op: op, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *exprParser) parseUnary() (expression, error) {
+	if p.skipSpace(); p.pos < len(p.s) && p.s[p.pos] == '-' {
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negExpr{x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (expression, error) {
+	if p.skipSpace(); p.pos >= len(p.s) {
+		return nil, p.errorf("unexpected end of expression")
+	}
+
+	start := p.pos
+	switch c := p.s[p.pos]; {
+	case c == '(':
+		p.pos++
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.skipSpace(); p.pos >= len(p.s) || p.s[p.pos] != ')' {
+			return nil, p.errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return x, nil
+
+	case c == '"':
+		end := strings.IndexByte(p.s[start+1:], '"')
+		if end < 0 {
+			return nil, p.errorf("missing closing quote")
+		}
+		p.pos = start + end + 2
+		return refExpr(p.s[start+1 : p.pos-1]), nil
+
+	case c == '.' || isDigit(c):
+		for p.pos < len(p.s) && (p.s[p.pos] == '.' || isDigit(p.s[p.pos]) ||
+			p.s[p.pos] == 'e' || p.s[p.pos] == 'E' ||
+			((p.s[p.pos] == '+' || p.s[p.pos] == '-') && (p.s[p.pos-1] == 'e' || p.s[p.pos-1] == 'E'))) {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", p.s[start:p.pos])
+		}
+		return numberExpr(v), nil
+
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for p.pos < len(p.s) && (p.s[p.pos] == '_' || isDigit(p.s[p.pos]) || unicode.IsLetter(rune(p.s[p.pos]))) {
+			p.pos++
+		}
+		return refExpr(p.s[start:p.pos]), nil
+
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid expression at position %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/transport"
+)
+
+const (
+	// httpTimeout is the overall time limit for HTTP requests to remote metric sources
+	httpTimeout = 10 * time.Second
+	// httpRetryAfter is the default delay before retrying a request that was throttled or refused
+	httpRetryAfter = 5 * time.Second
+)
+
+// newHTTPRequest returns a new request for the metric URL using the (rendered) HTTP request configuration.
+func newHTTPRequest(ctx context.Context, m *optimizev1beta2.Metric) (*http.Request, error) {
+	method := http.MethodGet
+	var body string
+	if m.HTTP != nil {
+		method = httpMethod(m.HTTP)
+		body = m.HTTP.Body
+	}
+	if method == http.MethodGet {
+		body = ""
+	}
+
+	req, err := http.NewRequest(method, m.URL, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if m.HTTP != nil {
+		for _, h := range m.HTTP.Headers {
+			req.Header.Set(h.Name, h.Value)
+		}
+	}
+
+	return req.WithContext(ctx), nil
+}
+
+// httpMethod returns the normalized HTTP request method.
+func httpMethod(r *optimizev1beta2.MetricHTTPRequest) string {
+	switch {
+	case r.Method != "":
+		return strings.ToUpper(r.Method)
+	case r.Body != "":
+		return http.MethodPost
+	default:
+		return http.MethodGet
+	}
+}
+
+// checkHTTPResponse returns an error for non-2xx responses. Responses indicating the remote metric source is throttling
+// requests or is temporarily unavailable are returned as a `CaptureError` so the request can be retried.
+func checkHTTPResponse(m *optimizev1beta2.Metric, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err := &CaptureError{
+		Message: fmt.Sprintf("unexpected HTTP response status: %s", resp.Status),
+		Address: m.URL,
+		Query:   m.Query,
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		err.RetryAfter = retryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+
+	return err
+}
+
+// retryAfter parses the value of a "Retry-After" header, which may be either a delay in seconds or an HTTP date.
+func retryAfter(value string, now time.Time) time.Duration {
+	if s, err := strconv.Atoi(value); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return httpRetryAfter
+}
+
+// newRoundTripper returns a round tripper for querying a remote metric source. The TLS configuration comes from
+// the metric while the certificates and credentials are read from well known keys of the metric's secret:
+//   - "token" for bearer token authentication (compatible with service account token secrets)
+//   - "username" and "password" for basic authentication (compatible with basic authentication secrets)
+//   - "ca.crt" for the certificate authority used to verify the server (only when the TLS configuration allows it)
+//   - "tls.crt" and "tls.key" for client certificate authentication (compatible with TLS secrets)
+func newRoundTripper(m *optimizev1beta2.Metric, secret *corev1.Secret) (http.RoundTripper, error) {
+	rt := http.DefaultTransport
+
+	tlsConfig, err := newTLSConfig(m, secret)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = tlsConfig
+		t.DisableKeepAlives = true // We do not hold on to the transport, do not leave idle connections behind
+		rt = t
+	}
+
+	if token := secretData(secret, corev1.ServiceAccountTokenKey); len(token) > 0 {
+		return transport.NewBearerAuthRoundTripper(string(token), rt), nil
+	}
+
+	if username := secretData(secret, corev1.BasicAuthUsernameKey); len(username) > 0 {
+		password := secretData(secret, corev1.BasicAuthPasswordKey)
+		return transport.NewBasicAuthRoundTripper(string(username), string(password), rt), nil
+	}
+
+	return rt, nil
+}
+
+// validateTLS checks the TLS configuration of a metric.
+func validateTLS(m *optimizev1beta2.Metric) []error {
+	if m.TLS == nil {
+		return nil
+	}
+
+	var errs []error
+	if u, err := url.Parse(m.URL); err == nil && m.URL != "" && u.Scheme != "https" {
+		errs = append(errs, Warning("TLS configuration is ignored without an HTTPS URL"))
+	}
+	if m.TLS.InsecureSkipVerify {
+		errs = append(errs, Warning("TLS certificate verification is disabled"))
+	}
+	if m.TLS.UseSecretCA && m.SecretRef == nil {
+		errs = append(errs, fmt.Errorf("TLS configuration uses the secret CA but no secret is referenced"))
+	}
+	return errs
+}
+
+// newTLSConfig returns the TLS configuration for the supplied metric, nil is returned if the default
+// configuration should be used.
+func newTLSConfig(m *optimizev1beta2.Metric, secret *corev1.Secret) (*tls.Config, error) {
+	// The CA is opt-in, service account token secrets include the cluster CA which is not used by external servers
+	var ca []byte
+	if m.TLS != nil && m.TLS.UseSecretCA {
+		ca = secretData(secret, corev1.ServiceAccountRootCAKey)
+		if len(ca) == 0 {
+			return nil, fmt.Errorf("metric TLS configuration requires a secret containing %s", corev1.ServiceAccountRootCAKey)
+		}
+	}
+	cert, key := secretData(secret, corev1.TLSCertKey), secretData(secret, corev1.TLSPrivateKeyKey)
+	if m.TLS == nil && len(ca) == 0 && len(cert) == 0 && len(key) == 0 {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{}
+
+	if m.TLS != nil {
+		cfg.ServerName = m.TLS.ServerName
+		cfg.InsecureSkipVerify = m.TLS.InsecureSkipVerify
+	}
+
+	if len(ca) > 0 {
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("secret %q contains an invalid %s", secret.Name, corev1.ServiceAccountRootCAKey)
+		}
+	}
+
+	if len(cert) > 0 || len(key) > 0 {
+		c, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("secret %q contains an invalid client certificate: %w", secret.Name, err)
+		}
+		cfg.Certificates = []tls.Certificate{c}
+	}
+
+	return cfg, nil
+}
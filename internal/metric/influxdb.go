@@ -0,0 +1,314 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func init() {
+	Register(optimizev1beta2.MetricInfluxDB, &influxDBProvider{})
+}
+
+// influxDBProvider captures values using Flux or InfluxQL queries against an InfluxDB server. Flux queries are used
+// unless the metric URL includes a "db" query parameter. The query result must be a single table (or series), multiple
+// rows are reduced using the "aggregator" query parameter on the metric URL.
+type influxDBProvider struct{}
+
+func (p *influxDBProvider) ApplyDefaults(*optimizev1beta2.Trial, *optimizev1beta2.Metric) error {
+	return nil
+}
+
+func (p *influxDBProvider) Validate(m *optimizev1beta2.Metric) []error {
+	errs := validateFeatures(m, featureTLS|featureSamples)
+	if m.URL == "" {
+		errs = append(errs, fmt.Errorf("InfluxDB metric requires a URL"))
+	} else if _, err := influxDBAggregator(m); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func (p *influxDBProvider) Capture(ctx context.Context, _ logr.Logger, t *optimizev1beta2.Trial, m *optimizev1beta2.Metric, secret *corev1.Secret) (float64, float64, error) {
+	return captureInfluxDBMetric(ctx, m, secret, t.Status.StartTime.Time, t.Status.CompletionTime.Time)
+}
+
+func captureInfluxDBMetric(ctx context.Context, m *optimizev1beta2.Metric, secret *corev1.Secret, startTime, completionTime time.Time) (float64, float64, error) {
+	aggregator, err := influxDBAggregator(m)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rt, err := newRoundTripper(m, secret)
+	if err != nil {
+		return 0, 0, err
+	}
+	client := &http.Client{Timeout: httpTimeout, Transport: rt}
+
+	// Build a Flux or InfluxQL request depending on the URL
+	u, err := url.Parse(m.URL)
+	if err != nil {
+		return 0, 0, err
+	}
+	q := u.Query()
+	q.Del("aggregator")
+
+	var req *http.Request
+	var parse func(io.Reader) ([]float64, error)
+	if q.Get("db") != "" {
+		req, err = newInfluxQLRequest(u, q, m.Query, startTime, completionTime)
+		parse = parseInfluxQLResponse
+	} else {
+		if q.Get("org") == "" && q.Get("orgID") == "" {
+			if org := credential(secret, "INFLUXDB_ORG", "INFLUX_ORG"); org != "" {
+				q.Set("org", org)
+			}
+		}
+		req, err = newFluxRequest(u, q, m.Query, startTime, completionTime)
+		parse = parseFluxResponse
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Token authentication is used by InfluxDB 2.x (and the 1.x compatibility API)
+	if token := credential(secret, "INFLUXDB_TOKEN", "INFLUX_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	} else if username := credential(secret, "INFLUXDB_USERNAME"); username != "" {
+		req.SetBasicAuth(username, credential(secret, "INFLUXDB_PASSWORD"))
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if err := checkHTTPResponse(m, resp); err != nil {
+		return 0, 0, err
+	}
+
+	values, err := parse(resp.Body)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	value, _, err := aggregate(aggregator, values)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if math.IsNaN(value) {
+		return 0, 0, &CaptureError{Message: "metric data not available", Address: m.URL, Query: m.Query}
+	}
+
+	return value, math.NaN(), nil
+}
+
+// newFluxRequest returns a request to the InfluxDB 2.x query API. The trial run is exposed to the query using the
+// same `v.timeRangeStart` and `v.timeRangeStop` variables as the InfluxDB UI.
+func newFluxRequest(u *url.URL, q url.Values, query string, startTime, completionTime time.Time) (*http.Request, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query": fmt.Sprintf("option v = {timeRangeStart: %s, timeRangeStop: %s}\n%s",
+			startTime.UTC().Format(time.RFC3339Nano), completionTime.UTC().Format(time.RFC3339Nano), query),
+		"type": "flux",
+		"dialect": map[string]interface{}{
+			"header":      true,
+			"annotations": []string{},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	u = &url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host, Path: path.Join(u.Path, "/api/v2/query"), RawQuery: q.Encode()}
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/csv")
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// newInfluxQLRequest returns a request to the InfluxDB 1.x query API. The trial run is exposed to the query using the
+// `$start` and `$stop` bound parameters.
+func newInfluxQLRequest(u *url.URL, q url.Values, query string, startTime, completionTime time.Time) (*http.Request, error) {
+	params, err := json.Marshal(map[string]string{
+		"start": startTime.UTC().Format(time.RFC3339Nano),
+		"stop":  completionTime.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	q.Set("q", query)
+	q.Set("params", string(params))
+	q.Set("epoch", "ms")
+
+	u = &url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host, Path: path.Join(u.Path, "/query"), RawQuery: q.Encode()}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// parseFluxResponse returns the "_value" column of a Flux CSV response containing a single table.
+func parseFluxResponse(r io.Reader) ([]float64, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	var values []float64
+	tableCol, valueCol := -1, -1
+	var table string
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		// Each table may include its own header row
+		if idx := indexOf(record, "_value"); idx >= 0 {
+			tableCol, valueCol = indexOf(record, "table"), idx
+			continue
+		}
+		if valueCol < 0 || valueCol >= len(record) {
+			return nil, fmt.Errorf("expected Flux result to contain a _value column")
+		}
+
+		if tableCol >= 0 && tableCol < len(record) {
+			if table != "" && table != record[tableCol] {
+				return nil, fmt.Errorf("expected Flux result to contain one table")
+			}
+			table = record[tableCol]
+		}
+
+		if record[valueCol] == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(record[valueCol], 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+// parseInfluxQLResponse returns the first value column of an InfluxQL JSON response containing a single series.
+func parseInfluxQLResponse(r io.Reader) ([]float64, error) {
+	var resp struct {
+		Results []struct {
+			Series []struct {
+				Columns []string        `json:"columns"`
+				Values  [][]interface{} `json:"values"`
+			} `json:"series"`
+			Error string `json:"error"`
+		} `json:"results"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Error != "" {
+		return nil, fmt.Errorf("InfluxQL query failed: %s", resp.Error)
+	}
+	if len(resp.Results) != 1 {
+		return nil, fmt.Errorf("expected InfluxQL result for one statement")
+	}
+	if resp.Results[0].Error != "" {
+		return nil, fmt.Errorf("InfluxQL query failed: %s", resp.Results[0].Error)
+	}
+
+	switch len(resp.Results[0].Series) {
+	case 0:
+		return nil, nil
+	case 1:
+	default:
+		return nil, fmt.Errorf("expected InfluxQL result to contain one series")
+	}
+
+	series := resp.Results[0].Series[0]
+	valueCol := -1
+	for i, c := range series.Columns {
+		if c != "time" {
+			valueCol = i
+			break
+		}
+	}
+	if valueCol < 0 {
+		return nil, fmt.Errorf("expected InfluxQL result to contain a value column")
+	}
+
+	var values []float64
+	for _, row := range series.Values {
+		if valueCol >= len(row) {
+			continue
+		}
+		if v, ok := row[valueCol].(float64); ok {
+			values = append(values, v)
+		}
+	}
+
+	return values, nil
+}
+
+// influxDBAggregator returns the aggregator specified using the "aggregator" query parameter on the metric URL.
+func influxDBAggregator(m *optimizev1beta2.Metric) (string, error) {
+	u, err := url.Parse(m.URL)
+	if err != nil {
+		return "", err
+	}
+
+	aggregator := u.Query().Get("aggregator")
+	if _, _, err := aggregate(aggregator, []float64{0}); err != nil {
+		return "", err
+	}
+	return aggregator, nil
+}
+
+func indexOf(record []string, name string) int {
+	for i := range record {
+		if record[i] == name {
+			return i
+		}
+	}
+	return -1
+}
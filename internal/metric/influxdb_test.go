@@ -0,0 +1,157 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestInfluxDBCapture(t *testing.T) {
+	startTime := time.Date(2022, time.January, 1, 12, 0, 0, 0, time.UTC)
+	completionTime := startTime.Add(5 * time.Minute)
+
+	srv := influxDBHttpTestServer(t, "testToken", startTime, completionTime)
+	defer srv.Close()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "influxdb"},
+		Data: map[string][]byte{
+			"INFLUXDB_TOKEN": []byte("testToken"),
+			"INFLUXDB_ORG":   []byte("my-org"),
+		},
+	}
+
+	testCases := []struct {
+		desc          string
+		url           string
+		query         string
+		secret        *corev1.Secret
+		expected      float64
+		expectedError string
+	}{
+		{
+			desc:     "flux",
+			url:      srv.URL,
+			query:    `from(bucket: "k6") |> range(start: v.timeRangeStart, stop: v.timeRangeStop) |> filter(fn: (r) => r._measurement == "http_req_duration") |> mean()`,
+			secret:   secret,
+			expected: 12.5,
+		},
+		{
+			desc:     "flux aggregator",
+			url:      srv.URL + "/?aggregator=max",
+			query:    `from(bucket: "k6") |> range(start: v.timeRangeStart, stop: v.timeRangeStop) |> filter(fn: (r) => r._measurement == "vus")`,
+			secret:   secret,
+			expected: 20,
+		},
+		{
+			desc:          "flux multiple tables",
+			url:           srv.URL,
+			query:         `from(bucket: "k6") |> range(start: v.timeRangeStart, stop: v.timeRangeStop)`,
+			secret:        secret,
+			expectedError: "expected Flux result to contain one table",
+		},
+		{
+			desc:          "flux empty",
+			url:           srv.URL,
+			query:         `from(bucket: "empty") |> range(start: v.timeRangeStart, stop: v.timeRangeStop)`,
+			secret:        secret,
+			expectedError: "metric data not available",
+		},
+		{
+			desc:          "flux unauthorized",
+			url:           srv.URL,
+			query:         `from(bucket: "k6") |> range(start: v.timeRangeStart, stop: v.timeRangeStop) |> mean()`,
+			secret:        &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "influxdb"}},
+			expectedError: "unexpected HTTP response status: 401 Unauthorized",
+		},
+		{
+			desc:     "influxql",
+			url:      srv.URL + "/?db=k6",
+			query:    `SELECT mean("value") FROM "http_req_duration" WHERE time >= $start AND time <= $stop`,
+			secret:   secret,
+			expected: 12.5,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			m := &optimizev1beta2.Metric{Name: "testMetric", Type: optimizev1beta2.MetricInfluxDB, URL: tc.url, Query: tc.query}
+			value, _, err := captureInfluxDBMetric(context.TODO(), m, tc.secret, startTime, completionTime)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.expected, value)
+			}
+		})
+	}
+}
+
+func influxDBHttpTestServer(t *testing.T, token string, startTime, completionTime time.Time) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/api/v2/query":
+			assert.Equal(t, "my-org", r.URL.Query().Get("org"))
+			assert.Empty(t, r.URL.Query().Get("aggregator"))
+
+			body := struct {
+				Query string `json:"query"`
+			}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.True(t, strings.HasPrefix(body.Query, fmt.Sprintf("option v = {timeRangeStart: %s, timeRangeStop: %s}\n",
+				startTime.Format(time.RFC3339Nano), completionTime.Format(time.RFC3339Nano))))
+
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			switch {
+			case strings.Contains(body.Query, `"empty"`):
+				fmt.Fprint(w, ",result,table,_value\r\n")
+			case strings.Contains(body.Query, "mean()"):
+				fmt.Fprint(w, ",result,table,_start,_stop,_value\r\n,_result,0,2022-01-01T12:00:00Z,2022-01-01T12:05:00Z,12.5\r\n\r\n")
+			case strings.Contains(body.Query, `"vus"`):
+				fmt.Fprint(w, ",result,table,_time,_value\r\n,_result,0,2022-01-01T12:01:00Z,10\r\n,_result,0,2022-01-01T12:02:00Z,20\r\n,_result,0,2022-01-01T12:03:00Z,15\r\n\r\n")
+			default:
+				fmt.Fprint(w, ",result,table,_time,_value,_measurement\r\n,_result,0,2022-01-01T12:01:00Z,10,vus\r\n,_result,1,2022-01-01T12:01:00Z,0.5,http_req_failed\r\n\r\n")
+			}
+
+		case "/query":
+			assert.Equal(t, "k6", r.URL.Query().Get("db"))
+			assert.Equal(t, fmt.Sprintf(`{"start":%q,"stop":%q}`, startTime.Format(time.RFC3339Nano), completionTime.Format(time.RFC3339Nano)), r.URL.Query().Get("params"))
+			fmt.Fprint(w, `{"results":[{"statement_id":0,"series":[{"name":"http_req_duration","columns":["time","mean"],"values":[[1641038400000,12.5]]}]}]}`)
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
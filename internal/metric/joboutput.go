@@ -0,0 +1,141 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+const (
+	// jobOutputWait is how long to wait for the job output to be recorded after the trial completes
+	jobOutputWait = 2 * time.Minute
+	// jobOutputRetryAfter is the delay before checking for the job output again
+	jobOutputRetryAfter = 5 * time.Second
+)
+
+func init() {
+	Register(optimizev1beta2.MetricJobOutput, &jobOutputProvider{})
+}
+
+// jobOutputProvider captures values by parsing the output of the trial job containers.
+type jobOutputProvider struct{}
+
+func (p *jobOutputProvider) ApplyDefaults(*optimizev1beta2.Trial, *optimizev1beta2.Metric) error {
+	return nil
+}
+
+func (p *jobOutputProvider) Validate(m *optimizev1beta2.Metric) []error {
+	errs := validateFeatures(m, 0)
+	if isJSONPathQuery(m.Query) {
+		if err := jsonpath.New(m.Name).Parse(m.Query); err != nil {
+			errs = append(errs, fmt.Errorf("invalid JSON path query: %w", err))
+		}
+	} else if re, err := regexp.Compile(m.Query); err != nil {
+		errs = append(errs, fmt.Errorf("invalid regular expression query: %w", err))
+	} else if re.NumSubexp() > 1 {
+		errs = append(errs, Warning("Only the first capture group of the regular expression is used"))
+	}
+	if m.URL != "" || m.Target != nil {
+		errs = append(errs, Warning("Job output metrics do not use a URL or target"))
+	}
+	return errs
+}
+
+func (p *jobOutputProvider) Capture(_ context.Context, _ logr.Logger, t *optimizev1beta2.Trial, m *optimizev1beta2.Metric, _ *corev1.Secret) (float64, float64, error) {
+	output, ok := t.Annotations[optimizev1beta2.AnnotationJobOutput]
+	if !ok {
+		return 0, 0, fmt.Errorf("no output was recorded for the trial job")
+	}
+
+	// The output is recorded shortly after the job completes, give the trial job controller a chance to catch up
+	if output == "" {
+		if t.Status.CompletionTime != nil && time.Since(t.Status.CompletionTime.Time) < jobOutputWait {
+			return 0, 0, &CaptureError{Message: "waiting for the trial job output to be recorded", RetryAfter: jobOutputRetryAfter}
+		}
+		return 0, 0, fmt.Errorf("no output was recorded for the trial job")
+	}
+
+	var value float64
+	var err error
+	if isJSONPathQuery(m.Query) {
+		value, err = parseJSONOutput(m.Name, m.Query, output)
+	} else {
+		value, err = parseTextOutput(m.Query, output)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return value, math.NaN(), nil
+}
+
+// isJSONPathQuery checks to see if the query should be evaluated as a JSON path expression.
+func isJSONPathQuery(query string) bool {
+	return strings.HasPrefix(strings.TrimSpace(query), "{")
+}
+
+// parseTextOutput returns the first capture group (or the entire match) of the last match of the regular expression.
+func parseTextOutput(query, output string) (float64, error) {
+	re, err := regexp.Compile(query)
+	if err != nil {
+		return 0, err
+	}
+
+	matches := re.FindAllStringSubmatch(output, -1)
+	if len(matches) == 0 {
+		return 0, fmt.Errorf("query '%s' did not match", query)
+	}
+
+	match := matches[len(matches)-1]
+	s := match[0]
+	if len(match) > 1 {
+		s = match[1]
+	}
+	return strconv.ParseFloat(strings.TrimSpace(s), 64)
+}
+
+// parseJSONOutput evaluates the JSON path expression against the output, if the output is not a single JSON document
+// the last line containing a JSON object is used.
+func parseJSONOutput(name, query, output string) (float64, error) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(output), &data); err != nil {
+		data = nil
+		lines := strings.Split(output, "\n")
+		for i := len(lines) - 1; i >= 0 && data == nil; i-- {
+			obj := make(map[string]interface{})
+			if err := json.Unmarshal([]byte(strings.TrimSpace(lines[i])), &obj); err == nil {
+				data = obj
+			}
+		}
+		if data == nil {
+			return 0, fmt.Errorf("trial job output does not contain a JSON object")
+		}
+	}
+
+	return evalJSONPath(name, query, data)
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestJobOutputCapture(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		output        string
+		completedAgo  time.Duration
+		query         string
+		expected      float64
+		expectedError string
+	}{
+		{
+			desc:     "regex capture group",
+			output:   "warming up\nops/sec: 1000\nops/sec: 1234.5\ndone",
+			query:    `ops/sec:\s*([0-9.]+)`,
+			expected: 1234.5,
+		},
+		{
+			desc:     "regex match",
+			output:   "42\n",
+			query:    `[0-9]+`,
+			expected: 42,
+		},
+		{
+			desc:     "termination message JSON",
+			output:   `{"results": {"throughput": 99.5}}`,
+			query:    "{.results.throughput}",
+			expected: 99.5,
+		},
+		{
+			desc:     "last JSON line",
+			output:   "starting benchmark\n{\"p95\": 0.1}\n{\"p95\": 0.25}\nbye",
+			query:    "{.p95}",
+			expected: 0.25,
+		},
+		{
+			desc:          "no match",
+			output:        "nothing to see here",
+			query:         `ops/sec: ([0-9.]+)`,
+			expectedError: "query 'ops/sec: ([0-9.]+)' did not match",
+		},
+		{
+			desc:          "no JSON",
+			output:        "nothing to see here",
+			query:         "{.p95}",
+			expectedError: "trial job output does not contain a JSON object",
+		},
+		{
+			desc:          "pending output",
+			output:        "",
+			completedAgo:  10 * time.Second,
+			query:         "{.p95}",
+			expectedError: "waiting for the trial job output to be recorded",
+		},
+		{
+			desc:          "missing output",
+			output:        "",
+			completedAgo:  10 * time.Minute,
+			query:         "{.p95}",
+			expectedError: "no output was recorded for the trial job",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			trial := &optimizev1beta2.Trial{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{optimizev1beta2.AnnotationJobOutput: tc.output},
+				},
+				Status: optimizev1beta2.TrialStatus{
+					CompletionTime: &metav1.Time{Time: time.Now().Add(-tc.completedAgo)},
+				},
+			}
+			value, _, err := CaptureMetric(context.TODO(), nil, nil, trial, &optimizev1beta2.Metric{
+				Name:  "test",
+				Type:  optimizev1beta2.MetricJobOutput,
+				Query: tc.query,
+			}, nil, nil)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tc.expected, value)
+			}
+		})
+	}
+}
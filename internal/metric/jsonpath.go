@@ -24,23 +24,70 @@ import (
 	"net/http"
 	"reflect"
 	"strconv"
-	"time"
+	"strings"
 
+	"github.com/go-logr/logr"
 	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/util/jsonpath"
 )
 
-// TODO We need some type of client util to encapsulate this
-var httpClient = &http.Client{Timeout: 10 * time.Second}
+func init() {
+	Register(optimizev1beta2.MetricJSONPath, &jsonPathProvider{})
+}
+
+// jsonPathProvider captures values by evaluating JSON path expressions against a JSON resource.
+type jsonPathProvider struct{}
+
+func (p *jsonPathProvider) ApplyDefaults(*optimizev1beta2.Trial, *optimizev1beta2.Metric) error {
+	return nil
+}
+
+func (p *jsonPathProvider) Validate(m *optimizev1beta2.Metric) []error {
+	errs := validateFeatures(m, featureTLS|featureHTTP|featureTemplatedSamples)
+	if m.URL == "" {
+		errs = append(errs, fmt.Errorf("JSON Path metric requires a URL"))
+	}
+	if !strings.Contains(m.Query, "{") {
+		errs = append(errs, Warning("JSON Path query should contain an {} expression"))
+	}
+	if m.HTTP != nil {
+		switch method := httpMethod(m.HTTP); method {
+		case http.MethodGet:
+			if m.HTTP.Body != "" {
+				errs = append(errs, Warning("HTTP request body is ignored for GET requests"))
+			}
+		case http.MethodPost:
+		default:
+			errs = append(errs, fmt.Errorf("unsupported HTTP method: %s (expected: GET, POST)", method))
+		}
+		for _, h := range m.HTTP.Headers {
+			if h.Name == "" {
+				errs = append(errs, fmt.Errorf("HTTP header name is required"))
+			}
+		}
+	}
+	return errs
+}
+
+func (p *jsonPathProvider) Capture(ctx context.Context, _ logr.Logger, _ *optimizev1beta2.Trial, m *optimizev1beta2.Metric, secret *corev1.Secret) (float64, float64, error) {
+	return captureJSONPathMetric(ctx, m, secret)
+}
+
+func captureJSONPathMetric(ctx context.Context, m *optimizev1beta2.Metric, secret *corev1.Secret) (value float64, valueError float64, err error) {
+	// Build the HTTP client
+	rt, err := newRoundTripper(m, secret)
+	if err != nil {
+		return 0, 0, err
+	}
+	client := &http.Client{Timeout: httpTimeout, Transport: rt}
 
-func captureJSONPathMetric(m *optimizev1beta2.Metric) (value float64, valueError float64, err error) {
 	// Fetch the URL
-	req, err := http.NewRequest(http.MethodGet, m.URL, nil)
+	req, err := newHTTPRequest(ctx, m)
 	if err != nil {
 		return 0, 0, err
 	}
-	req.Header.Set("Accept", "application/json")
-	resp, err := httpClient.Do(req.WithContext(context.TODO()))
+	resp, err := client.Do(req)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -49,9 +96,8 @@ func captureJSONPathMetric(m *optimizev1beta2.Metric) (value float64, valueError
 	}()
 
 	// Check the response status
-	if resp.StatusCode != http.StatusOK {
-		// TODO Should we not ignore this?
-		return 0, math.NaN(), nil
+	if err := checkHTTPResponse(m, resp); err != nil {
+		return 0, 0, err
 	}
 
 	// Unmarshal as generic JSON
@@ -60,14 +106,23 @@ func captureJSONPathMetric(m *optimizev1beta2.Metric) (value float64, valueError
 		return 0, 0, err
 	}
 
-	// Evaluate the JSON path
-	jp := jsonpath.New(m.Name)
-	if err := jp.Parse(m.Query); err != nil {
+	value, err = evalJSONPath(m.Name, m.Query, data)
+	if err != nil {
 		return 0, 0, err
 	}
+	return value, math.NaN(), nil
+}
+
+// evalJSONPath evaluates a JSON path expression against generic JSON data, the result must be a single number.
+func evalJSONPath(name, query string, data interface{}) (float64, error) {
+	// Evaluate the JSON path
+	jp := jsonpath.New(name)
+	if err := jp.Parse(query); err != nil {
+		return 0, err
+	}
 	values, err := jp.FindResults(data)
 	if err != nil {
-		return 0, 0, err
+		return 0, err
 	}
 
 	// Convert the result to a float
@@ -75,18 +130,14 @@ func captureJSONPathMetric(m *optimizev1beta2.Metric) (value float64, valueError
 		v := reflect.ValueOf(values[0][0].Interface())
 		switch v.Kind() {
 		case reflect.Float64:
-			return v.Float(), math.NaN(), nil
+			return v.Float(), nil
 		case reflect.String:
-			v, err := strconv.ParseFloat(v.String(), 64)
-			if err != nil {
-				return 0, 0, err
-			}
-			return v, math.NaN(), nil
+			return strconv.ParseFloat(v.String(), 64)
 		default:
-			return 0, 0, fmt.Errorf("could not convert match to a floating point number")
+			return 0, fmt.Errorf("could not convert match to a floating point number")
 		}
 	}
 
 	// If we made it this far we weren't able to extract the value
-	return 0, 0, fmt.Errorf("query '%s' did not match", m.Query)
+	return 0, fmt.Errorf("query '%s' did not match", query)
 }
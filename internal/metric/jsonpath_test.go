@@ -0,0 +1,169 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestJSONPathCaptureHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/kpi":
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer s3cr3t" || r.Header.Get("X-Trial") != "my-trial" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprintf(w, `{"request":%s,"value":"42"}`, body)
+		case "/throttled":
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "kpi"},
+		Data:       map[string][]byte{"token": []byte("s3cr3t")},
+	}
+	now := metav1.Now()
+	trial := &optimizev1beta2.Trial{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-trial"},
+		Status: optimizev1beta2.TrialStatus{
+			StartTime:      &metav1.Time{Time: now.Add(-time.Minute)},
+			CompletionTime: &now,
+		},
+	}
+
+	testCases := []struct {
+		desc          string
+		metric        *optimizev1beta2.Metric
+		expected      float64
+		expectedError *CaptureError
+	}{
+		{
+			desc: "post",
+			metric: &optimizev1beta2.Metric{
+				Name:  "testMetric",
+				Type:  optimizev1beta2.MetricJSONPath,
+				URL:   srv.URL + "/kpi",
+				Query: "{.value}",
+				HTTP: &optimizev1beta2.MetricHTTPRequest{
+					Headers: []optimizev1beta2.MetricHTTPHeader{{Name: "X-Trial", Value: "{{ .Trial.Name }}"}},
+					Body:    `{"trial":"{{ .Trial.Name }}"}`,
+				},
+			},
+			expected: 42,
+		},
+		{
+			desc: "templated body",
+			metric: &optimizev1beta2.Metric{
+				Name:  "testMetric",
+				Type:  optimizev1beta2.MetricJSONPath,
+				URL:   srv.URL + "/kpi",
+				Query: "{.request.duration}",
+				HTTP: &optimizev1beta2.MetricHTTPRequest{
+					Method:  "post",
+					Headers: []optimizev1beta2.MetricHTTPHeader{{Name: "X-Trial", Value: "{{ .Trial.Name }}"}},
+					Body:    `{"duration":{{ duration .StartTime .CompletionTime }}}`,
+				},
+			},
+			expected: 60,
+		},
+		{
+			desc: "throttled",
+			metric: &optimizev1beta2.Metric{
+				Name:  "testMetric",
+				Type:  optimizev1beta2.MetricJSONPath,
+				URL:   srv.URL + "/throttled",
+				Query: "{.value}",
+			},
+			expectedError: &CaptureError{Message: "unexpected HTTP response status: 429 Too Many Requests", RetryAfter: 30 * time.Second},
+		},
+		{
+			desc: "unavailable",
+			metric: &optimizev1beta2.Metric{
+				Name:  "testMetric",
+				Type:  optimizev1beta2.MetricJSONPath,
+				URL:   srv.URL + "/unavailable",
+				Query: "{.value}",
+			},
+			expectedError: &CaptureError{Message: "unexpected HTTP response status: 503 Service Unavailable", RetryAfter: httpRetryAfter},
+		},
+		{
+			desc: "not found",
+			metric: &optimizev1beta2.Metric{
+				Name:  "testMetric",
+				Type:  optimizev1beta2.MetricJSONPath,
+				URL:   srv.URL + "/missing",
+				Query: "{.value}",
+			},
+			expectedError: &CaptureError{Message: "unexpected HTTP response status: 404 Not Found"},
+		},
+		{
+			desc: "unauthorized",
+			metric: &optimizev1beta2.Metric{
+				Name:  "testMetric",
+				Type:  optimizev1beta2.MetricJSONPath,
+				URL:   srv.URL + "/kpi",
+				Query: "{.value}",
+			},
+			expectedError: &CaptureError{Message: "unexpected HTTP response status: 403 Forbidden"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			value, _, err := CaptureMetric(context.TODO(), nil, nil, trial, tc.metric, nil, secret)
+			if tc.expectedError != nil {
+				require.Error(t, err)
+				require.IsType(t, &CaptureError{}, err)
+				assert.Equal(t, tc.expectedError.Message, err.(*CaptureError).Message)
+				assert.Equal(t, tc.expectedError.RetryAfter, err.(*CaptureError).RetryAfter)
+				return
+			}
+
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.expected, value)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2022, time.January, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 120*time.Second, retryAfter("120", now))
+	assert.Equal(t, 90*time.Second, retryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, httpRetryAfter, retryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now))
+	assert.Equal(t, httpRetryAfter, retryAfter("", now))
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"math"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func init() {
+	Register(optimizev1beta2.MetricKubernetes, &kubernetesProvider{})
+}
+
+// kubernetesProvider captures values from the rendered query itself.
+type kubernetesProvider struct{}
+
+func (p *kubernetesProvider) ApplyDefaults(*optimizev1beta2.Trial, *optimizev1beta2.Metric) error {
+	return nil
+}
+
+func (p *kubernetesProvider) Validate(m *optimizev1beta2.Metric) []error {
+	return validateFeatures(m, 0)
+}
+
+func (p *kubernetesProvider) Capture(_ context.Context, _ logr.Logger, _ *optimizev1beta2.Trial, m *optimizev1beta2.Metric, _ *corev1.Secret) (float64, float64, error) {
+	value, err := strconv.ParseFloat(m.Query, 64)
+	return value, math.NaN(), err
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/go-logr/logr"
+	prom "github.com/prometheus/client_golang/api"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func init() {
+	Register(optimizev1beta2.MetricLoki, &lokiProvider{})
+}
+
+// lokiProvider captures values using LogQL metric queries against a Loki server. Loki exposes a Prometheus compatible
+// query API, so queries are evaluated using the same rules as Prometheus metrics. The tenant is read from the "tenant"
+// query parameter on the metric URL or the "LOKI_TENANT_ID" secret key.
+type lokiProvider struct{}
+
+func (p *lokiProvider) ApplyDefaults(*optimizev1beta2.Trial, *optimizev1beta2.Metric) error {
+	return nil
+}
+
+func (p *lokiProvider) Validate(m *optimizev1beta2.Metric) []error {
+	errs := validateFeatures(m, featureRange|featureTLS|featureSamples)
+	if m.URL == "" {
+		errs = append(errs, fmt.Errorf("Loki metric requires a URL"))
+	}
+	if m.Range != nil {
+		if _, _, err := aggregate(m.Range.Aggregation, []float64{0}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (p *lokiProvider) Capture(ctx context.Context, _ logr.Logger, t *optimizev1beta2.Trial, m *optimizev1beta2.Metric, secret *corev1.Secret) (float64, float64, error) {
+	return captureLokiMetric(ctx, m, secret, t.Status.StartTime.Time, t.Status.CompletionTime.Time)
+}
+
+func captureLokiMetric(ctx context.Context, m *optimizev1beta2.Metric, secret *corev1.Secret, startTime, completionTime time.Time) (value float64, valueError float64, err error) {
+	rt, err := newRoundTripper(m, secret)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The Prometheus compatible API is served under "/loki"
+	u, err := url.Parse(m.URL)
+	if err != nil {
+		return 0, 0, err
+	}
+	q := u.Query()
+	tenant := q.Get("tenant")
+	if tenant == "" {
+		tenant = credential(secret, "LOKI_TENANT_ID")
+	}
+	q.Del("tenant")
+	u.RawQuery = q.Encode()
+	u.Path = path.Join("/", u.Path, "loki")
+
+	if tenant != "" {
+		rt = &tenantRoundTripper{tenant: tenant, rt: rt}
+	}
+
+	c, err := prom.NewClient(prom.Config{Address: u.String(), RoundTripper: rt})
+	if err != nil {
+		return 0, 0, err
+	}
+	lokiAPI := promv1.NewAPI(c)
+
+	// Range queries are evaluated over the entire trial run
+	if m.Range != nil {
+		return captureRangeMetric(ctx, lokiAPI, m, startTime, completionTime)
+	}
+
+	value, err = queryScalar(ctx, lokiAPI, m.Query, completionTime)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Treat an empty result the same as a Prometheus NaN
+	if math.IsNaN(value) {
+		return 0, 0, &CaptureError{Message: "metric data not available", Address: m.URL, Query: m.Query}
+	}
+
+	if m.ErrorQuery != "" {
+		valueError, err = queryScalar(ctx, lokiAPI, m.ErrorQuery, completionTime)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return value, valueError, nil
+}
+
+// tenantRoundTripper sets the Loki tenant header on each request.
+type tenantRoundTripper struct {
+	tenant string
+	rt     http.RoundTripper
+}
+
+func (t *tenantRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Scope-OrgID", t.tenant)
+	return t.rt.RoundTrip(req)
+}
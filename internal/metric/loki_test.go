@@ -0,0 +1,110 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLokiCapture(t *testing.T) {
+	startTime := time.Date(2022, time.January, 1, 12, 0, 0, 0, time.UTC)
+	completionTime := startTime.Add(5 * time.Minute)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Scope-OrgID") != "team-a" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte("no org id\n"))
+			return
+		}
+		_ = r.ParseForm()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/loki/api/v1/query":
+			assert.Equal(t, "2022-01-01T12:05:00Z", r.Form.Get("time"))
+			switch r.Form.Get("query") {
+			case `sum(count_over_time({app="api"} |= "error" [5m]))`:
+				_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1641038700,"42"]}],"stats":{}}}`))
+			default:
+				_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[],"stats":{}}}`))
+			}
+		case "/loki/api/v1/query_range":
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[1641038400,"1"],[1641038550,"5"],[1641038700,"3"]]}],"stats":{}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	testCases := []struct {
+		desc          string
+		metric        optimizev1beta2.Metric
+		secret        *corev1.Secret
+		expected      float64
+		expectedError string
+	}{
+		{
+			desc: "instant",
+			metric: optimizev1beta2.Metric{
+				URL:   srv.URL + "?tenant=team-a",
+				Query: `sum(count_over_time({app="api"} |= "error" [5m]))`,
+			},
+			expected: 42,
+		},
+		{
+			desc: "range",
+			metric: optimizev1beta2.Metric{
+				URL:   srv.URL,
+				Query: `sum(rate({app="api"} |= "error" [1m]))`,
+				Range: &optimizev1beta2.MetricRange{Aggregation: "max"},
+			},
+			secret:   &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "loki"}, Data: map[string][]byte{"LOKI_TENANT_ID": []byte("team-a")}},
+			expected: 5,
+		},
+		{
+			desc: "empty",
+			metric: optimizev1beta2.Metric{
+				URL:   srv.URL + "?tenant=team-a",
+				Query: `sum(count_over_time({app="missing"}[5m]))`,
+			},
+			expectedError: "metric data not available",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tc.metric.Name = "errors"
+			tc.metric.Type = optimizev1beta2.MetricLoki
+			value, _, err := captureLokiMetric(context.TODO(), &tc.metric, tc.secret, startTime, completionTime)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				_, ok := err.(*CaptureError)
+				assert.True(t, ok)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tc.expected, value)
+			}
+		})
+	}
+}
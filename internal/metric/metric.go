@@ -19,37 +19,108 @@ package metric
 import (
 	"context"
 	"fmt"
-	"math"
-	"strconv"
+	"time"
 
 	"github.com/go-logr/logr"
 	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
 	"github.com/thestormforge/optimize-controller/v2/internal/template"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-// CaptureMetric captures a point-in-time metric value and it's error rate.
-func CaptureMetric(ctx context.Context, log logr.Logger, trial *optimizev1beta2.Trial, metric *optimizev1beta2.Metric, target runtime.Object) (float64, float64, error) {
-	// Execute the queries as Go templates
+// CaptureMetric captures a point-in-time metric value and it's error rate. The optional reader is used to look up
+// objects referenced from the metric templates. The optional secret contains the credentials referenced by the
+// metric, if no secret is supplied credentials are read from the environment.
+func CaptureMetric(ctx context.Context, log logr.Logger, r client.Reader, trial *optimizev1beta2.Trial, metric *optimizev1beta2.Metric, target runtime.Object, secret *corev1.Secret) (float64, float64, error) {
+	// Find the provider for the metric type
+	p, ok := Lookup(metric.Type)
+	if !ok {
+		return 0, 0, fmt.Errorf("unknown metric type: %s", metric.Type)
+	}
+
+	// Capture multiple samples over the trial run if requested
+	if metric.Samples > 1 && trial.Status.StartTime != nil && trial.Status.CompletionTime != nil {
+		return captureSamples(ctx, log, r, p, trial, metric, target, secret)
+	}
+
+	// Execute the queries (and request templates) as Go templates
 	var err error
-	if metric.Query, metric.ErrorQuery, err = template.New().RenderMetricQueries(metric, trial, target); err != nil {
+	te := template.New().WithLookup(ctx, r)
+	if metric.Query, metric.ErrorQuery, err = te.RenderMetricQueries(metric, trial, target); err != nil {
+		return 0, 0, err
+	}
+	if metric.HTTP, err = te.RenderMetricRequest(metric, trial, target); err != nil {
 		return 0, 0, err
 	}
 
-	// Capture the value based on the metric type
-	switch metric.Type {
-	case optimizev1beta2.MetricKubernetes, "":
-		value, err := strconv.ParseFloat(metric.Query, 64)
-		return value, math.NaN(), err
-	case optimizev1beta2.MetricPrometheus:
-		return capturePrometheusMetric(ctx, log, metric, trial.Status.CompletionTime.Time)
-	case optimizev1beta2.MetricDatadog:
-		return captureDatadogMetric(metric, trial.Status.StartTime.Time, trial.Status.CompletionTime.Time)
-	case optimizev1beta2.MetricJSONPath:
-		return captureJSONPathMetric(metric)
-	case optimizev1beta2.MetricNewRelic:
-		return captureNewRelicMetric(metric, trial.Status.StartTime.Time, trial.Status.CompletionTime.Time)
-	default:
-		return 0, 0, fmt.Errorf("unknown metric type: %s", metric.Type)
+	// Capture the value using the provider
+	return p.Capture(ctx, log, trial, metric, secret)
+}
+
+// captureSamples splits the trial run into equal intervals and captures the metric over each interval, the mean and
+// standard error of the captured values are returned. Sampling must be supported by the provider.
+func captureSamples(ctx context.Context, log logr.Logger, r client.Reader, p Provider, trial *optimizev1beta2.Trial, metric *optimizev1beta2.Metric, target runtime.Object, secret *corev1.Secret) (float64, float64, error) {
+	n := int(metric.Samples)
+	start, end := trial.Status.StartTime.Time, trial.Status.CompletionTime.Time
+	interval := end.Sub(start) / time.Duration(n)
+	if interval <= 0 {
+		return 0, 0, fmt.Errorf("trial run is too short to capture %d samples", n)
 	}
+
+	// Render the metric for each interval
+	te := template.New().WithLookup(ctx, r)
+	trials := make([]*optimizev1beta2.Trial, 0, n)
+	metrics := make([]*optimizev1beta2.Metric, 0, n)
+	for i := 0; i < n; i++ {
+		t := trial.DeepCopy()
+		t.Status.StartTime = &metav1.Time{Time: start.Add(time.Duration(i) * interval)}
+		t.Status.CompletionTime = &metav1.Time{Time: start.Add(time.Duration(i+1) * interval)}
+
+		m := metric.DeepCopy()
+		var err error
+		if m.Query, m.ErrorQuery, err = te.RenderMetricQueries(metric, t, target); err != nil {
+			return 0, 0, err
+		}
+		if m.HTTP, err = te.RenderMetricRequest(metric, t, target); err != nil {
+			return 0, 0, err
+		}
+
+		trials = append(trials, t)
+		metrics = append(metrics, m)
+	}
+
+	// Let the provider decide if sampling is supported
+	templated := false
+	for _, err := range p.Validate(metrics[0]) {
+		switch {
+		case err == errTemplatedSamples:
+			templated = true
+		case !isWarning(err):
+			return 0, 0, err
+		}
+	}
+
+	// Providers which do not use the trial run interval only produce different samples if the templates do
+	if templated {
+		if equality.Semantic.DeepEqual(metrics[0], metrics[1]) {
+			return 0, 0, fmt.Errorf("metric %q does not depend on the trial run interval, sampling is not supported", metric.Name)
+		}
+		for i := range trials {
+			trials[i] = trial
+		}
+	}
+
+	values := make([]float64, 0, n)
+	for i := 0; i < n; i++ {
+		value, _, err := p.Capture(ctx, log, trials[i], metrics[i], secret)
+		if err != nil {
+			return 0, 0, err
+		}
+		values = append(values, value)
+	}
+
+	return mean(values), standardError(values), nil
 }
@@ -25,7 +25,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/newrelic/newrelic-client-go/pkg/config"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -50,10 +52,17 @@ func TestCaptureMetric(t *testing.T) {
 	promHttpTest := promHttpTestServer()
 	defer promHttpTest.Close()
 
+	datadogHttpTest := datadogHttpTestServer("testApiKey", "testAppKey")
+	defer datadogHttpTest.Close()
+
+	newRelicHttpTest := newRelicHttpTestServer("testApiKey")
+	defer newRelicHttpTest.Close()
+
 	testCases := []struct {
 		desc     string
 		metric   *optimizev1beta2.Metric
 		obj      runtime.Object
+		secret   *corev1.Secret
 		expected float64
 	}{
 		{
@@ -117,6 +126,42 @@ func TestCaptureMetric(t *testing.T) {
 			},
 			expected: 5,
 		},
+		{
+			desc: "datadog secret",
+			metric: &optimizev1beta2.Metric{
+				Name:      "testMetric",
+				Query:     "avg:kubernetes.cpu.usage.total{*}",
+				Type:      optimizev1beta2.MetricDatadog,
+				URL:       datadogHttpTest.URL,
+				SecretRef: &corev1.LocalObjectReference{Name: "datadog"},
+			},
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "datadog"},
+				Data: map[string][]byte{
+					"DATADOG_API_KEY": []byte("testApiKey"),
+					"DATADOG_APP_KEY": []byte("testAppKey"),
+				},
+			},
+			expected: 3,
+		},
+		{
+			desc: "newrelic secret",
+			metric: &optimizev1beta2.Metric{
+				Name:      "testMetric",
+				Query:     "SELECT average(cpuPercent) FROM SystemSample",
+				Type:      optimizev1beta2.MetricNewRelic,
+				URL:       newRelicHttpTest.URL + "?region=EU",
+				SecretRef: &corev1.LocalObjectReference{Name: "newrelic"},
+			},
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "newrelic"},
+				Data: map[string][]byte{
+					"NEW_RELIC_API_KEY":    []byte("testApiKey"),
+					"NEW_RELIC_ACCOUNT_ID": []byte("1234"),
+				},
+			},
+			expected: 7,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -129,7 +174,7 @@ func TestCaptureMetric(t *testing.T) {
 				},
 			}
 
-			duration, _, err := CaptureMetric(context.TODO(), log, trial, tc.metric, tc.obj)
+			duration, _, err := CaptureMetric(context.TODO(), log, nil, trial, tc.metric, tc.obj, tc.secret)
 			assert.NoError(t, err)
 			assert.Equal(t, tc.expected, duration)
 		})
@@ -149,3 +194,127 @@ func promHttpTestServer() *httptest.Server {
 		fmt.Fprint(w, resp)
 	}))
 }
+
+func datadogHttpTestServer(apiKey, appKey string) *httptest.Server {
+	resp := `{"status":"ok","series":[{"metric":"kubernetes.cpu.usage.total","pointlist":[[1595471900000,2],[1595471905000,null],[1595471910000,4]]}]}`
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api_key") != apiKey || r.URL.Query().Get("application_key") != appKey {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, resp)
+	}))
+}
+
+func newRelicHttpTestServer(apiKey string) *httptest.Server {
+	resp := `{"data":{"actor":{"account":{"nrql":{"results":[{"average.cpuPercent":7}]}}}}}`
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Api-Key") != apiKey {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, resp)
+	}))
+}
+
+func TestNewRelicConfig(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		url       string
+		nerdGraph string
+		err       string
+	}{
+		{
+			desc:      "default",
+			nerdGraph: "https://api.newrelic.com/graphql",
+		},
+		{
+			desc:      "region",
+			url:       "?region=EU",
+			nerdGraph: "https://api.eu.newrelic.com/graphql",
+		},
+		{
+			desc:      "endpoint",
+			url:       "http://newrelic-proxy:8080/graphql?region=EU",
+			nerdGraph: "http://newrelic-proxy:8080/graphql",
+		},
+		{
+			desc: "unsupported region",
+			url:  "?region=APAC",
+			err:  "unsupported region: APAC (expected: US, EU)",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts, err := newRelicConfig(&optimizev1beta2.Metric{Type: optimizev1beta2.MetricNewRelic, URL: tc.url})
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+
+			cfg := config.New()
+			for _, opt := range opts {
+				require.NoError(t, opt(&cfg))
+			}
+			assert.Equal(t, tc.nerdGraph, cfg.Region().NerdGraphURL())
+		})
+	}
+}
+
+func TestCaptureMetricSamples(t *testing.T) {
+	start := time.Date(2022, time.January, 1, 12, 0, 0, 0, time.UTC)
+	completion := metav1.NewTime(start.Add(40 * time.Second))
+
+	// Report the offset of the sample interval from the start of the trial run
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var sampleStart int64
+		if err := json.NewDecoder(r.Body).Decode(&sampleStart); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"value":%d}`, sampleStart-start.Unix())
+	}))
+	defer srv.Close()
+
+	trial := &optimizev1beta2.Trial{
+		Status: optimizev1beta2.TrialStatus{
+			StartTime:      &metav1.Time{Time: start},
+			CompletionTime: &completion,
+		},
+	}
+	m := &optimizev1beta2.Metric{
+		Name:    "offset",
+		Type:    optimizev1beta2.MetricJSONPath,
+		URL:     srv.URL,
+		Query:   "{.value}",
+		HTTP:    &optimizev1beta2.MetricHTTPRequest{Body: "{{ .StartTime.Unix }}"},
+		Samples: 4,
+	}
+
+	value, valueError, err := CaptureMetric(context.TODO(), nil, nil, trial, m, nil, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 15.0, value)
+		assert.InDelta(t, 6.455, valueError, 0.001)
+	}
+
+	// Sampling is rejected for metrics which do not depend on the trial run interval
+	_, _, err = CaptureMetric(context.TODO(), nil, nil, trial, &optimizev1beta2.Metric{
+		Name:    "fixed",
+		Type:    optimizev1beta2.MetricJSONPath,
+		URL:     srv.URL,
+		Query:   "{.value}",
+		HTTP:    &optimizev1beta2.MetricHTTPRequest{Body: "0"},
+		Samples: 4,
+	}, nil, nil)
+	assert.EqualError(t, err, `metric "fixed" does not depend on the trial run interval, sampling is not supported`)
+
+	trial.Spec.Values = []optimizev1beta2.Value{{Name: "offset", Value: "15"}}
+	_, _, err = CaptureMetric(context.TODO(), nil, nil, trial, &optimizev1beta2.Metric{
+		Name:    "double",
+		Type:    optimizev1beta2.MetricDerived,
+		Query:   "offset * 2",
+		Samples: 4,
+	}, nil, nil)
+	assert.EqualError(t, err, "metric sampling is not supported")
+}
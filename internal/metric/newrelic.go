@@ -17,17 +17,20 @@ limitations under the License.
 package metric
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
-	"os"
+	"net/url"
 	"strconv"
-	"strings"
 	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/newrelic/newrelic-client-go/newrelic"
 	"github.com/newrelic/newrelic-client-go/pkg/nrdb"
+	"github.com/newrelic/newrelic-client-go/pkg/region"
 	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
 )
 
 const query = `
@@ -42,23 +45,51 @@ const query = `
 		}
 	}`
 
-func captureNewRelicMetric(m *optimizev1beta2.Metric, startTime, completionTime time.Time) (float64, float64, error) {
-	apiKey := os.Getenv("NEW_RELIC_API_KEY")
+func init() {
+	Register(optimizev1beta2.MetricNewRelic, &newRelicProvider{})
+}
+
+// newRelicProvider captures values using NRQL queries against the New Relic service.
+type newRelicProvider struct{}
+
+func (p *newRelicProvider) ApplyDefaults(*optimizev1beta2.Trial, *optimizev1beta2.Metric) error {
+	return nil
+}
+
+func (p *newRelicProvider) Validate(m *optimizev1beta2.Metric) []error {
+	errs := validateFeatures(m, featureSamples)
+	if _, err := newRelicConfig(m); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func (p *newRelicProvider) Capture(_ context.Context, _ logr.Logger, t *optimizev1beta2.Trial, m *optimizev1beta2.Metric, secret *corev1.Secret) (float64, float64, error) {
+	return captureNewRelicMetric(m, secret, t.Status.StartTime.Time, t.Status.CompletionTime.Time)
+}
+
+func captureNewRelicMetric(m *optimizev1beta2.Metric, secret *corev1.Secret, startTime, completionTime time.Time) (float64, float64, error) {
+	apiKey := credential(secret, "NEW_RELIC_API_KEY")
 	if apiKey == "" {
-		return 0, 0, errors.New("NEW_RELIC_API_KEY environment variable missing")
+		return 0, 0, missingCredential(secret, "NEW_RELIC_API_KEY")
 	}
 
-	envAccountID := os.Getenv("NEW_RELIC_ACCOUNT_ID")
+	envAccountID := credential(secret, "NEW_RELIC_ACCOUNT_ID")
 	if envAccountID == "" {
-		return 0, 0, errors.New("NEW_RELIC_ACCOUNT_ID environment variable missing")
+		return 0, 0, missingCredential(secret, "NEW_RELIC_ACCOUNT_ID")
 	}
 
-	accountID, err := strconv.Atoi(strings.TrimSpace(envAccountID))
+	accountID, err := strconv.Atoi(envAccountID)
 	if err != nil {
 		return 0, 0, errors.New("invalid account id, must be a number")
 	}
 
-	client, err := newrelic.New(newrelic.ConfigPersonalAPIKey(strings.TrimSpace(apiKey)))
+	opts, err := newRelicConfig(m)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	client, err := newrelic.New(append(opts, newrelic.ConfigPersonalAPIKey(apiKey))...)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -103,6 +134,32 @@ func captureNewRelicMetric(m *optimizev1beta2.Metric, startTime, completionTime
 	return result, math.NaN(), nil
 }
 
+// newRelicConfig returns the client configuration for the metric. The region (e.g. "EU") is specified using the
+// "region" query parameter on the metric URL, the remainder of the URL (if present) overrides the NerdGraph endpoint.
+func newRelicConfig(m *optimizev1beta2.Metric) ([]newrelic.ConfigOption, error) {
+	u, err := url.Parse(m.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []newrelic.ConfigOption
+	q := u.Query()
+	if r := q.Get("region"); r != "" {
+		if _, err := region.Parse(r); err != nil {
+			return nil, fmt.Errorf("unsupported region: %s (expected: US, EU)", r)
+		}
+		opts = append(opts, newrelic.ConfigRegion(r))
+	}
+
+	if u.Host != "" {
+		q.Del("region")
+		u.RawQuery = q.Encode()
+		opts = append(opts, newrelic.ConfigNerdGraphBaseURL(u.String()))
+	}
+
+	return opts, nil
+}
+
 // Using the pattern provided by NewRelic instead of dealing with map[string]interface casts
 // ref: https://github.com/newrelic/newrelic-client-go/blob/2932f5d6275d9017fd1ce5764d2de258575dd187/pkg/nrdb/nrdb_query.go#L50
 type gqlNrglQueryResponse struct {
@@ -0,0 +1,257 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-logr/logr"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	"github.com/thestormforge/optimize-controller/v2/internal/meta"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func init() {
+	Register(optimizev1beta2.MetricPodMetrics, &podMetricsProvider{})
+}
+
+// podMetricsSampleInterval is the default time between samples, it matches the default metrics-server resolution.
+const podMetricsSampleInterval = 15 * time.Second
+
+// podMetricsProvider captures values by aggregating the pod resource usage sampled during the trial run.
+type podMetricsProvider struct{}
+
+func (p *podMetricsProvider) ApplyDefaults(t *optimizev1beta2.Trial, m *optimizev1beta2.Metric) error {
+	if m.Target == nil {
+		m.Target = &optimizev1beta2.ResourceTarget{}
+	}
+	if m.Target.Kind == "" {
+		m.Target.SetGroupVersionKind(metricsv1beta1.SchemeGroupVersion.WithKind("PodMetricsList"))
+	}
+	if m.Target.Namespace == "" {
+		m.Target.Namespace = t.Namespace
+	}
+	return nil
+}
+
+func (p *podMetricsProvider) Validate(m *optimizev1beta2.Metric) []error {
+	errs := validateFeatures(m, featureRange)
+	switch corev1.ResourceName(m.Query) {
+	case corev1.ResourceCPU, corev1.ResourceMemory:
+	default:
+		errs = append(errs, Warning("Pod metrics only report cpu and memory usage"))
+	}
+	if m.Target != nil && m.Target.Name != "" {
+		errs = append(errs, Warning("Pod metrics target name is ignored, use a label selector instead"))
+	}
+	if m.Range != nil {
+		if _, _, err := aggregate(m.Range.Aggregation, []float64{0}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (p *podMetricsProvider) Capture(_ context.Context, _ logr.Logger, t *optimizev1beta2.Trial, m *optimizev1beta2.Metric, _ *corev1.Secret) (float64, float64, error) {
+	samples, err := loadSamples(t)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	s := samples[m.Name]
+	if s == nil || s.Count == 0 {
+		return 0, 0, fmt.Errorf("no %s usage was sampled during the trial run", m.Query)
+	}
+
+	var aggregation string
+	if m.Range != nil {
+		aggregation = m.Range.Aggregation
+	}
+	return s.aggregate(aggregation)
+}
+
+// SamplePodMetrics records the current resource usage of the pods matched by the metric target on the trial, if a
+// new sample is due. The amount of time until the next sample is due is returned.
+func SamplePodMetrics(ctx context.Context, r client.Reader, t *optimizev1beta2.Trial, m *optimizev1beta2.Metric, now time.Time) (time.Duration, error) {
+	interval := podMetricsSampleInterval
+	if m.Range != nil && m.Range.Step != nil && m.Range.Step.Duration > 0 {
+		interval = m.Range.Step.Duration
+	}
+
+	samples, err := loadSamples(t)
+	if err != nil {
+		return interval, err
+	}
+
+	// Check if we are due for a new sample
+	s := samples[m.Name]
+	if s == nil {
+		s = &sampleHistory{}
+		samples[m.Name] = s
+	}
+	if next := s.Time.Add(interval).Sub(now); next > 0 {
+		return next, nil
+	}
+
+	// Fetch the current usage of the matching pods
+	sel, err := meta.MatchingSelector(podMetricsSelector(m))
+	if err != nil {
+		return interval, err
+	}
+	list := &metricsv1beta1.PodMetricsList{}
+	if err := r.List(ctx, list, client.InNamespace(m.Target.Namespace), sel); err != nil {
+		return interval, err
+	}
+
+	// Do not record a sample until the pods are reporting usage
+	if len(list.Items) == 0 {
+		return interval, nil
+	}
+
+	var value float64
+	for i := range list.Items {
+		for _, c := range list.Items[i].Containers {
+			if q, ok := c.Usage[corev1.ResourceName(m.Query)]; ok {
+				value += float64(q.MilliValue()) / 1000
+			}
+		}
+	}
+
+	s.Time = metav1.NewTime(now)
+	s.add(value)
+	return interval, storeSamples(t, samples)
+}
+
+// podMetricsSelector returns the label selector for the metric target, an empty selector matches all pods.
+func podMetricsSelector(m *optimizev1beta2.Metric) *metav1.LabelSelector {
+	if m.Target == nil || m.Target.LabelSelector == nil {
+		return &metav1.LabelSelector{}
+	}
+	return m.Target.LabelSelector
+}
+
+// maxSampleValues is the maximum number of sampled values retained for computing percentiles, it bounds the size
+// of the sample history recorded on the trial regardless of how long the trial runs.
+const maxSampleValues = 256
+
+// sampleHistory is the running summary of the values sampled for a single metric.
+type sampleHistory struct {
+	// The time of the last sample
+	Time metav1.Time `json:"time"`
+	// The number of sampled values
+	Count int `json:"count"`
+	// The sum of the sampled values
+	Sum float64 `json:"sum"`
+	// The sum of the squares of the sampled values
+	SumSquares float64 `json:"sumSquares"`
+	// The smallest sampled value
+	Min float64 `json:"min"`
+	// The largest sampled value
+	Max float64 `json:"max"`
+	// The most recently sampled value
+	Last float64 `json:"last"`
+	// An evenly spaced subset of the sampled values, used to estimate percentiles
+	Values []float64 `json:"values,omitempty"`
+	// The number of sampled values represented by each retained value
+	Stride int `json:"stride,omitempty"`
+}
+
+// add includes a new value in the summary. Once the number of retained values exceeds the limit, every other value
+// is discarded and only every other subsequent value is retained.
+func (s *sampleHistory) add(value float64) {
+	if s.Count == 0 || value < s.Min {
+		s.Min = value
+	}
+	if s.Count == 0 || value > s.Max {
+		s.Max = value
+	}
+	s.Last = value
+	s.Sum += value
+	s.SumSquares += value * value
+	s.Count++
+
+	if s.Stride < 1 {
+		s.Stride = 1
+	}
+	if (s.Count-1)%s.Stride != 0 {
+		return
+	}
+	s.Values = append(s.Values, value)
+	if len(s.Values) > maxSampleValues {
+		n := (len(s.Values) + 1) / 2
+		for i := 0; i < n; i++ {
+			s.Values[i] = s.Values[i*2]
+		}
+		s.Values = s.Values[:n]
+		s.Stride *= 2
+	}
+}
+
+// aggregate returns the aggregated value and standard deviation of the sampled values.
+func (s *sampleHistory) aggregate(aggregation string) (float64, float64, error) {
+	n := float64(s.Count)
+	avg := s.Sum / n
+	sd := math.Sqrt(math.Max(s.SumSquares/n-avg*avg, 0))
+
+	switch {
+	case aggregation == "avg" || aggregation == "":
+		return avg, sd, nil
+	case aggregation == "last":
+		return s.Last, sd, nil
+	case aggregation == "max" || aggregation == "peak":
+		return s.Max, sd, nil
+	case aggregation == "min":
+		return s.Min, sd, nil
+	case aggregation == "sum":
+		return s.Sum, sd, nil
+	}
+
+	value, _, err := aggregate(aggregation, s.Values)
+	return value, sd, err
+}
+
+// loadSamples returns the sample history recorded on the trial, indexed by metric name.
+func loadSamples(t *optimizev1beta2.Trial) (map[string]*sampleHistory, error) {
+	samples := make(map[string]*sampleHistory)
+	if data := t.Annotations[optimizev1beta2.AnnotationMetricSamples]; data != "" {
+		if err := json.Unmarshal([]byte(data), &samples); err != nil {
+			return nil, fmt.Errorf("invalid metric samples: %w", err)
+		}
+	}
+	return samples, nil
+}
+
+// storeSamples records the sample history on the trial.
+func storeSamples(t *optimizev1beta2.Trial, samples map[string]*sampleHistory) error {
+	data, err := json.Marshal(samples)
+	if err != nil {
+		return err
+	}
+
+	if t.Annotations == nil {
+		t.Annotations = make(map[string]string)
+	}
+	t.Annotations[optimizev1beta2.AnnotationMetricSamples] = string(data)
+	return nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPodMetricsSample(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, metricsv1beta1.AddToScheme(scheme))
+
+	c := fake.NewFakeClientWithScheme(scheme,
+		podMetrics("app-0", "app", "250m", "100Mi"),
+		podMetrics("app-1", "app", "500m", "200Mi"),
+		podMetrics("db-0", "db", "1", "1Gi"),
+	)
+
+	trial := &optimizev1beta2.Trial{ObjectMeta: metav1.ObjectMeta{Name: "my-trial", Namespace: "default"}}
+	cpu := &optimizev1beta2.Metric{
+		Name:  "cpu",
+		Type:  optimizev1beta2.MetricPodMetrics,
+		Query: "cpu",
+		Target: &optimizev1beta2.ResourceTarget{
+			LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}},
+		},
+		Range: &optimizev1beta2.MetricRange{Aggregation: "peak", Step: &metav1.Duration{Duration: 10 * time.Second}},
+	}
+	memory := &optimizev1beta2.Metric{
+		Name:  "memory",
+		Type:  optimizev1beta2.MetricPodMetrics,
+		Query: "memory",
+	}
+	p := &podMetricsProvider{}
+	require.NoError(t, p.ApplyDefaults(trial, cpu))
+	require.NoError(t, p.ApplyDefaults(trial, memory))
+
+	// Nothing has been sampled yet
+	_, _, err := p.Capture(context.TODO(), nil, trial, cpu, nil)
+	assert.EqualError(t, err, "no cpu usage was sampled during the trial run")
+
+	now := time.Now().Truncate(time.Second) // Sample times are recorded with second precision
+
+	next, err := SamplePodMetrics(context.TODO(), c, trial, cpu, now)
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Second, next)
+
+	next, err = SamplePodMetrics(context.TODO(), c, trial, memory, now)
+	require.NoError(t, err)
+	assert.Equal(t, podMetricsSampleInterval, next)
+
+	// Not due for another sample yet
+	next, err = SamplePodMetrics(context.TODO(), c, trial, cpu, now.Add(4*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 6*time.Second, next)
+
+	// Change the usage before the next sample
+	pm := &metricsv1beta1.PodMetrics{}
+	require.NoError(t, c.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "app-1"}, pm))
+	pm.Containers[0].Usage[corev1.ResourceCPU] = resource.MustParse("1250m")
+	require.NoError(t, c.Update(context.TODO(), pm))
+
+	_, err = SamplePodMetrics(context.TODO(), c, trial, cpu, now.Add(10*time.Second))
+	require.NoError(t, err)
+
+	value, _, err := p.Capture(context.TODO(), nil, trial, cpu, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1.5, value)
+	}
+
+	value, _, err = p.Capture(context.TODO(), nil, trial, memory, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, float64(1324*1024*1024), value)
+	}
+}
+
+func TestSampleHistory(t *testing.T) {
+	s := &sampleHistory{}
+	for i := 1; i <= 10000; i++ {
+		s.add(float64(i))
+	}
+
+	assert.Equal(t, 10000, s.Count)
+	assert.LessOrEqual(t, len(s.Values), maxSampleValues)
+	for i, v := range s.Values {
+		assert.Equal(t, float64(1+i*s.Stride), v)
+	}
+
+	cases := []struct {
+		aggregation string
+		expected    float64
+		delta       float64
+	}{
+		{aggregation: "avg", expected: 5000.5},
+		{aggregation: "last", expected: 10000},
+		{aggregation: "peak", expected: 10000},
+		{aggregation: "min", expected: 1},
+		{aggregation: "sum", expected: 50005000},
+		{aggregation: "p50", expected: 5000, delta: 100},
+		{aggregation: "p95", expected: 9500, delta: 100},
+	}
+	for _, c := range cases {
+		t.Run(c.aggregation, func(t *testing.T) {
+			value, sd, err := s.aggregate(c.aggregation)
+			if assert.NoError(t, err) {
+				assert.InDelta(t, c.expected, value, c.delta)
+				assert.InDelta(t, 2886.75, sd, 0.01)
+			}
+		})
+	}
+}
+
+func podMetrics(name, app, cpu, memory string) *metricsv1beta1.PodMetrics {
+	return &metricsv1beta1.PodMetrics{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{"app": app},
+		},
+		Containers: []metricsv1beta1.ContainerMetrics{
+			{
+				Name: "main",
+				Usage: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse(cpu),
+					corev1.ResourceMemory: resource.MustParse(memory),
+				},
+			},
+		},
+	}
+}
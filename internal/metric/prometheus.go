@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -27,38 +28,84 @@ import (
 	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
 	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
 )
 
-// CaptureError describes problems that arise while capturing Prometheus metric values.
-type CaptureError struct {
-	// A description of what went wrong
-	Message string
-	// The URL that was used to capture the metric
-	Address string
-	// The metric query that failed
-	Query string
-	// The minimum amount of time until the metric is expected to be available
-	RetryAfter time.Duration
+func init() {
+	Register(optimizev1beta2.MetricPrometheus, &prometheusProvider{})
 }
 
-func (e *CaptureError) Error() string {
-	return e.Message
+// prometheusProvider captures values using PromQL queries against a Prometheus server.
+type prometheusProvider struct{}
+
+func (p *prometheusProvider) ApplyDefaults(t *optimizev1beta2.Trial, m *optimizev1beta2.Metric) error {
+	// Give Prometheus metrics a default URL
+	if m.URL == "" {
+		m.URL = fmt.Sprintf("http://optimize-%[1]s-prometheus.%[1]s:9090/", t.Namespace)
+	}
+	return nil
+}
+
+func (p *prometheusProvider) Validate(m *optimizev1beta2.Metric) []error {
+	errs := validateFeatures(m, featureRange|featureTLS|featureScrapeTargets|featureSamples)
+	if _, err := scrapeTargetMatcher(m.ScrapeTargets); err != nil {
+		errs = append(errs, err)
+	}
+	if m.Range != nil {
+		if _, _, err := aggregate(m.Range.Aggregation, []float64{0}); err != nil {
+			errs = append(errs, err)
+		}
+	} else if !strings.Contains(m.Query, "scalar") {
+		errs = append(errs, Warning("Prometheus query may require explicit scalar conversion"))
+	}
+	return errs
+}
+
+func (p *prometheusProvider) Capture(ctx context.Context, log logr.Logger, t *optimizev1beta2.Trial, m *optimizev1beta2.Metric, secret *corev1.Secret) (float64, float64, error) {
+	return capturePrometheusMetric(ctx, log, m, secret, t.Status.StartTime.Time, t.Status.CompletionTime.Time)
+}
+
+// CheckPrometheusConnection verifies that the Prometheus server of the supplied metric can be reached using the
+// authentication and TLS configuration of the metric.
+func CheckPrometheusConnection(ctx context.Context, m *optimizev1beta2.Metric, secret *corev1.Secret) error {
+	rt, err := newRoundTripper(m, secret)
+	if err != nil {
+		return err
+	}
+	c, err := prom.NewClient(prom.Config{Address: m.URL, RoundTripper: rt})
+	if err != nil {
+		return err
+	}
+	_, err = promv1.NewAPI(c).Targets(ctx)
+	return err
 }
 
-func capturePrometheusMetric(ctx context.Context, log logr.Logger, m *optimizev1beta2.Metric, completionTime time.Time) (value float64, valueError float64, err error) {
+func capturePrometheusMetric(ctx context.Context, log logr.Logger, m *optimizev1beta2.Metric, secret *corev1.Secret, startTime, completionTime time.Time) (value float64, valueError float64, err error) {
 	// Get the Prometheus API
-	c, err := prom.NewClient(prom.Config{Address: m.URL})
+	rt, err := newRoundTripper(m, secret)
+	if err != nil {
+		return 0, 0, err
+	}
+	c, err := prom.NewClient(prom.Config{Address: m.URL, RoundTripper: rt})
 	if err != nil {
 		return 0, 0, err
 	}
 	promAPI := promv1.NewAPI(c)
 
 	// Make sure Prometheus is ready
-	lastScrapeEndTime, err := checkReady(ctx, promAPI, completionTime)
+	lastScrapeEndTime, err := checkReady(ctx, promAPI, m.ScrapeTargets, completionTime)
 	if err != nil {
 		return 0, 0, err
 	}
 
+	// Range queries are evaluated over the entire trial run
+	if m.Range != nil {
+		return captureRangeMetric(ctx, promAPI, m, startTime, completionTime)
+	}
+
 	// Execute the query
 	value, err = queryScalar(ctx, promAPI, m.Query, completionTime)
 	if err != nil {
@@ -93,42 +140,182 @@ func capturePrometheusMetric(ctx context.Context, log logr.Logger, m *optimizev1
 	return value, valueError, nil
 }
 
-// Choose lower then normal default scrape parameters
-// TODO We could use `api.Config` to get the actual values (global defaults and per-target settings)
+func captureRangeMetric(ctx context.Context, api promv1.API, m *optimizev1beta2.Metric, startTime, completionTime time.Time) (value float64, valueError float64, err error) {
+	r := promv1.Range{Start: startTime, End: completionTime, Step: scrapeInterval}
+	if m.Range.Step != nil && m.Range.Step.Duration > 0 {
+		r.Step = m.Range.Step.Duration
+	}
+
+	// Execute the query over the range
+	values, err := queryRange(ctx, api, m.Query, r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Reduce the range to a single value, use the spread of the range as the error
+	value, valueError, err = aggregate(m.Range.Aggregation, values)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Treat NaN as an error condition, the same as an instant query
+	if math.IsNaN(value) {
+		return 0, 0, &CaptureError{Message: "metric data not available", Address: m.URL, Query: m.Query}
+	}
+
+	// Execute the error query (if configured), it takes precedence over the computed error
+	if m.ErrorQuery != "" {
+		valueError, err = queryScalar(ctx, api, m.ErrorQuery, completionTime)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return value, valueError, nil
+}
+
+// Choose lower then normal default scrape parameters, this is only used when the Prometheus configuration is not available
 const scrapeInterval = 5 * time.Second // Prometheus default is 1m
 
-func checkReady(ctx context.Context, api promv1.API, t time.Time) (time.Time, error) {
+// checkReady ensures the scrape targets matching the selector are healthy and have been scraped since the supplied
+// time, the time of the last scrape is returned.
+func checkReady(ctx context.Context, api promv1.API, sel *optimizev1beta2.ScrapeTargetSelector, t time.Time) (time.Time, error) {
+	matches, err := scrapeTargetMatcher(sel)
+	if err != nil {
+		return t, err
+	}
+
 	targets, err := api.Targets(ctx)
 	if err != nil {
 		return t, err
 	}
 
-	var lastScrape time.Time
+	intervals := scrapeIntervals(ctx, api)
 
+	var lastScrape time.Time
+	var matched bool
 	for _, target := range targets.Active {
+		if !matches(target) {
+			continue
+		}
+		matched = true
+
+		interval := intervals.interval(target)
 		if target.Health != promv1.HealthGood {
 			return t, &CaptureError{
 				Message:    fmt.Sprintf("scrape target is unhealthy (%s): %s", target.Health, target.LastError),
 				Address:    target.ScrapeURL,
-				RetryAfter: scrapeInterval,
+				RetryAfter: interval,
 			}
 		}
 
 		// Ensure we have done an additional scrape since completion time
-		if target.LastScrape.Before(t.Add(scrapeInterval)) {
+		if target.LastScrape.Before(t.Add(interval)) {
 			return t, &CaptureError{
 				Message:    "waiting for final scrape",
 				Address:    target.ScrapeURL,
-				RetryAfter: scrapeInterval,
+				RetryAfter: interval,
 			}
 		}
 
 		lastScrape = target.LastScrape
 	}
 
+	// Do not wait on a selector that will never match anything
+	if sel != nil && !matched {
+		return t, fmt.Errorf("no active scrape targets match the selector")
+	}
+
 	return lastScrape, nil
 }
 
+// scrapeTargetMatcher returns a function for filtering active scrape targets.
+func scrapeTargetMatcher(sel *optimizev1beta2.ScrapeTargetSelector) (func(promv1.ActiveTarget) bool, error) {
+	if sel == nil {
+		return func(promv1.ActiveTarget) bool { return true }, nil
+	}
+
+	ls := labels.Everything()
+	if sel.LabelSelector != nil {
+		var err error
+		if ls, err = metav1.LabelSelectorAsSelector(sel.LabelSelector); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(target promv1.ActiveTarget) bool {
+		if sel.Job != "" && string(target.Labels[model.JobLabel]) != sel.Job {
+			return false
+		}
+
+		lbls := make(labels.Set, len(target.Labels))
+		for k, v := range target.Labels {
+			lbls[string(k)] = string(v)
+		}
+		return ls.Matches(lbls)
+	}, nil
+}
+
+// scrapeConfig is the subset of the Prometheus configuration used to determine scrape intervals.
+type scrapeConfig struct {
+	Global struct {
+		ScrapeInterval string `json:"scrape_interval"`
+	} `json:"global"`
+	ScrapeConfigs []struct {
+		JobName        string `json:"job_name"`
+		ScrapeInterval string `json:"scrape_interval"`
+	} `json:"scrape_configs"`
+}
+
+// scrapeIntervalSet holds the global and per-job scrape intervals.
+type scrapeIntervalSet struct {
+	global time.Duration
+	jobs   map[string]time.Duration
+}
+
+// scrapeIntervals reads the scrape intervals from the Prometheus configuration. If the configuration is not
+// available, the default scrape interval is used for all targets.
+func scrapeIntervals(ctx context.Context, api promv1.API) *scrapeIntervalSet {
+	intervals := &scrapeIntervalSet{global: scrapeInterval}
+
+	cfg, err := api.Config(ctx)
+	if err != nil || cfg.YAML == "" {
+		return intervals
+	}
+
+	sc := &scrapeConfig{}
+	if err := yaml.Unmarshal([]byte(cfg.YAML), sc); err != nil {
+		return intervals
+	}
+
+	intervals.global = parseScrapeInterval(sc.Global.ScrapeInterval, time.Minute) // Prometheus default is 1m
+	intervals.jobs = make(map[string]time.Duration, len(sc.ScrapeConfigs))
+	for _, c := range sc.ScrapeConfigs {
+		intervals.jobs[c.JobName] = parseScrapeInterval(c.ScrapeInterval, intervals.global)
+	}
+	return intervals
+}
+
+// interval returns the scrape interval of the supplied target.
+func (s *scrapeIntervalSet) interval(target promv1.ActiveTarget) time.Duration {
+	// The discovered job label is the name of the scrape configuration, even if it is changed by relabeling
+	job, ok := target.DiscoveredLabels[model.JobLabel]
+	if !ok {
+		job = string(target.Labels[model.JobLabel])
+	}
+	if d, ok := s.jobs[job]; ok {
+		return d
+	}
+	return s.global
+}
+
+func parseScrapeInterval(s string, defaultInterval time.Duration) time.Duration {
+	if d, err := model.ParseDuration(s); err == nil && d > 0 {
+		return time.Duration(d)
+	}
+	return defaultInterval
+}
+
 func queryScalar(ctx context.Context, api promv1.API, q string, t time.Time) (float64, error) {
 	v, _, err := api.Query(ctx, q, t)
 	if err != nil {
@@ -140,15 +327,40 @@ func queryScalar(ctx context.Context, api promv1.API, q string, t time.Time) (fl
 	case *model.Scalar:
 		return float64(vt.Value), nil
 
-	case *model.Vector:
+	case model.Vector:
 		// Strictly mimic `scalar(q)` by returning NaN if the vector isn't a single element
 		// https://prometheus.io/docs/prometheus/latest/querying/functions/#scalar
-		if len(*vt) != 1 {
+		if len(vt) != 1 {
 			return math.NaN(), nil
 		}
-		return float64((*vt)[0].Value), nil
+		return float64(vt[0].Value), nil
 
 	default:
 		return 0, fmt.Errorf("expected scalar query result, got %s", v.Type())
 	}
 }
+
+func queryRange(ctx context.Context, api promv1.API, q string, r promv1.Range) ([]float64, error) {
+	v, _, err := api.QueryRange(ctx, q, r)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := v.(model.Matrix)
+	if !ok {
+		return nil, fmt.Errorf("expected matrix query result, got %s", v.Type())
+	}
+
+	// Similar to `queryScalar`, anything other then a single series has no value
+	if len(m) != 1 {
+		return nil, nil
+	}
+
+	values := make([]float64, 0, len(m[0].Values))
+	for _, p := range m[0].Values {
+		if !math.IsNaN(float64(p.Value)) {
+			values = append(values, float64(p.Value))
+		}
+	}
+	return values, nil
+}
@@ -2,9 +2,12 @@ package metric
 
 import (
 	"context"
+	"encoding/pem"
 	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,6 +15,10 @@ import (
 	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
 func TestPrometheusCheckReady(t *testing.T) {
@@ -57,7 +64,7 @@ func TestPrometheusCheckReady(t *testing.T) {
 			c, err := prom.NewClient(prom.Config{Address: promSrv.URL})
 			require.NoError(t, err)
 
-			_, err = checkReady(context.Background(), promv1.NewAPI(c), tc.completedTime)
+			_, err = checkReady(context.Background(), promv1.NewAPI(c), nil, tc.completedTime)
 
 			if tc.expectedError != nil {
 				require.Error(t, err)
@@ -73,9 +80,307 @@ func TestPrometheusCheckReady(t *testing.T) {
 }
 
 func promTargetsHttpTestServer(scrapeTime time.Time) *httptest.Server {
+	return httptest.NewServer(promTargetsHandler(scrapeTime))
+}
+
+func promTargetsHandler(scrapeTime time.Time) http.HandlerFunc {
 	respStr := `{"status":"success","data":{"activeTargets":[{"discoveredLabels":{"job":"kube-state-metrics"},"labels":{"instance":"localhost:8080","job":"kube-state-metrics"},"scrapePool":"kube-state-metrics","scrapeUrl":"http://localhost:8080/metrics","globalUrl":"http://optimize-default-prometheus-server-94df65748-bljzg:8080/metrics","lastError":"","lastScrape":%q,"lastScrapeDuration":0.0030478,"health":"up"},{"discoveredLabels":{"instance":"kind-control-plane","job":"kubernetes-cadvisor"},"labels":{"beta_kubernetes_io_arch":"amd64","beta_kubernetes_io_os":"linux","instance":"kind-control-plane","job":"kubernetes-cadvisor","kubernetes_io_arch":"amd64","kubernetes_io_hostname":"kind-control-plane","kubernetes_io_os":"linux"},"scrapePool":"kubernetes-cadvisor","scrapeUrl":"https://172.18.0.2:10250/metrics/cadvisor","globalUrl":"https://172.18.0.2:10250/metrics/cadvisor","lastError":"","lastScrape":%q,"lastScrapeDuration":0.0626849,"health":"up"},{"discoveredLabels":{"job":"prometheus-pushgateway"},"labels":{"instance":"localhost:9091","job":"prometheus-pushgateway"},"scrapePool":"prometheus-pushgateway","scrapeUrl":"http://localhost:9091/metrics","globalUrl":"http://optimize-default-prometheus-server-94df65748-bljzg:9091/metrics","lastError":"","lastScrape":%q,"lastScrapeDuration":0.0016028,"health":"up"}],"droppedTargets":[]}}`
-	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
 		t := scrapeTime.Format(time.RFC3339Nano)
 		fmt.Fprintf(w, respStr, t, t, t)
+	}
+}
+
+func TestPrometheusCheckReadyTargets(t *testing.T) {
+	completedTime := time.Now().UTC().Add(-time.Minute)
+	scrapeTime := completedTime.Add(20 * time.Second)
+
+	promSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/targets":
+			t := scrapeTime.Format(time.RFC3339Nano)
+			fmt.Fprintf(w, `{"status":"success","data":{"activeTargets":[`+
+				`{"discoveredLabels":{"job":"kube-state-metrics"},"labels":{"job":"kube-state-metrics","app":"ksm"},"scrapeUrl":"http://localhost:8080/metrics","lastError":"","lastScrape":%[1]q,"health":"up"},`+
+				`{"discoveredLabels":{"job":"kubernetes-cadvisor"},"labels":{"job":"kubernetes-cadvisor"},"scrapeUrl":"https://172.18.0.2:10250/metrics/cadvisor","lastError":"","lastScrape":%[1]q,"health":"up"},`+
+				`{"discoveredLabels":{"job":"my-app"},"labels":{"job":"my-app","app":"my-app"},"scrapeUrl":"http://10.0.0.1:8080/metrics","lastError":"connection refused","lastScrape":%[1]q,"health":"down"}`+
+				`],"droppedTargets":[]}}`, t)
+		case "/api/v1/status/config":
+			fmt.Fprint(w, `{"status":"success","data":{"yaml":"global:\n  scrape_interval: 15s\nscrape_configs:\n- job_name: kube-state-metrics\n  scrape_interval: 30s\n- job_name: kubernetes-cadvisor\n"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer promSrv.Close()
+
+	c, err := prom.NewClient(prom.Config{Address: promSrv.URL})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		desc          string
+		selector      *optimizev1beta2.ScrapeTargetSelector
+		expectedError *CaptureError
+		expectedFail  bool
+	}{
+		{
+			desc:          "all targets",
+			expectedError: &CaptureError{Message: "waiting for final scrape", RetryAfter: 30 * time.Second},
+		},
+		{
+			desc:     "job using global interval",
+			selector: &optimizev1beta2.ScrapeTargetSelector{Job: "kubernetes-cadvisor"},
+		},
+		{
+			desc:          "job using job interval",
+			selector:      &optimizev1beta2.ScrapeTargetSelector{Job: "kube-state-metrics"},
+			expectedError: &CaptureError{Message: "waiting for final scrape", RetryAfter: 30 * time.Second},
+		},
+		{
+			desc:          "label selector",
+			selector:      &optimizev1beta2.ScrapeTargetSelector{LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "my-app"}}},
+			expectedError: &CaptureError{Message: "scrape target is unhealthy (down): connection refused", RetryAfter: 15 * time.Second},
+		},
+		{
+			desc:         "label selector and job",
+			selector:     &optimizev1beta2.ScrapeTargetSelector{Job: "kubernetes-cadvisor", LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "my-app"}}},
+			expectedFail: true,
+		},
+		{
+			desc:         "no matches",
+			selector:     &optimizev1beta2.ScrapeTargetSelector{Job: "missing"},
+			expectedFail: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := checkReady(context.Background(), promv1.NewAPI(c), tc.selector, completedTime)
+
+			switch {
+			case tc.expectedFail:
+				require.Error(t, err)
+				_, ok := err.(*CaptureError)
+				assert.False(t, ok, "selector without matches should not be retried")
+			case tc.expectedError != nil:
+				require.Error(t, err)
+				require.IsType(t, &CaptureError{}, err)
+				assert.Equal(t, tc.expectedError.RetryAfter, err.(*CaptureError).RetryAfter)
+				assert.Equal(t, tc.expectedError.Message, err.(*CaptureError).Message)
+			default:
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPrometheusCaptureRange(t *testing.T) {
+	completionTime := time.Now().UTC().Add(-time.Minute)
+	startTime := completionTime.Add(-20 * time.Second)
+
+	promSrv := promRangeHttpTestServer(completionTime.Add(time.Minute), startTime, "1", "3", "2", "6")
+	defer promSrv.Close()
+
+	testCases := []struct {
+		desc          string
+		metricRange   *optimizev1beta2.MetricRange
+		errorQuery    string
+		expected      float64
+		expectedError float64
+	}{
+		{
+			desc:          "default aggregation",
+			metricRange:   &optimizev1beta2.MetricRange{},
+			expected:      3,
+			expectedError: math.Sqrt(3.5),
+		},
+		{
+			desc:          "max aggregation",
+			metricRange:   &optimizev1beta2.MetricRange{Aggregation: "max", Step: &metav1.Duration{Duration: 10 * time.Second}},
+			expected:      6,
+			expectedError: math.Sqrt(3.5),
+		},
+		{
+			desc:          "explicit error query",
+			metricRange:   &optimizev1beta2.MetricRange{Aggregation: "last"},
+			errorQuery:    "scalar(prometheus_build_info)",
+			expected:      6,
+			expectedError: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.desc), func(t *testing.T) {
+			m := &optimizev1beta2.Metric{
+				Name:       "testMetric",
+				Type:       optimizev1beta2.MetricPrometheus,
+				URL:        promSrv.URL,
+				Query:      "sum(rate(http_requests_total[1m]))",
+				ErrorQuery: tc.errorQuery,
+				Range:      tc.metricRange,
+			}
+
+			value, valueError, err := capturePrometheusMetric(context.Background(), zap.New(), m, nil, startTime, completionTime)
+			if assert.NoError(t, err) {
+				assert.InDelta(t, tc.expected, value, 1e-9)
+				assert.InDelta(t, tc.expectedError, valueError, 1e-9)
+			}
+		})
+	}
+}
+
+func TestPrometheusQueryScalar(t *testing.T) {
+	promSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("query") {
+		case "scalar":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"scalar","result":[1595471900.283,"1"]}}`)
+		case "vector":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1595471900.283,"2"]}]}}`)
+		case "empty vector":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+		case "multiple vector":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"a":"1"},"value":[1595471900.283,"2"]},{"metric":{"a":"2"},"value":[1595471900.283,"3"]}]}}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"string","result":[1595471900.283,"x"]}}`)
+		}
 	}))
+	defer promSrv.Close()
+
+	c, err := prom.NewClient(prom.Config{Address: promSrv.URL})
+	require.NoError(t, err)
+	api := promv1.NewAPI(c)
+
+	testCases := []struct {
+		query       string
+		expected    float64
+		expectedErr bool
+	}{
+		{query: "scalar", expected: 1},
+		{query: "vector", expected: 2},
+		{query: "empty vector", expected: math.NaN()},
+		{query: "multiple vector", expected: math.NaN()},
+		{query: "string", expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			value, err := queryScalar(context.Background(), api, tc.query, time.Now())
+			switch {
+			case tc.expectedErr:
+				assert.Error(t, err)
+			case math.IsNaN(tc.expected):
+				if assert.NoError(t, err) {
+					assert.True(t, math.IsNaN(value))
+				}
+			default:
+				if assert.NoError(t, err) {
+					assert.Equal(t, tc.expected, value)
+				}
+			}
+		})
+	}
+}
+
+func promRangeHttpTestServer(scrapeTime, startTime time.Time, values ...string) *httptest.Server {
+	targets := promTargetsHandler(scrapeTime)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/targets":
+			targets(w, r)
+		case "/api/v1/query_range":
+			var points []string
+			for i, v := range values {
+				points = append(points, fmt.Sprintf(`[%d,%q]`, startTime.Unix()+int64(i*5), v))
+			}
+			fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[%s]}]}}`, strings.Join(points, ","))
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"scalar","result":[1595471900.283,"1"]}}`)
+		}
+	}))
+}
+
+func TestPrometheusAuthentication(t *testing.T) {
+	completionTime := time.Now().UTC().Add(-time.Minute)
+	startTime := completionTime.Add(-20 * time.Second)
+
+	promHttpTest := promHttpTestServer()
+	defer promHttpTest.Close()
+
+	promSrv := httptest.NewTLSServer(authHandler(promHttpTest.Config.Handler))
+	defer promSrv.Close()
+	caCrt := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: promSrv.Certificate().Raw})
+
+	testCases := []struct {
+		desc        string
+		secret      *corev1.Secret
+		tls         *optimizev1beta2.MetricTLSConfig
+		expectedErr bool
+	}{
+		{
+			desc:        "unauthenticated",
+			secret:      &corev1.Secret{Data: map[string][]byte{"ca.crt": caCrt}},
+			tls:         &optimizev1beta2.MetricTLSConfig{UseSecretCA: true},
+			expectedErr: true,
+		},
+		{
+			desc:        "untrusted",
+			secret:      &corev1.Secret{Data: map[string][]byte{"token": []byte("testToken")}},
+			expectedErr: true,
+		},
+		{
+			desc:   "insecure",
+			secret: &corev1.Secret{Data: map[string][]byte{"token": []byte("testToken")}},
+			tls:    &optimizev1beta2.MetricTLSConfig{InsecureSkipVerify: true},
+		},
+		{
+			desc:        "secret CA not trusted",
+			secret:      &corev1.Secret{Data: map[string][]byte{"token": []byte("testToken"), "ca.crt": caCrt}},
+			expectedErr: true,
+		},
+		{
+			desc:        "missing secret CA",
+			secret:      &corev1.Secret{Data: map[string][]byte{"token": []byte("testToken")}},
+			tls:         &optimizev1beta2.MetricTLSConfig{UseSecretCA: true},
+			expectedErr: true,
+		},
+		{
+			desc:   "bearer token",
+			secret: &corev1.Secret{Data: map[string][]byte{"token": []byte("testToken"), "ca.crt": caCrt}},
+			tls:    &optimizev1beta2.MetricTLSConfig{UseSecretCA: true},
+		},
+		{
+			desc:   "basic auth",
+			secret: &corev1.Secret{Data: map[string][]byte{"username": []byte("testUser"), "password": []byte("testPassword"), "ca.crt": caCrt}},
+			tls:    &optimizev1beta2.MetricTLSConfig{UseSecretCA: true},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.desc), func(t *testing.T) {
+			m := &optimizev1beta2.Metric{
+				Name:  "testMetric",
+				Type:  optimizev1beta2.MetricPrometheus,
+				URL:   promSrv.URL,
+				Query: "scalar(prometheus_build_info)",
+				TLS:   tc.tls,
+			}
+
+			connErr := CheckPrometheusConnection(context.Background(), m, tc.secret)
+			value, _, err := capturePrometheusMetric(context.Background(), zap.New(), m, tc.secret, startTime, completionTime)
+			if tc.expectedErr {
+				assert.Error(t, connErr)
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, connErr)
+			if assert.NoError(t, err) {
+				assert.Equal(t, float64(1), value)
+			}
+		})
+	}
+}
+
+func authHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if r.Header.Get("Authorization") != "Bearer testToken" && (!ok || username != "testUser" || password != "testPassword") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
 }
@@ -0,0 +1,113 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"fmt"
+
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	pkgmetric "github.com/thestormforge/optimize-controller/v2/pkg/metric"
+)
+
+// The provider registry is public so that providers can be compiled in from outside of this module, the built-in
+// providers use it through these aliases.
+type (
+	// Provider is used to collect the values for a single metric type.
+	Provider = pkgmetric.Provider
+	// Warning is a problem reported during validation that does not prevent a metric from being captured.
+	Warning = pkgmetric.Warning
+	// CaptureError describes problems that arise while capturing metric values.
+	CaptureError = pkgmetric.CaptureError
+)
+
+// Register makes a metric provider available for the supplied metric type.
+func Register(metricType optimizev1beta2.MetricType, p Provider) {
+	pkgmetric.Register(metricType, p)
+}
+
+// Lookup returns the provider registered for the supplied metric type. An empty type is treated as "kubernetes".
+func Lookup(metricType optimizev1beta2.MetricType) (Provider, bool) {
+	return pkgmetric.Lookup(metricType)
+}
+
+// Types returns a sorted list of the registered metric types.
+func Types() []optimizev1beta2.MetricType {
+	return pkgmetric.Types()
+}
+
+// feature is an optional metric field which is only used by some providers.
+type feature int
+
+const (
+	featureRange feature = 1 << iota
+	featureTLS
+	featureHTTP
+	featureScrapeTargets
+	// featureSamples indicates the provider captures values over the trial run interval
+	featureSamples
+	// featureTemplatedSamples indicates the provider only captures values over the trial run interval when the
+	// queries or requests are templated using the start and completion time
+	featureTemplatedSamples
+)
+
+// errTemplatedSamples is reported when sampling depends on the use of the trial run interval in the templates.
+var errTemplatedSamples = Warning("Metric sampling requires queries or requests that use the trial start or completion time")
+
+// validateFeatures checks the optional metric fields against the features supported by a provider, fields which
+// are set but not supported are reported as a `Warning`.
+func validateFeatures(m *optimizev1beta2.Metric, supported feature) []error {
+	var errs []error
+	if m.Range != nil && supported&featureRange == 0 {
+		errs = append(errs, Warning("Metric range is not supported"))
+	}
+	if m.TLS != nil && supported&featureTLS == 0 {
+		errs = append(errs, Warning("Metric TLS configuration is not supported"))
+	} else {
+		errs = append(errs, validateTLS(m)...)
+	}
+	if m.HTTP != nil && supported&featureHTTP == 0 {
+		errs = append(errs, Warning("Metric HTTP request is not supported"))
+	}
+	if m.ScrapeTargets != nil && supported&featureScrapeTargets == 0 {
+		errs = append(errs, Warning("Metric scrape targets are not supported"))
+	}
+	if m.Samples > 1 {
+		switch {
+		case supported&featureSamples != 0:
+		case supported&featureTemplatedSamples != 0:
+			errs = append(errs, errTemplatedSamples)
+		default:
+			errs = append(errs, fmt.Errorf("metric sampling is not supported"))
+		}
+	}
+	return errs
+}
+
+// isWarning checks to see if the supplied error is a `Warning`.
+func isWarning(err error) bool {
+	_, ok := err.(Warning)
+	return ok
+}
+
+// ApplyDefaults fills in type specific default values for the supplied metric.
+func ApplyDefaults(t *optimizev1beta2.Trial, m *optimizev1beta2.Metric) error {
+	p, ok := Lookup(m.Type)
+	if !ok {
+		return fmt.Errorf("unknown metric type: %s", m.Type)
+	}
+	return p.ApplyDefaults(t, m)
+}
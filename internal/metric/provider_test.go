@@ -0,0 +1,45 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+)
+
+func TestValidateFeatures(t *testing.T) {
+	m := &optimizev1beta2.Metric{
+		URL:           "http://example.com",
+		Range:         &optimizev1beta2.MetricRange{},
+		TLS:           &optimizev1beta2.MetricTLSConfig{},
+		HTTP:          &optimizev1beta2.MetricHTTPRequest{},
+		ScrapeTargets: &optimizev1beta2.ScrapeTargetSelector{},
+	}
+
+	assert.Equal(t, []error{
+		Warning("Metric range is not supported"),
+		Warning("Metric TLS configuration is not supported"),
+		Warning("Metric HTTP request is not supported"),
+		Warning("Metric scrape targets are not supported"),
+	}, validateFeatures(m, 0))
+
+	assert.Equal(t, []error{
+		Warning("TLS configuration is ignored without an HTTPS URL"),
+	}, validateFeatures(m, featureRange|featureTLS|featureHTTP|featureScrapeTargets))
+}
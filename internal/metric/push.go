@@ -0,0 +1,100 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/go-logr/logr"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func init() {
+	Register(optimizev1beta2.MetricPush, &pushProvider{})
+}
+
+// pushProvider captures values which were pushed to the controller by the trial job.
+type pushProvider struct{}
+
+func (p *pushProvider) ApplyDefaults(*optimizev1beta2.Trial, *optimizev1beta2.Metric) error {
+	return nil
+}
+
+func (p *pushProvider) Validate(m *optimizev1beta2.Metric) []error {
+	errs := validateFeatures(m, 0)
+	if m.Query == "" {
+		errs = append(errs, fmt.Errorf("push metric %q must specify the name of the pushed value", m.Name))
+	}
+	if m.URL != "" || m.Target != nil {
+		errs = append(errs, Warning("Push metrics do not use a URL or target"))
+	}
+	return errs
+}
+
+func (p *pushProvider) Capture(_ context.Context, _ logr.Logger, t *optimizev1beta2.Trial, m *optimizev1beta2.Metric, _ *corev1.Secret) (float64, float64, error) {
+	values, err := loadPushedValues(t)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	value, ok := values[m.Query]
+	if !ok {
+		return 0, 0, fmt.Errorf("no value named %q was pushed during the trial run", m.Query)
+	}
+	return value, math.NaN(), nil
+}
+
+// PushValues records the supplied values on the trial, replacing any previously pushed values with the same name.
+func PushValues(t *optimizev1beta2.Trial, values map[string]float64) error {
+	pushed, err := loadPushedValues(t)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("invalid value for %q: %v", k, v)
+		}
+		pushed[k] = v
+	}
+
+	data, err := json.Marshal(pushed)
+	if err != nil {
+		return err
+	}
+
+	if t.Annotations == nil {
+		t.Annotations = make(map[string]string)
+	}
+	t.Annotations[optimizev1beta2.AnnotationPushedMetrics] = string(data)
+	return nil
+}
+
+// loadPushedValues returns the values pushed by the trial job, indexed by name.
+func loadPushedValues(t *optimizev1beta2.Trial) (map[string]float64, error) {
+	values := make(map[string]float64)
+	if data := t.Annotations[optimizev1beta2.AnnotationPushedMetrics]; data != "" {
+		if err := json.Unmarshal([]byte(data), &values); err != nil {
+			return nil, fmt.Errorf("invalid pushed metrics: %w", err)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+)
+
+func TestPushCapture(t *testing.T) {
+	trial := &optimizev1beta2.Trial{}
+	throughput := &optimizev1beta2.Metric{Name: "throughput", Type: optimizev1beta2.MetricPush, Query: "rps"}
+
+	_, _, err := CaptureMetric(context.TODO(), nil, nil, trial, throughput, nil, nil)
+	assert.EqualError(t, err, `no value named "rps" was pushed during the trial run`)
+
+	require.NoError(t, PushValues(trial, map[string]float64{"rps": 120, "errors": 3}))
+	require.NoError(t, PushValues(trial, map[string]float64{"rps": 125.5}))
+	assert.Error(t, PushValues(trial, map[string]float64{"rps": math.Inf(1)}))
+
+	value, _, err := CaptureMetric(context.TODO(), nil, nil, trial, throughput, nil, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 125.5, value)
+	}
+	assert.JSONEq(t, `{"rps":125.5,"errors":3}`, trial.Annotations[optimizev1beta2.AnnotationPushedMetrics])
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq" // Registers the "postgres" driver
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func init() {
+	Register(optimizev1beta2.MetricSQL, &sqlProvider{})
+}
+
+const (
+	// sqlTimeout is the overall time limit for connecting to the database and running the query
+	sqlTimeout = 10 * time.Second
+	// sqlRetryAfter is the delay before retrying a query that did not return a value
+	sqlRetryAfter = 5 * time.Second
+	// sqlRetryWindow is how long after the trial completes that missing values and connection failures are retried
+	// without counting against the remaining attempts, e.g. to allow results to be committed
+	sqlRetryWindow = 2 * time.Minute
+)
+
+// sqlOpen opens a database handle, it can be replaced for testing.
+var sqlOpen = sql.Open
+
+// sqlProvider captures values by running a query against a Postgres or MySQL database. The data source name is
+// read from the "SQL_DSN" secret key and must be either a "postgres://" URL or a "mysql://" prefixed MySQL DSN.
+type sqlProvider struct{}
+
+func (p *sqlProvider) ApplyDefaults(*optimizev1beta2.Trial, *optimizev1beta2.Metric) error {
+	return nil
+}
+
+func (p *sqlProvider) Validate(m *optimizev1beta2.Metric) []error {
+	errs := validateFeatures(m, featureTemplatedSamples)
+	if strings.TrimSpace(m.Query) == "" {
+		errs = append(errs, fmt.Errorf("SQL metric requires a query"))
+	}
+	if m.URL != "" {
+		errs = append(errs, Warning("SQL metric URL is ignored, the DSN is read from the secret"))
+	}
+	return errs
+}
+
+func (p *sqlProvider) Capture(ctx context.Context, _ logr.Logger, t *optimizev1beta2.Trial, m *optimizev1beta2.Metric, secret *corev1.Secret) (float64, float64, error) {
+	var completionTime time.Time
+	if t.Status.CompletionTime != nil {
+		completionTime = t.Status.CompletionTime.Time
+	}
+	return captureSQLMetric(ctx, m, secret, completionTime, time.Now())
+}
+
+func captureSQLMetric(ctx context.Context, m *optimizev1beta2.Metric, secret *corev1.Secret, completionTime, now time.Time) (float64, float64, error) {
+	dsn := credential(secret, "SQL_DSN")
+	if dsn == "" {
+		return 0, 0, missingCredential(secret, "SQL_DSN")
+	}
+
+	driverName, dataSourceName, address, err := sqlDataSource(dsn)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Missing values and unreachable databases are retried for a while after the trial completes
+	captureError := func(message string, retry bool) error {
+		err := &CaptureError{Message: message, Address: address, Query: m.Query}
+		if retry && now.Sub(completionTime) < sqlRetryWindow {
+			err.RetryAfter = sqlRetryAfter
+		}
+		return err
+	}
+
+	db, err := sqlOpen(driverName, dataSourceName)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(ctx, sqlTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, m.Query)
+	if err != nil {
+		var netErr net.Error
+		return 0, 0, captureError(err.Error(), errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded))
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, 0, captureError(err.Error(), false)
+	}
+	if len(cols) != 1 {
+		return 0, 0, captureError(fmt.Sprintf("expected SQL query to return one column, got %d", len(cols)), false)
+	}
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, 0, captureError(err.Error(), false)
+		}
+		return 0, 0, captureError("metric data not available", true)
+	}
+
+	var value sql.NullFloat64
+	if err := rows.Scan(&value); err != nil {
+		return 0, 0, captureError(err.Error(), false)
+	}
+	if rows.Next() {
+		return 0, 0, captureError("expected SQL query to return one row", false)
+	}
+	if !value.Valid {
+		return 0, 0, captureError("metric data not available", true)
+	}
+
+	return value.Float64, math.NaN(), nil
+}
+
+// sqlDataSource returns the driver name and data source name for the supplied DSN, along with a redacted address
+// suitable for logging.
+func sqlDataSource(dsn string) (driverName, dataSourceName, address string, err error) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", "", "", fmt.Errorf("invalid Postgres DSN: %w", err)
+		}
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		}
+		return "postgres", dsn, u.String(), nil
+
+	case strings.HasPrefix(dsn, "mysql://"):
+		dsn = strings.TrimPrefix(dsn, "mysql://")
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return "", "", "", fmt.Errorf("invalid MySQL DSN: %w", err)
+		}
+		if cfg.Passwd != "" {
+			cfg.Passwd = "xxxxx"
+		}
+		return "mysql", dsn, "mysql://" + cfg.FormatDSN(), nil
+
+	default:
+		return "", "", "", fmt.Errorf("unsupported SQL DSN, expected a postgres:// URL or a mysql:// DSN")
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// Drift returns the merge patch needed to bring the current object back to the patched state, or nil if the
+// patch operation is still reflected in the current state of the object. JSON patches are not idempotent, so instead
+// of applying them again the values of the patched fields are compared; operations whose effect cannot be verified
+// (e.g. appending to an array) are ignored.
+func Drift(current *unstructured.Unstructured, po *optimizev1beta2.PatchOperation) ([]byte, error) {
+	currentData, err := current.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply the patch locally, if nothing changes then the object has not drifted
+	var patchedData []byte
+	switch po.PatchType {
+	case types.JSONPatchType:
+		patchedData, err = jsonPatchFields(current.UnstructuredContent(), po.Data)
+		if err != nil {
+			return nil, err
+		}
+
+	case types.MergePatchType:
+		patchedData, err = jsonpatch.MergePatch(currentData, po.Data)
+		if err != nil {
+			return nil, err
+		}
+
+	case types.StrategicMergePatchType, types.ApplyPatchType:
+		// NOTE: Apply configurations are approximated using a strategic merge, ownership of fields is not considered
+		obj, err := scheme.Scheme.New(current.GroupVersionKind())
+		switch {
+		case runtime.IsNotRegisteredError(err):
+			// Custom resources do not support strategic merge, fall back to a regular merge
+			patchedData, err = jsonpatch.MergePatch(currentData, po.Data)
+		case err == nil:
+			patchedData, err = strategicpatch.StrategicMergePatch(currentData, po.Data, obj)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown patch type: %s", po.PatchType)
+	}
+
+	data, err := jsonpatch.CreateMergePatch(currentData, patchedData)
+	if err != nil || string(data) == "{}" {
+		return nil, err
+	}
+	return data, nil
+}
+
+// jsonPatchFields returns the current object with the values of the fields targeted by the JSON patch set to the
+// patched values.
+func jsonPatchFields(current map[string]interface{}, data []byte) ([]byte, error) {
+	p, err := jsonpatch.DecodePatch(data)
+	if err != nil {
+		return nil, err
+	}
+
+	patched := runtime.DeepCopyJSON(current)
+	for _, op := range p {
+		path, err := op.Path()
+		if err != nil {
+			return nil, err
+		}
+		tokens := pointerTokens(path)
+		if len(tokens) == 0 {
+			continue
+		}
+
+		key := tokens[len(tokens)-1]
+
+		switch op.Kind() {
+		case "add", "replace":
+			value, err := op.ValueInterface()
+			if err != nil {
+				return nil, err
+			}
+			switch pv := pointerParent(patched, tokens).(type) {
+			case map[string]interface{}:
+				pv[key] = value
+			case []interface{}:
+				// Appended values cannot be distinguished from the existing values
+				if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(pv) {
+					pv[i] = value
+				}
+			}
+
+		case "remove":
+			// Removing an array element shifts the remaining elements, only object fields can be verified
+			if pv, ok := pointerValue(patched, tokens[:len(tokens)-1]); ok {
+				if pv, ok := pv.(map[string]interface{}); ok {
+					delete(pv, key)
+				}
+			}
+		}
+	}
+
+	return json.Marshal(patched)
+}
+
+// pointerTokens splits a JSON pointer into unescaped reference tokens.
+func pointerTokens(path string) []string {
+	if path == "" || path == "/" {
+		return nil
+	}
+	tokens := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tokens[i], "~1", "/"), "~0", "~")
+	}
+	return tokens
+}
+
+// pointerParent returns the container of the value referenced by the JSON pointer tokens, missing object fields along
+// the way are created (they existed when the patch was originally applied).
+func pointerParent(doc interface{}, tokens []string) interface{} {
+	for _, token := range tokens[:len(tokens)-1] {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			v, ok := d[token]
+			if !ok || v == nil {
+				v = make(map[string]interface{})
+				d[token] = v
+			}
+			doc = v
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(d) {
+				return nil
+			}
+			doc = d[i]
+		default:
+			return nil
+		}
+	}
+	return doc
+}
+
+// pointerValue returns the value referenced by the JSON pointer tokens.
+func pointerValue(doc interface{}, tokens []string) (interface{}, bool) {
+	for _, token := range tokens {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			v, ok := d[token]
+			if !ok {
+				return nil, false
+			}
+			doc = v
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(d) {
+				return nil, false
+			}
+			doc = d[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
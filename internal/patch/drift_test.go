@@ -0,0 +1,165 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestDrift(t *testing.T) {
+	deployment := func() *unstructured.Unstructured {
+		u := &unstructured.Unstructured{}
+		_ = u.UnmarshalJSON([]byte(`{
+  "apiVersion": "apps/v1",
+  "kind": "Deployment",
+  "metadata": {"name": "myapp", "namespace": "default"},
+  "spec": {
+    "replicas": 3,
+    "template": {"spec": {"containers": [
+      {"name": "app", "image": "app:1", "resources": {"limits": {"memory": "1Gi"}}},
+      {"name": "proxy", "image": "proxy:1"}
+    ]}}
+  }
+}`))
+		return u
+	}
+
+	widget := &unstructured.Unstructured{}
+	_ = widget.UnmarshalJSON([]byte(`{"apiVersion": "example.com/v1", "kind": "Widget", "metadata": {"name": "w"}, "spec": {"size": 2}}`))
+
+	cases := []struct {
+		desc      string
+		current   *unstructured.Unstructured
+		patchType types.PatchType
+		data      string
+		expected  string
+	}{
+		{
+			desc:      "merge",
+			current:   deployment(),
+			patchType: types.MergePatchType,
+			data:      `{"spec":{"replicas":3}}`,
+		},
+		{
+			desc:      "merge drifted",
+			current:   deployment(),
+			patchType: types.MergePatchType,
+			data:      `{"spec":{"replicas":5}}`,
+			expected:  `{"spec":{"replicas":5}}`,
+		},
+		{
+			desc:      "strategic",
+			current:   deployment(),
+			patchType: types.StrategicMergePatchType,
+			data:      `{"spec":{"template":{"spec":{"containers":[{"name":"app","resources":{"limits":{"memory":"1Gi"}}}]}}}}`,
+		},
+		{
+			desc:      "strategic drifted",
+			current:   deployment(),
+			patchType: types.StrategicMergePatchType,
+			data:      `{"spec":{"template":{"spec":{"containers":[{"name":"proxy","image":"proxy:2"}]}}}}`,
+			expected:  `{"spec":{"template":{"spec":{"containers":[{"image":"app:1","name":"app","resources":{"limits":{"memory":"1Gi"}}},{"image":"proxy:2","name":"proxy"}]}}}}`,
+		},
+		{
+			desc:      "apply",
+			current:   deployment(),
+			patchType: types.ApplyPatchType,
+			data:      `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"myapp","namespace":"default"},"spec":{"replicas":3}}`,
+		},
+		{
+			desc:      "json",
+			current:   deployment(),
+			patchType: types.JSONPatchType,
+			data:      `[{"op":"replace","path":"/spec/template/spec/containers/1/image","value":"proxy:1"}]`,
+		},
+		{
+			desc:      "json drifted",
+			current:   deployment(),
+			patchType: types.JSONPatchType,
+			data:      `[{"op":"replace","path":"/spec/template/spec/containers/1/image","value":"proxy:2"}]`,
+			expected:  `{"spec":{"template":{"spec":{"containers":[{"image":"app:1","name":"app","resources":{"limits":{"memory":"1Gi"}}},{"image":"proxy:2","name":"proxy"}]}}}}`,
+		},
+		{
+			desc:      "json add field",
+			current:   deployment(),
+			patchType: types.JSONPatchType,
+			data:      `[{"op":"add","path":"/spec/replicas","value":3}]`,
+		},
+		{
+			desc:      "json add field drifted",
+			current:   deployment(),
+			patchType: types.JSONPatchType,
+			data:      `[{"op":"add","path":"/metadata/annotations/tuned","value":"true"}]`,
+			expected:  `{"metadata":{"annotations":{"tuned":"true"}}}`,
+		},
+		{
+			desc:      "json append",
+			current:   deployment(),
+			patchType: types.JSONPatchType,
+			data:      `[{"op":"add","path":"/spec/template/spec/containers/-","value":{"name":"sidecar","image":"sidecar:1"}}]`,
+		},
+		{
+			desc:      "json insert",
+			current:   deployment(),
+			patchType: types.JSONPatchType,
+			data:      `[{"op":"add","path":"/spec/template/spec/containers/1","value":{"name":"proxy","image":"proxy:1"}}]`,
+		},
+		{
+			desc:      "json remove",
+			current:   deployment(),
+			patchType: types.JSONPatchType,
+			data:      `[{"op":"remove","path":"/spec/strategy"}]`,
+		},
+		{
+			desc:      "json remove drifted",
+			current:   deployment(),
+			patchType: types.JSONPatchType,
+			data:      `[{"op":"remove","path":"/spec/replicas"}]`,
+			expected:  `{"spec":{"replicas":null}}`,
+		},
+		{
+			desc:      "json remove array element",
+			current:   deployment(),
+			patchType: types.JSONPatchType,
+			data:      `[{"op":"remove","path":"/spec/template/spec/containers/1"}]`,
+		},
+		{
+			desc:      "custom resource",
+			current:   widget,
+			patchType: types.StrategicMergePatchType,
+			data:      `{"spec":{"size":4}}`,
+			expected:  `{"spec":{"size":4}}`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			data, err := Drift(c.current, &optimizev1beta2.PatchOperation{PatchType: c.patchType, Data: []byte(c.data)})
+			if assert.NoError(t, err) {
+				if c.expected == "" {
+					assert.Nil(t, data)
+				} else {
+					assert.JSONEq(t, c.expected, string(data))
+				}
+			}
+		})
+	}
+}
@@ -17,8 +17,11 @@ limitations under the License.
 package patch
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 
 	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
 	"github.com/thestormforge/optimize-controller/v2/internal/template"
@@ -26,11 +29,72 @@ import (
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const defaultAttemptsRemaining = 3
 
+// FieldManager is the name of the field manager used when patching objects.
+const FieldManager = "stormforge-optimize"
+
+// ErrNoMatchingTargets is returned when the selector of a patch template does not match any objects.
+var ErrNoMatchingTargets = errors.New("patch selector did not match any objects")
+
+// ExpandTemplate returns a copy of the patch template for each object matched by the template's selector, each copy
+// has a target reference to a single object. Templates without a selector are returned as is. It is an error for the
+// selector not to match anything, otherwise the trial would run without the patch.
+func ExpandTemplate(ctx context.Context, r client.Reader, t *optimizev1beta2.Trial, p *optimizev1beta2.PatchTemplate) ([]optimizev1beta2.PatchTemplate, error) {
+	if p.Selector == nil {
+		return []optimizev1beta2.PatchTemplate{*p}, nil
+	}
+
+	// The target reference determines what type of objects we are searching for
+	if p.TargetRef == nil || p.TargetRef.Kind == "" {
+		return nil, fmt.Errorf("invalid patch reference: selector requires a kind")
+	}
+	if p.TargetRef.Name != "" {
+		return nil, fmt.Errorf("invalid patch reference: name and selector are mutually exclusive")
+	}
+	if r == nil {
+		return nil, fmt.Errorf("unable to match patch targets: missing reader")
+	}
+
+	sel, err := metav1.LabelSelectorAsSelector(p.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := p.TargetRef.Namespace
+	if namespace == "" {
+		namespace = t.Namespace
+	}
+
+	// RBAC: We assume that we have "list" permission from a customer defined role, same as the "patch" permission
+	ul := &unstructured.UnstructuredList{}
+	ul.SetGroupVersionKind(p.TargetRef.GroupVersionKind().GroupVersion().WithKind(p.TargetRef.Kind + "List"))
+	if err := r.List(ctx, ul, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: sel}); err != nil {
+		return nil, err
+	}
+
+	if len(ul.Items) == 0 {
+		return nil, fmt.Errorf("%w: %s %q in namespace %q", ErrNoMatchingTargets, p.TargetRef.Kind, sel.String(), namespace)
+	}
+
+	sort.Slice(ul.Items, func(i, j int) bool { return ul.Items[i].GetName() < ul.Items[j].GetName() })
+
+	result := make([]optimizev1beta2.PatchTemplate, 0, len(ul.Items))
+	for i := range ul.Items {
+		pt := p.DeepCopy()
+		pt.Selector = nil
+		pt.TargetRef.Namespace = ul.Items[i].GetNamespace()
+		pt.TargetRef.Name = ul.Items[i].GetName()
+		result = append(result, *pt)
+	}
+	return result, nil
+}
+
 // RenderTemplate determines the patch target and renders the patch template
 func RenderTemplate(te *template.Engine, t *optimizev1beta2.Trial, p *optimizev1beta2.PatchTemplate) (*corev1.ObjectReference, []byte, error) {
 	// Render the actual patch data
@@ -43,7 +107,7 @@ func RenderTemplate(te *template.Engine, t *optimizev1beta2.Trial, p *optimizev1
 	ref := &corev1.ObjectReference{}
 	if p.TargetRef != nil {
 		p.TargetRef.DeepCopyInto(ref)
-	} else if p.Type == optimizev1beta2.PatchStrategic || p.Type == "" {
+	} else if p.Type == optimizev1beta2.PatchStrategic || p.Type == optimizev1beta2.PatchApply || p.Type == "" {
 		m := &metav1.PartialObjectMetadata{}
 		if err := json.Unmarshal(data, m); err != nil {
 			return nil, nil, err
@@ -65,13 +129,60 @@ func RenderTemplate(te *template.Engine, t *optimizev1beta2.Trial, p *optimizev1
 	}
 
 	// Only allow an empty name for jobs (the only job you can patch is the trial job itself so we don't need the name)
-	if ref.Name == "" && ref.GroupVersionKind() != batchv1.SchemeGroupVersion.WithKind("Job") {
+	// or when the patch template has not been expanded to the objects matching its selector
+	if ref.Name == "" && p.Selector == nil && ref.GroupVersionKind() != batchv1.SchemeGroupVersion.WithKind("Job") {
 		return nil, nil, fmt.Errorf("invalid patch reference: missing name")
 	}
 
+	// Apply configurations must fully identify the object they are applied to
+	if p.Type == optimizev1beta2.PatchApply {
+		if data, err = applyConfiguration(ref, data); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	return ref, data, nil
 }
 
+// applyConfiguration validates the rendered apply patch against the reference, filling in any missing identifying fields
+func applyConfiguration(ref *corev1.ObjectReference, data []byte) ([]byte, error) {
+	if ref.APIVersion == "" {
+		return nil, fmt.Errorf("invalid apply patch: missing apiVersion")
+	}
+
+	// The type information may be omitted from the apply configuration, the reference fills it in
+	m := make(map[string]interface{})
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid apply patch: %w", err)
+	}
+	u := &unstructured.Unstructured{Object: m}
+
+	check := func(field, actual, expected string, set func(string)) error {
+		switch actual {
+		case "":
+			set(expected)
+		case expected:
+		default:
+			return fmt.Errorf("invalid apply patch: %s %q does not match target %q", field, actual, expected)
+		}
+		return nil
+	}
+	if err := check("apiVersion", u.GetAPIVersion(), ref.APIVersion, u.SetAPIVersion); err != nil {
+		return nil, err
+	}
+	if err := check("kind", u.GetKind(), ref.Kind, u.SetKind); err != nil {
+		return nil, err
+	}
+	if err := check("name", u.GetName(), ref.Name, u.SetName); err != nil {
+		return nil, err
+	}
+	if err := check("namespace", u.GetNamespace(), ref.Namespace, u.SetNamespace); err != nil {
+		return nil, err
+	}
+
+	return u.MarshalJSON()
+}
+
 // createPatchOperation creates a new patch operation from a patch template and it's (fully rendered) patch data
 func CreatePatchOperation(t *optimizev1beta2.Trial, p *optimizev1beta2.PatchTemplate, ref *corev1.ObjectReference, data []byte) (*optimizev1beta2.PatchOperation, error) {
 	// If the patch is effectively null, we do not need to evaluate it
@@ -93,6 +204,8 @@ func CreatePatchOperation(t *optimizev1beta2.Trial, p *optimizev1beta2.PatchTemp
 		po.PatchType = types.MergePatchType
 	case optimizev1beta2.PatchJSON:
 		po.PatchType = types.JSONPatchType
+	case optimizev1beta2.PatchApply:
+		po.PatchType = types.ApplyPatchType
 	default:
 		return nil, fmt.Errorf("unknown patch type: %s", p.Type)
 	}
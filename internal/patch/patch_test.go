@@ -17,15 +17,21 @@ limitations under the License.
 package patch
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
 	"github.com/thestormforge/optimize-controller/v2/internal/template"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func TestPatch(t *testing.T) {
@@ -137,6 +143,75 @@ metadata:
 			},
 			attemptsRemaining: defaultAttemptsRemaining,
 		},
+		{
+			desc:  "apply",
+			trial: trial,
+			patchTemplate: &optimizev1beta2.PatchTemplate{
+				Type:  optimizev1beta2.PatchApply,
+				Patch: patchSpec,
+				TargetRef: &corev1.ObjectReference{
+					Kind:       "Deployment",
+					APIVersion: "apps/v1",
+					Name:       "myapp",
+					Namespace:  "default",
+				},
+			},
+			attemptsRemaining: defaultAttemptsRemaining,
+		},
+		{
+			desc:  "apply w/o targetref",
+			trial: trial,
+			patchTemplate: &optimizev1beta2.PatchTemplate{
+				Type:      optimizev1beta2.PatchApply,
+				Patch:     fullPatch,
+				TargetRef: nil,
+			},
+			attemptsRemaining: defaultAttemptsRemaining,
+		},
+		{
+			desc:  "apply w/ mismatched targetref",
+			trial: trial,
+			patchTemplate: &optimizev1beta2.PatchTemplate{
+				Type:  optimizev1beta2.PatchApply,
+				Patch: fullPatch,
+				TargetRef: &corev1.ObjectReference{
+					Kind:       "Deployment",
+					APIVersion: "apps/v1",
+					Name:       "otherapp",
+					Namespace:  "default",
+				},
+			},
+			expectedRenderError: true,
+		},
+		{
+			desc:  "apply w/o apiVersion",
+			trial: trial,
+			patchTemplate: &optimizev1beta2.PatchTemplate{
+				Type:  optimizev1beta2.PatchApply,
+				Patch: patchSpec,
+				TargetRef: &corev1.ObjectReference{
+					Kind:      "Deployment",
+					Name:      "myapp",
+					Namespace: "default",
+				},
+			},
+			expectedRenderError: true,
+		},
+		{
+			desc:  "patchTrial - apply",
+			trial: trial,
+			patchTemplate: &optimizev1beta2.PatchTemplate{
+				Type:  optimizev1beta2.PatchApply,
+				Patch: patchSpec,
+				TargetRef: &corev1.ObjectReference{
+					Kind:       "Job",
+					APIVersion: "batch/v1",
+					Name:       trial.Name,
+					Namespace:  trial.Namespace,
+				},
+			},
+			expectedPOError: true,
+		},
 		{
 			desc:  "patchTrial - json",
 			trial: trial,
@@ -209,6 +284,10 @@ metadata:
 				if !strings.Contains(tc.desc, "patchTrial") {
 					assert.Equal(t, tc.patchTemplate.Patch, jsonPatch)
 				}
+			case optimizev1beta2.PatchApply:
+				if !strings.Contains(tc.desc, "patchTrial") {
+					assert.JSONEq(t, `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"myapp","namespace":"default"},"spec":{"template":{"spec":{"containers":[{"name":"postgres","imagePullPolicy":"Always"}]}}}}`, string(data))
+				}
 			}
 
 			// Test CreatePatchOperation
@@ -228,3 +307,69 @@ metadata:
 		})
 	}
 }
+
+func TestExpandTemplate(t *testing.T) {
+	deployment := func(namespace, name, tier string) *appsv1.Deployment {
+		return &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    map[string]string{"tier": tier},
+		}}
+	}
+	c := fake.NewFakeClientWithScheme(scheme.Scheme,
+		deployment("default", "web", "frontend"),
+		deployment("default", "api", "frontend"),
+		deployment("default", "db", "backend"),
+		deployment("other", "web", "frontend"),
+	)
+	trial := &optimizev1beta2.Trial{ObjectMeta: metav1.ObjectMeta{Name: "my-trial", Namespace: "default"}}
+	ctx := context.TODO()
+
+	// Templates without a selector are not expanded
+	p := &optimizev1beta2.PatchTemplate{
+		TargetRef: &corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "db"},
+	}
+	pts, err := ExpandTemplate(ctx, nil, trial, p)
+	require.NoError(t, err)
+	assert.Equal(t, []optimizev1beta2.PatchTemplate{*p}, pts)
+
+	// Selectors match in the trial namespace by default
+	p = &optimizev1beta2.PatchTemplate{
+		Type:      optimizev1beta2.PatchMerge,
+		TargetRef: &corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment"},
+		Selector:  &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "frontend"}},
+	}
+	pts, err = ExpandTemplate(ctx, c, trial, p)
+	require.NoError(t, err)
+	if assert.Len(t, pts, 2) {
+		assert.Equal(t, &corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "api"}, pts[0].TargetRef)
+		assert.Equal(t, &corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "web"}, pts[1].TargetRef)
+		assert.Nil(t, pts[0].Selector)
+		assert.Equal(t, optimizev1beta2.PatchMerge, pts[0].Type)
+	}
+	assert.Empty(t, p.TargetRef.Name, "original template must not be modified")
+
+	// An explicit namespace overrides the trial namespace
+	p.TargetRef.Namespace = "other"
+	pts, err = ExpandTemplate(ctx, c, trial, p)
+	require.NoError(t, err)
+	if assert.Len(t, pts, 1) {
+		assert.Equal(t, "other", pts[0].TargetRef.Namespace)
+	}
+
+	// Selectors must match something
+	_, err = ExpandTemplate(ctx, c, trial, &optimizev1beta2.PatchTemplate{
+		TargetRef: &corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment"},
+		Selector:  &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "cache"}},
+	})
+	assert.True(t, errors.Is(err, ErrNoMatchingTargets))
+
+	// Selectors require a kind and no name
+	_, err = ExpandTemplate(ctx, c, trial, &optimizev1beta2.PatchTemplate{Selector: p.Selector})
+	assert.Error(t, err)
+	_, err = ExpandTemplate(ctx, c, trial, &optimizev1beta2.PatchTemplate{
+		TargetRef: &corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "web"},
+		Selector:  p.Selector,
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// RestartAnnotation is the pod template annotation used to trigger a rollout, it is the same annotation used
+	// by `kubectl rollout restart`.
+	RestartAnnotation = "kubectl.kubernetes.io/restartedAt"
+)
+
+// FindConsumers returns references to the workloads whose pod templates consume the referenced config map or secret.
+// Only workloads in the same namespace as the configuration object are considered.
+func FindConsumers(ctx context.Context, r client.Reader, ref *corev1.ObjectReference) ([]corev1.ObjectReference, error) {
+	var result []corev1.ObjectReference
+	add := func(kind string, obj metav1.Object, spec *corev1.PodSpec) {
+		if consumes(spec, ref) {
+			result = append(result, corev1.ObjectReference{
+				APIVersion: appsv1.SchemeGroupVersion.String(),
+				Kind:       kind,
+				Namespace:  obj.GetNamespace(),
+				Name:       obj.GetName(),
+			})
+		}
+	}
+
+	// RBAC: We assume that we have "list" permission from a customer defined role, same as the "patch" permission
+	deployments := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deployments, client.InNamespace(ref.Namespace)); err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		add("Deployment", &deployments.Items[i], &deployments.Items[i].Spec.Template.Spec)
+	}
+
+	statefulSets := &appsv1.StatefulSetList{}
+	if err := r.List(ctx, statefulSets, client.InNamespace(ref.Namespace)); err != nil {
+		return nil, err
+	}
+	for i := range statefulSets.Items {
+		add("StatefulSet", &statefulSets.Items[i], &statefulSets.Items[i].Spec.Template.Spec)
+	}
+
+	daemonSets := &appsv1.DaemonSetList{}
+	if err := r.List(ctx, daemonSets, client.InNamespace(ref.Namespace)); err != nil {
+		return nil, err
+	}
+	for i := range daemonSets.Items {
+		add("DaemonSet", &daemonSets.Items[i], &daemonSets.Items[i].Spec.Template.Spec)
+	}
+
+	return result, nil
+}
+
+// CreateRestartOperation creates a new patch operation that triggers a rollout of the referenced workload.
+func CreateRestartOperation(ref *corev1.ObjectReference, restartedAt *metav1.Time) (*optimizev1beta2.PatchOperation, error) {
+	data, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						RestartAnnotation: restartedAt.UTC().Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &optimizev1beta2.PatchOperation{
+		TargetRef:         *ref,
+		PatchType:         types.StrategicMergePatchType,
+		Data:              data,
+		AttemptsRemaining: defaultAttemptsRemaining,
+	}, nil
+}
+
+// consumes checks to see if the pod spec references the config map or secret
+func consumes(spec *corev1.PodSpec, ref *corev1.ObjectReference) bool {
+	configMap := ref.Kind == "ConfigMap"
+	secret := ref.Kind == "Secret"
+
+	for _, v := range spec.Volumes {
+		switch {
+		case configMap && v.ConfigMap != nil && v.ConfigMap.Name == ref.Name:
+			return true
+		case secret && v.Secret != nil && v.Secret.SecretName == ref.Name:
+			return true
+		case v.Projected != nil:
+			for _, s := range v.Projected.Sources {
+				if configMap && s.ConfigMap != nil && s.ConfigMap.Name == ref.Name {
+					return true
+				}
+				if secret && s.Secret != nil && s.Secret.Name == ref.Name {
+					return true
+				}
+			}
+		}
+	}
+
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, c := range containers {
+		for _, e := range c.EnvFrom {
+			if configMap && e.ConfigMapRef != nil && e.ConfigMapRef.Name == ref.Name {
+				return true
+			}
+			if secret && e.SecretRef != nil && e.SecretRef.Name == ref.Name {
+				return true
+			}
+		}
+		for _, e := range c.Env {
+			if e.ValueFrom == nil {
+				continue
+			}
+			if configMap && e.ValueFrom.ConfigMapKeyRef != nil && e.ValueFrom.ConfigMapKeyRef.Name == ref.Name {
+				return true
+			}
+			if secret && e.ValueFrom.SecretKeyRef != nil && e.ValueFrom.SecretKeyRef.Name == ref.Name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestFindConsumers(t *testing.T) {
+	podSpec := func(spec corev1.PodSpec) corev1.PodTemplateSpec { return corev1.PodTemplateSpec{Spec: spec} }
+	c := fake.NewFakeClientWithScheme(scheme.Scheme,
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "env-from", Namespace: "default"},
+			Spec: appsv1.DeploymentSpec{Template: podSpec(corev1.PodSpec{Containers: []corev1.Container{{
+				EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "app-config"}}}},
+			}}})},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "default"},
+			Spec: appsv1.DeploymentSpec{Template: podSpec(corev1.PodSpec{Containers: []corev1.Container{{
+				EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "other-config"}}}},
+			}}})},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "volume", Namespace: "default"},
+			Spec: appsv1.StatefulSetSpec{Template: podSpec(corev1.PodSpec{Volumes: []corev1.Volume{{
+				VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "app-config"}}},
+			}}})},
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "env", Namespace: "default"},
+			Spec: appsv1.DaemonSetSpec{Template: podSpec(corev1.PodSpec{InitContainers: []corev1.Container{{
+				Env: []corev1.EnvVar{{ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "app-config"}}}}},
+			}}})},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "other-namespace", Namespace: "other"},
+			Spec: appsv1.DeploymentSpec{Template: podSpec(corev1.PodSpec{Volumes: []corev1.Volume{{
+				VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "app-config"}}},
+			}}})},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "default"},
+			Spec: appsv1.DeploymentSpec{Template: podSpec(corev1.PodSpec{Volumes: []corev1.Volume{{
+				VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{
+					{Secret: &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "app-config"}}},
+				}}},
+			}}})},
+		},
+	)
+
+	refs, err := FindConsumers(context.TODO(), c, &corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "app-config"})
+	require.NoError(t, err)
+	assert.Equal(t, []corev1.ObjectReference{
+		{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "env-from"},
+		{APIVersion: "apps/v1", Kind: "StatefulSet", Namespace: "default", Name: "volume"},
+		{APIVersion: "apps/v1", Kind: "DaemonSet", Namespace: "default", Name: "env"},
+	}, refs)
+
+	refs, err = FindConsumers(context.TODO(), c, &corev1.ObjectReference{APIVersion: "v1", Kind: "Secret", Namespace: "default", Name: "app-config"})
+	require.NoError(t, err)
+	assert.Equal(t, []corev1.ObjectReference{
+		{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "secret"},
+	}, refs)
+}
+
+func TestCreateRestartOperation(t *testing.T) {
+	ref := &corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "myapp"}
+	restartedAt := metav1.NewTime(time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC))
+
+	po, err := CreateRestartOperation(ref, &restartedAt)
+	require.NoError(t, err)
+	assert.Equal(t, *ref, po.TargetRef)
+	assert.Equal(t, types.StrategicMergePatchType, po.PatchType)
+	assert.JSONEq(t, `{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":"2022-04-01T12:00:00Z"}}}}}`, string(po.Data))
+	assert.Equal(t, defaultAttemptsRemaining, po.AttemptsRemaining)
+}
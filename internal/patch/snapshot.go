@@ -0,0 +1,225 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// RestoreFinalizer is used to prevent deletion until patched objects are restored to their original state.
+	RestoreFinalizer = "restoreFinalizer.stormforge.io"
+
+	// maxSnapshotSize is the limit on the recorded state of all the patched objects, it leaves room for the object
+	// metadata within the 1MiB limit imposed on config maps.
+	maxSnapshotSize = 1000 * 1000
+)
+
+// ErrSnapshotTooLarge is returned when recording an object would exceed the size limit of the snapshot.
+var ErrSnapshotTooLarge = errors.New("snapshot of the original patched objects is too large")
+
+// unrestoredAnnotations are maintained by other controllers or tools and are never restored.
+var unrestoredAnnotations = []string{
+	"deployment.kubernetes.io/revision",
+	"kubectl.kubernetes.io/last-applied-configuration",
+}
+
+// NeedsSnapshot checks to see if the experiment restores patched objects.
+func NeedsSnapshot(exp *optimizev1beta2.Experiment) bool {
+	switch exp.Spec.RestorePolicy {
+	case optimizev1beta2.RestoreExperiment, optimizev1beta2.RestoreTrial:
+		return true
+	default:
+		return false
+	}
+}
+
+// SnapshotNamespacedName returns the name of the config map holding the original state of the patched objects for
+// the specified experiment.
+func SnapshotNamespacedName(exp types.NamespacedName) types.NamespacedName {
+	return types.NamespacedName{Namespace: exp.Namespace, Name: exp.Name + "-snapshot"}
+}
+
+// NewSnapshot returns a new, empty config map for recording the original state of objects patched by the experiment.
+// The caller is responsible for establishing ownership.
+func NewSnapshot(exp *optimizev1beta2.Experiment) *corev1.ConfigMap {
+	nn := SnapshotNamespacedName(types.NamespacedName{Namespace: exp.Namespace, Name: exp.Name})
+	s := &corev1.ConfigMap{}
+	s.Namespace = nn.Namespace
+	s.Name = nn.Name
+	s.Labels = map[string]string{optimizev1beta2.LabelExperiment: exp.Name}
+	return s
+}
+
+// IsSnapshotSupported checks to see if the state of the referenced object can be recorded. Secrets are never recorded
+// since that would copy their data into a config map.
+func IsSnapshotSupported(ref *corev1.ObjectReference) bool {
+	return ref.GroupVersionKind().GroupKind() != corev1.SchemeGroupVersion.WithKind("Secret").GroupKind()
+}
+
+// AddSnapshot records the state of an object before it is patched. Only the first recorded state of an object is
+// kept, returns false if the snapshot already has an entry for the object.
+func AddSnapshot(s *corev1.ConfigMap, obj *unstructured.Unstructured) (bool, error) {
+	key := snapshotKey(obj.GroupVersionKind().GroupKind().String(), obj.GetNamespace(), obj.GetName())
+	if _, ok := s.Data[key]; ok {
+		return false, nil
+	}
+
+	// Only the object identity, labels, annotations and the non-metadata, non-status fields are recorded
+	u := &unstructured.Unstructured{Object: restorableContent(obj)}
+	u.SetNamespace(obj.GetNamespace())
+	u.SetName(obj.GetName())
+
+	data, err := json.Marshal(u)
+	if err != nil {
+		return false, err
+	}
+
+	size := len(key) + len(data)
+	for k, v := range s.Data {
+		size += len(k) + len(v)
+	}
+	if size > maxSnapshotSize {
+		return false, fmt.Errorf("%w: unable to record %s %s", ErrSnapshotTooLarge, obj.GetKind(), obj.GetName())
+	}
+
+	if s.Data == nil {
+		s.Data = make(map[string]string)
+	}
+	s.Data[key] = string(data)
+	return true, nil
+}
+
+// Snapshot returns the recorded state of the referenced object, or nil if there is no recorded state.
+func Snapshot(s *corev1.ConfigMap, ref *corev1.ObjectReference) (*unstructured.Unstructured, error) {
+	data, ok := s.Data[snapshotKey(ref.GroupVersionKind().GroupKind().String(), ref.Namespace, ref.Name)]
+	if !ok {
+		return nil, nil
+	}
+
+	u := &unstructured.Unstructured{}
+	if err := json.Unmarshal([]byte(data), u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Snapshots returns the recorded state of all the objects in the snapshot.
+func Snapshots(s *corev1.ConfigMap) ([]*unstructured.Unstructured, error) {
+	keys := make([]string, 0, len(s.Data))
+	for k := range s.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := make([]*unstructured.Unstructured, 0, len(keys))
+	for _, k := range keys {
+		u := &unstructured.Unstructured{}
+		if err := json.Unmarshal([]byte(s.Data[k]), u); err != nil {
+			return nil, err
+		}
+		result = append(result, u)
+	}
+	return result, nil
+}
+
+// Restore patches the live object back to the recorded state. Objects which no longer exist are ignored.
+func Restore(ctx context.Context, r client.Reader, w client.Writer, original *unstructured.Unstructured) error {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(original.GroupVersionKind())
+	if err := r.Get(ctx, client.ObjectKey{Namespace: original.GetNamespace(), Name: original.GetName()}, u); err != nil {
+		if apierrs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	data, err := RestorePatch(u, original)
+	if err != nil || data == nil {
+		return err
+	}
+
+	// RBAC: We assume that we have "patch" permission from a customer defined role, same as applying the patch
+	return w.Patch(ctx, u, client.RawPatch(types.MergePatchType, data))
+}
+
+// RestorePatch returns the merge patch needed to return the current object to the original state, or nil if the
+// object has not changed.
+func RestorePatch(current, original *unstructured.Unstructured) ([]byte, error) {
+	currentData, err := json.Marshal(restorableContent(current))
+	if err != nil {
+		return nil, err
+	}
+	originalData, err := json.Marshal(restorableContent(original))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := jsonpatch.CreateMergePatch(currentData, originalData)
+	if err != nil || string(data) == "{}" {
+		return nil, err
+	}
+	return data, nil
+}
+
+// restorableContent returns the object content that is restored, only the labels and annotations of the metadata are
+// restored and status is never restored
+func restorableContent(obj *unstructured.Unstructured) map[string]interface{} {
+	labels := obj.GetLabels()
+	annotations := obj.GetAnnotations()
+	for _, k := range unrestoredAnnotations {
+		delete(annotations, k)
+	}
+
+	content := obj.DeepCopy().UnstructuredContent()
+	delete(content, "status")
+
+	// Always include (possibly empty) maps so a merge patch removes labels and annotations added since the snapshot
+	content["metadata"] = map[string]interface{}{
+		"labels":      stringMap(labels),
+		"annotations": stringMap(annotations),
+	}
+	return content
+}
+
+// stringMap converts a string map into unstructured content.
+func stringMap(m map[string]string) map[string]interface{} {
+	result := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
+
+// snapshotKey returns the config map key used to record the state of an object
+func snapshotKey(groupKind, namespace, name string) string {
+	// Underscores cannot appear in any of the parts, but they are allowed in config map keys
+	return strings.Join([]string{groupKind, namespace, name}, "_")
+}
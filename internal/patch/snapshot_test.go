@@ -0,0 +1,141 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package patch
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	original := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app-config",
+			Namespace:   "default",
+			Labels:      map[string]string{"app": "my-app"},
+			Annotations: map[string]string{"tuned": "false"},
+		},
+		Data: map[string]string{"threads": "4", "cache": "on"},
+	}
+	c := fake.NewFakeClientWithScheme(scheme.Scheme, original.DeepCopy())
+	ctx := context.TODO()
+	ref := &corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "app-config"}
+
+	exp := &optimizev1beta2.Experiment{ObjectMeta: metav1.ObjectMeta{Name: "my-exp", Namespace: "default"}}
+	cm := NewSnapshot(exp)
+	assert.Equal(t, types.NamespacedName{Namespace: "default", Name: "my-exp-snapshot"}, types.NamespacedName{Namespace: cm.Namespace, Name: cm.Name})
+
+	// Record the original state
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(ref.GroupVersionKind())
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, u))
+	added, err := AddSnapshot(cm, u)
+	require.NoError(t, err)
+	assert.True(t, added)
+	assert.Contains(t, cm.Data, "ConfigMap_default_app-config")
+
+	// Patch the object, only the first recorded state is kept
+	require.NoError(t, c.Patch(ctx, u, client.RawPatch(types.MergePatchType, []byte(`{"metadata":{"labels":{"extra":"x"},"annotations":{"tuned":"true","kubectl.kubernetes.io/last-applied-configuration":"{}"}},"data":{"threads":"16","cache":null,"extra":"x"}}`))))
+	added, err = AddSnapshot(cm, u)
+	require.NoError(t, err)
+	assert.False(t, added)
+
+	// There is nothing to restore for objects that were never recorded
+	missing, err := Snapshot(cm, &corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "other"})
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+
+	// Restore the original state
+	snapshot, err := Snapshot(cm, ref)
+	require.NoError(t, err)
+	require.NotNil(t, snapshot)
+	require.NoError(t, Restore(ctx, c, c, snapshot))
+
+	restored := &corev1.ConfigMap{}
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, restored))
+	assert.Equal(t, original.Data, restored.Data)
+	assert.Equal(t, original.Labels, restored.Labels)
+	assert.Equal(t, map[string]string{"tuned": "false", "kubectl.kubernetes.io/last-applied-configuration": "{}"}, restored.Annotations)
+
+	// Restoring again is a no-op
+	all, err := Snapshots(cm)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	current := &unstructured.Unstructured{}
+	current.SetGroupVersionKind(ref.GroupVersionKind())
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, current))
+	data, err := RestorePatch(current, all[0])
+	require.NoError(t, err)
+	assert.Nil(t, data)
+
+	// Deleted objects are ignored
+	require.NoError(t, c.Delete(ctx, restored))
+	assert.NoError(t, Restore(ctx, c, c, snapshot))
+}
+
+func TestAddSnapshotTooLarge(t *testing.T) {
+	s := NewSnapshot(&optimizev1beta2.Experiment{ObjectMeta: metav1.ObjectMeta{Name: "my-exp", Namespace: "default"}})
+
+	newConfigMap := func(name string, size int) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{}
+		u.SetAPIVersion("v1")
+		u.SetKind("ConfigMap")
+		u.SetNamespace("default")
+		u.SetName(name)
+		u.Object["data"] = map[string]interface{}{"blob": strings.Repeat("x", size)}
+		return u
+	}
+
+	added, err := AddSnapshot(s, newConfigMap("first", maxSnapshotSize/2))
+	require.NoError(t, err)
+	assert.True(t, added)
+
+	added, err = AddSnapshot(s, newConfigMap("second", maxSnapshotSize/2))
+	assert.True(t, errors.Is(err, ErrSnapshotTooLarge))
+	assert.False(t, added)
+	assert.Len(t, s.Data, 1)
+}
+
+func TestNeedsSnapshot(t *testing.T) {
+	for _, p := range []optimizev1beta2.RestorePolicy{"", optimizev1beta2.RestoreNever, "unknown"} {
+		assert.False(t, NeedsSnapshot(&optimizev1beta2.Experiment{Spec: optimizev1beta2.ExperimentSpec{RestorePolicy: p}}), string(p))
+	}
+	for _, p := range []optimizev1beta2.RestorePolicy{optimizev1beta2.RestoreExperiment, optimizev1beta2.RestoreTrial} {
+		assert.True(t, NeedsSnapshot(&optimizev1beta2.Experiment{Spec: optimizev1beta2.ExperimentSpec{RestorePolicy: p}}), string(p))
+	}
+}
+
+func TestIsSnapshotSupported(t *testing.T) {
+	assert.True(t, IsSnapshotSupported(&corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap"}))
+	assert.True(t, IsSnapshotSupported(&corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment"}))
+	assert.True(t, IsSnapshotSupported(&corev1.ObjectReference{APIVersion: "example.com/v1", Kind: "Secret"}))
+	assert.False(t, IsSnapshotSupported(&corev1.ObjectReference{APIVersion: "v1", Kind: "Secret"}))
+}
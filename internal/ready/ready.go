@@ -19,9 +19,14 @@ package ready
 import (
 	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	"github.com/thestormforge/optimize-controller/v2/internal/template"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -51,12 +56,18 @@ const (
 	// of the target object. The name of the status field and the expected value (indicating a ready state) should
 	// be appended to this constant, e.g. `"stormforge.io/status-phase-running"` to check for a running pod.
 	ConditionTypeStatus = "stormforge.io/status-"
+	// ConditionTypeHTTPGet is a special condition type whose status is determined by making an HTTP GET request from
+	// the controller. The URL and expected status codes are configured separately on the readiness check, the URL is
+	// a template rendered using the target object.
+	ConditionTypeHTTPGet = "stormforge.io/http-get"
 )
 
 // ReadinessChecker is used to check the conditions of runtime objects
 type ReadinessChecker struct {
 	// Reader is used to fetch information about objects related to the object whose conditions are being checked
 	Reader client.Reader
+	// HTTPClient is used to make HTTP requests, if nil a client with a short timeout is used
+	HTTPClient *http.Client
 }
 
 // ReadinessError is an error that occurs while testing for readiness, it indicates a "hard failure" and is not just
@@ -80,8 +91,9 @@ func (e *ReadinessError) Error() string {
 
 // CheckConditions checks to see that all of the listed conditions have a status of true on the specified object. Note
 // that in addition to generically checking in the `status.conditions` field, special conditions are also supported. The
-// special conditions are prefixed with "stormforge.io/".
-func (r *ReadinessChecker) CheckConditions(ctx context.Context, obj *unstructured.Unstructured, conditionTypes []string) (string, bool, error) {
+// special conditions are prefixed with "stormforge.io/". The HTTP GET configuration is only required when checking
+// the "http-get" condition type.
+func (r *ReadinessChecker) CheckConditions(ctx context.Context, obj *unstructured.Unstructured, conditionTypes []string, httpGet *optimizev1beta2.HTTPGetCheck) (string, bool, error) {
 	for _, c := range conditionTypes {
 		var msg string
 		var s corev1.ConditionStatus
@@ -97,6 +109,8 @@ func (r *ReadinessChecker) CheckConditions(ctx context.Context, obj *unstructure
 			msg, s, err = r.rolloutStatus(obj)
 		case ConditionTypeAppReady:
 			msg, s, err = r.appReady(ctx, obj)
+		case ConditionTypeHTTPGet:
+			msg, s, err = r.httpGet(ctx, obj, httpGet)
 		default:
 			if strings.HasPrefix(c, ConditionTypeStatus) {
 				msg, s, err = r.statusField(obj, c)
@@ -132,6 +146,46 @@ func (r *ReadinessChecker) alwaysTrue(obj *unstructured.Unstructured) (string, c
 	return "", corev1.ConditionTrue, nil
 }
 
+// httpGet makes an HTTP request to determine if the target is responding
+func (r *ReadinessChecker) httpGet(ctx context.Context, obj *unstructured.Unstructured, httpGet *optimizev1beta2.HTTPGetCheck) (string, corev1.ConditionStatus, error) {
+	if httpGet == nil || httpGet.URL == "" {
+		return "", corev1.ConditionFalse, fmt.Errorf("missing HTTP GET configuration for condition: %s", ConditionTypeHTTPGet)
+	}
+
+	u, err := template.New().RenderHTTPGetURL(httpGet, obj)
+	if err != nil {
+		return "", corev1.ConditionFalse, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", corev1.ConditionFalse, err
+	}
+
+	c := r.HTTPClient
+	if c == nil {
+		c = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	// Failing to connect is expected while the application is starting
+	resp, err := c.Do(req)
+	if err != nil {
+		return err.Error(), corev1.ConditionFalse, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	if len(httpGet.ExpectedStatusCodes) == 0 && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return "", corev1.ConditionTrue, nil
+	}
+	for _, code := range httpGet.ExpectedStatusCodes {
+		if int(code) == resp.StatusCode {
+			return "", corev1.ConditionTrue, nil
+		}
+	}
+	return fmt.Sprintf("GET %s returned unexpected status: %s", u, resp.Status), corev1.ConditionFalse, nil
+}
+
 // unstructuredConditionStatus inspects unstructured contents for the status of a condition
 func (r *ReadinessChecker) unstructuredConditionStatus(obj *unstructured.Unstructured, conditionType string) (string, corev1.ConditionStatus, error) {
 	s, ok := obj.UnstructuredContent()["status"].(map[string]interface{})
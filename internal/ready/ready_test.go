@@ -18,9 +18,12 @@ package ready
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -234,7 +237,7 @@ func TestReadinessChecker_CheckConditions(t *testing.T) {
 			rc := &ReadinessChecker{Reader: fake.NewFakeClientWithScheme(scheme, c.objs...)}
 
 			// Verify the results
-			msg, ready, err := rc.CheckConditions(ctx, u, c.conditionTypes)
+			msg, ready, err := rc.CheckConditions(ctx, u, c.conditionTypes, nil)
 			assert.Equal(t, c.ready, ready)
 			assert.Equal(t, c.msg, msg)
 			if c.err != nil {
@@ -251,3 +254,62 @@ func TestReadinessChecker_CheckConditions(t *testing.T) {
 		})
 	}
 }
+
+func TestReadinessChecker_HTTPGet(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/myapp/healthz":
+			w.WriteHeader(http.StatusOK)
+		case "/myapp/starting":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		desc    string
+		httpGet *optimizev1beta2.HTTPGetCheck
+		ready   bool
+		err     bool
+	}{
+		{
+			desc:    "ok",
+			httpGet: &optimizev1beta2.HTTPGetCheck{URL: srv.URL + "/{{ .metadata.name }}/healthz"},
+			ready:   true,
+		},
+		{
+			desc:    "unavailable",
+			httpGet: &optimizev1beta2.HTTPGetCheck{URL: srv.URL + "/{{ .metadata.name }}/starting"},
+		},
+		{
+			desc:    "expected status",
+			httpGet: &optimizev1beta2.HTTPGetCheck{URL: srv.URL + "/missing", ExpectedStatusCodes: []int32{http.StatusNotFound}},
+			ready:   true,
+		},
+		{
+			desc:    "unexpected status",
+			httpGet: &optimizev1beta2.HTTPGetCheck{URL: srv.URL + "/{{ .metadata.name }}/healthz", ExpectedStatusCodes: []int32{http.StatusNoContent}},
+		},
+		{
+			desc: "missing configuration",
+			err:  true,
+		},
+	}
+
+	u := &unstructured.Unstructured{}
+	u.SetName("myapp")
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			rc := &ReadinessChecker{HTTPClient: srv.Client()}
+			msg, ready, err := rc.CheckConditions(context.TODO(), u, []string{ConditionTypeHTTPGet}, c.httpGet)
+			assert.Equal(t, c.ready, ready)
+			if c.err {
+				assert.Error(t, err)
+			} else if assert.NoError(t, err) && !c.ready {
+				assert.NotEmpty(t, msg)
+			}
+		})
+	}
+}
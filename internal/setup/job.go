@@ -60,8 +60,9 @@ func getImage(image string, pullPolicy corev1.PullPolicy) (string, corev1.PullPo
 	return Image, corev1.PullPolicy(ImagePullPolicy)
 }
 
-// NewJob returns a new setup job for either create or delete.
-func NewJob(t *optimizev1beta2.Trial, mode string) (*batchv1.Job, error) {
+// NewJob returns a new setup job for either create or delete. The optional experiment is used to describe the
+// parameters to Helm value templates.
+func NewJob(exp *optimizev1beta2.Experiment, t *optimizev1beta2.Trial, mode string) (*batchv1.Job, error) {
 	job := &batchv1.Job{}
 	job.Namespace = t.Namespace
 	job.Name = fmt.Sprintf("%s-%s", t.Name, mode)
@@ -132,7 +133,7 @@ func NewJob(t *optimizev1beta2.Trial, mode string) (*batchv1.Job, error) {
 		// For Helm installs, serialize a Konjure configuration
 		helmConfig := newHelmGeneratorConfig(&task)
 		if helmConfig != nil {
-			te := template.New()
+			te := template.New().WithExperiment(exp)
 
 			// Helm Values
 			for _, hv := range task.HelmValues {
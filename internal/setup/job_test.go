@@ -107,7 +107,7 @@ func TestNewJob(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(fmt.Sprintf("%q", tc.desc), func(t *testing.T) {
-			j, err := setup.NewJob(tc.trial, "create")
+			j, err := setup.NewJob(nil, tc.trial, "create")
 			assert.NoError(t, err)
 
 			if len(tc.trial.Spec.SetupTasks) == 0 {
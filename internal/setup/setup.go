@@ -36,6 +36,9 @@ const (
 	Initializer = "setupInitializer.stormforge.io"
 	// Finalizer is used to prevent the trial deletion for setup tasks.
 	Finalizer = "setupFinalizer.stormforge.io"
+
+	// MetricsTokenKey is the key of the bearer token in the trial metrics secret.
+	MetricsTokenKey = "token"
 )
 
 // UpdateStatus returns true if there are setup tasks.
@@ -166,6 +169,29 @@ func AppendPrometheusEnv(t *optimizev1beta2.Trial, env []corev1.EnvVar) []corev1
 	return env
 }
 
+// AppendMetricsEnv appends the URL and bearer token used to push metric values to the controller. The token is
+// referenced from the secret named on the trial so it is never stored in plain text on the job.
+func AppendMetricsEnv(t *optimizev1beta2.Trial, env []corev1.EnvVar) []corev1.EnvVar {
+	url := t.Annotations[optimizev1beta2.AnnotationMetricsURL]
+	secretName := t.Annotations[optimizev1beta2.AnnotationMetricsSecret]
+	if url == "" || secretName == "" {
+		return env
+	}
+
+	return append(env,
+		corev1.EnvVar{Name: "STORMFORGE_METRICS_URL", Value: url},
+		corev1.EnvVar{
+			Name: "STORMFORGE_METRICS_TOKEN",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+					Key:                  MetricsTokenKey,
+				},
+			},
+		},
+	)
+}
+
 // AppendStatusEnv appends the trial status as environment variables.
 func AppendStatusEnv(t *optimizev1beta2.Trial, env []corev1.EnvVar) []corev1.EnvVar {
 	for i := range t.Status.Conditions {
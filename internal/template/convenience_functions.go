@@ -19,6 +19,7 @@ package template
 import (
 	"fmt"
 	"math"
+	"strconv"
 )
 
 const (
@@ -47,3 +48,58 @@ func mib(query string) string {
 func kib(query string) string {
 	return fmt.Sprintf("%s/%.f", query, math.Pow(iByte, 1))
 }
+
+// clamp restricts a numeric value to the inclusive range [min, max]
+func clamp(min, max, value interface{}) (float64, error) {
+	lo, err := toFloat64(min)
+	if err != nil {
+		return 0, err
+	}
+	hi, err := toFloat64(max)
+	if err != nil {
+		return 0, err
+	}
+	v, err := toFloat64(value)
+	if err != nil {
+		return 0, err
+	}
+	return math.Min(math.Max(v, lo), hi), nil
+}
+
+// millicores formats a number of millicores as a CPU quantity (e.g. "500m")
+func millicores(value interface{}) (string, error) {
+	v, err := toFloat64(value)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%.fm", math.Round(v)), nil
+}
+
+// mebibytes formats a number of mebibytes as a memory quantity (e.g. "512Mi")
+func mebibytes(value interface{}) (string, error) {
+	v, err := toFloat64(value)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%.fMi", math.Round(v)), nil
+}
+
+// toFloat64 converts the numeric values produced by templates (e.g. parameter assignments or Sprig math) to a float
+func toFloat64(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("expected a number, got %T", value)
+	}
+}
@@ -50,6 +50,10 @@ func FuncMap() template.FuncMap {
 		"GiB":               gib,
 		"MiB":               mib,
 		"KiB":               kib,
+		"lookup":            lookup,
+		"clamp":             clamp,
+		"millicores":        millicores,
+		"mebibytes":         mebibytes,
 	}
 
 	for k, v := range extra {
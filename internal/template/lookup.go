@@ -0,0 +1,183 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"context"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// WithLookup returns a copy of the engine whose `lookup` function reads live objects using the supplied reader. A
+// nil reader leaves the default `lookup` function in place, which always returns an empty result.
+func (e *Engine) WithLookup(ctx context.Context, r client.Reader) *Engine {
+	if r == nil {
+		return e
+	}
+
+	ee := *e
+	ee.FuncMap = make(template.FuncMap, len(e.FuncMap))
+	for k, v := range e.FuncMap {
+		ee.FuncMap[k] = v
+	}
+	ee.FuncMap["lookup"] = newLookup(ctx, r)
+	return &ee
+}
+
+// lookup is used when no client is available (e.g. rendering offline), it behaves as if no objects exist
+func lookup(apiVersion, kind, namespace, name string) (map[string]interface{}, error) {
+	return map[string]interface{}{}, nil
+}
+
+// newLookup returns a read-only function for fetching objects from the cluster. When a name is specified a single
+// object is returned (or an empty map if it does not exist), otherwise a list of all the objects of the specified
+// kind in the namespace is returned.
+func newLookup(ctx context.Context, r client.Reader) func(string, string, string, string) (map[string]interface{}, error) {
+	return func(apiVersion, kind, namespace, name string) (map[string]interface{}, error) {
+		gvk := schema.FromAPIVersionAndKind(apiVersion, kind)
+
+		if name != "" {
+			u := &unstructured.Unstructured{}
+			u.SetGroupVersionKind(gvk)
+			if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, u); err != nil {
+				if apierrors.IsNotFound(err) {
+					return map[string]interface{}{}, nil
+				}
+				return nil, err
+			}
+			return u.UnstructuredContent(), nil
+		}
+
+		ul := &unstructured.UnstructuredList{}
+		ul.SetGroupVersionKind(gvk.GroupVersion().WithKind(kind + "List"))
+		if err := r.List(ctx, ul, client.InNamespace(namespace)); err != nil {
+			return nil, err
+		}
+		return ul.UnstructuredContent(), nil
+	}
+}
+
+// LookupReference is a reference to the objects read by a call to the `lookup` function. Arguments which are computed
+// when the template is rendered are left empty on the object reference and flagged as dynamic.
+type LookupReference struct {
+	corev1.ObjectReference
+	// DynamicType is true if the API version or kind is computed, the objects being read cannot be determined.
+	DynamicType bool
+	// DynamicNamespace is true if the namespace is computed.
+	DynamicNamespace bool
+	// DynamicName is true if the name is computed, any object of the kind may be read.
+	DynamicName bool
+}
+
+// LookupReferences returns references to the objects read by calls to the `lookup` function in the supplied template
+// text. A reference without a name (that is not dynamic) indicates the call lists objects.
+func LookupReferences(text string) ([]LookupReference, error) {
+	trees, err := parse.Parse("lookup", text, "", "", FuncMap())
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []LookupReference
+	for _, t := range trees {
+		walkLookups(t.Root, &refs)
+	}
+	return refs, nil
+}
+
+// walkLookups recursively visits the template nodes looking for `lookup` commands
+func walkLookups(node parse.Node, refs *[]LookupReference) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			walkLookups(c, refs)
+		}
+	case *parse.ActionNode:
+		walkLookups(n.Pipe, refs)
+	case *parse.IfNode:
+		walkLookups(n.Pipe, refs)
+		walkLookups(n.List, refs)
+		walkLookups(n.ElseList, refs)
+	case *parse.RangeNode:
+		walkLookups(n.Pipe, refs)
+		walkLookups(n.List, refs)
+		walkLookups(n.ElseList, refs)
+	case *parse.WithNode:
+		walkLookups(n.Pipe, refs)
+		walkLookups(n.List, refs)
+		walkLookups(n.ElseList, refs)
+	case *parse.TemplateNode:
+		walkLookups(n.Pipe, refs)
+	case *parse.ChainNode:
+		walkLookups(n.Node, refs)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			walkLookups(c, refs)
+		}
+	case *parse.CommandNode:
+		if ref, ok := lookupReference(n); ok {
+			*refs = append(*refs, ref)
+		}
+		for _, a := range n.Args {
+			walkLookups(a, refs)
+		}
+	}
+}
+
+// lookupReference converts a `lookup` command into a reference, non-literal arguments are flagged as dynamic
+func lookupReference(n *parse.CommandNode) (LookupReference, bool) {
+	if len(n.Args) != 5 {
+		return LookupReference{}, false
+	}
+	if id, ok := n.Args[0].(*parse.IdentifierNode); !ok || id.Ident != "lookup" {
+		return LookupReference{}, false
+	}
+
+	args := make([]string, 4)
+	dynamic := make([]bool, 4)
+	for i, a := range n.Args[1:] {
+		if s, ok := a.(*parse.StringNode); ok {
+			args[i] = strings.TrimSpace(s.Text)
+		} else {
+			dynamic[i] = true
+		}
+	}
+
+	return LookupReference{
+		ObjectReference: corev1.ObjectReference{
+			APIVersion: args[0],
+			Kind:       args[1],
+			Namespace:  args[2],
+			Name:       args[3],
+		},
+		DynamicType:      dynamic[0] || dynamic[1],
+		DynamicNamespace: dynamic[2],
+		DynamicName:      dynamic[3],
+	}, true
+}
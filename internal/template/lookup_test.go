@@ -0,0 +1,95 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestEngine_WithLookup(t *testing.T) {
+	cl := fake.NewFakeClientWithScheme(scheme.Scheme,
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "limits", Namespace: "default"},
+			Data:       map[string]string{"memory": "2Gi"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"},
+		},
+	)
+
+	trial := &optimizev1beta2.Trial{ObjectMeta: metav1.ObjectMeta{Name: "my-trial", Namespace: "default"}}
+
+	cases := []struct {
+		desc     string
+		query    string
+		expected string
+	}{
+		{
+			desc:     "get",
+			query:    `{{ (lookup "v1" "ConfigMap" .Trial.Namespace "limits").data.memory }}`,
+			expected: "2Gi",
+		},
+		{
+			desc:     "not found",
+			query:    `{{ lookup "v1" "ConfigMap" "default" "missing" | len }}`,
+			expected: "0",
+		},
+		{
+			desc:     "list",
+			query:    `{{ range (lookup "v1" "ConfigMap" "default" "").items }}{{ .metadata.name }} {{ end }}`,
+			expected: "limits other ",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			q, _, err := New().WithLookup(context.TODO(), cl).RenderMetricQueries(&optimizev1beta2.Metric{Query: c.query}, trial, nil)
+			if assert.NoError(t, err) {
+				assert.Equal(t, c.expected, q)
+			}
+		})
+	}
+
+	// Without a reader nothing is found
+	q, _, err := New().WithLookup(context.TODO(), nil).RenderMetricQueries(&optimizev1beta2.Metric{Query: cases[1].query}, trial, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "0", q)
+}
+
+func TestLookupReferences(t *testing.T) {
+	refs, err := LookupReferences(`
+{{ $cm := lookup "v1" "ConfigMap" .Trial.Namespace "limits" }}
+{{ range (lookup "apps/v1" "Deployment" "default" "").items }}{{ .metadata.name }}{{ end }}
+{{ if true }}{{ lookup "v1" "Secret" "default" .Values.name }}{{ end }}
+{{ lookup "v1" .Values.kind "default" "foo" }}
+`)
+	require.NoError(t, err)
+	assert.Equal(t, []LookupReference{
+		{ObjectReference: corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Name: "limits"}, DynamicNamespace: true},
+		{ObjectReference: corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default"}},
+		{ObjectReference: corev1.ObjectReference{APIVersion: "v1", Kind: "Secret", Namespace: "default"}, DynamicName: true},
+		{ObjectReference: corev1.ObjectReference{APIVersion: "v1", Namespace: "default", Name: "foo"}, DynamicType: true},
+	}, refs)
+}
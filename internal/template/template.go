@@ -25,6 +25,7 @@ import (
 
 	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/yaml"
@@ -36,6 +37,22 @@ type PatchData struct {
 	Trial metav1.ObjectMeta
 	// Trial assignments
 	Values map[string]interface{}
+	// Experiment metadata (only available if the experiment is known)
+	Experiment metav1.ObjectMeta
+	// Experiment parameter definitions (only available if the experiment is known)
+	Parameters map[string]ParameterData
+}
+
+// ParameterData represents an experiment parameter definition during patch evaluation
+type ParameterData struct {
+	// The inclusive minimum value of the parameter
+	Min int32
+	// The inclusive maximum value of the parameter
+	Max int32
+	// The baseline value of the parameter, nil if there is no baseline
+	Baseline interface{}
+	// The discrete allowed values of the parameter
+	Values []string
 }
 
 // MetricData represents a trial during metric evaluation
@@ -61,23 +78,44 @@ func (m *MetricData) Pods() runtime.Object {
 	return m.Target
 }
 
-func newPatchData(t *optimizev1beta2.Trial) *PatchData {
+func newPatchData(t *optimizev1beta2.Trial, exp *optimizev1beta2.Experiment) *PatchData {
 	d := &PatchData{}
 
 	t.ObjectMeta.DeepCopyInto(&d.Trial)
 
 	d.Values = make(map[string]interface{}, len(t.Spec.Assignments))
 	for _, a := range t.Spec.Assignments {
-		if a.Value.Type == intstr.String {
-			d.Values[a.Name] = a.Value.StrVal
-		} else {
-			d.Values[a.Name] = a.Value.IntVal
+		d.Values[a.Name] = intOrStringValue(a.Value)
+	}
+
+	if exp != nil {
+		exp.ObjectMeta.DeepCopyInto(&d.Experiment)
+
+		d.Parameters = make(map[string]ParameterData, len(exp.Spec.Parameters))
+		for _, p := range exp.Spec.Parameters {
+			pd := ParameterData{
+				Min:    p.Min,
+				Max:    p.Max,
+				Values: append([]string(nil), p.Values...),
+			}
+			if p.Baseline != nil {
+				pd.Baseline = intOrStringValue(*p.Baseline)
+			}
+			d.Parameters[p.Name] = pd
 		}
 	}
 
 	return d
 }
 
+// intOrStringValue returns the string or integer value for use in a template
+func intOrStringValue(v intstr.IntOrString) interface{} {
+	if v.Type == intstr.String {
+		return v.StrVal
+	}
+	return v.IntVal
+}
+
 func newMetricData(t *optimizev1beta2.Trial, target runtime.Object) *MetricData {
 	d := &MetricData{
 		Trial:  t.DeepCopy(),
@@ -86,11 +124,7 @@ func newMetricData(t *optimizev1beta2.Trial, target runtime.Object) *MetricData
 
 	d.Values = make(map[string]interface{}, len(t.Spec.Assignments))
 	for _, a := range t.Spec.Assignments {
-		if a.Value.Type == intstr.String {
-			d.Values[a.Name] = a.Value.StrVal
-		} else {
-			d.Values[a.Name] = a.Value.IntVal
-		}
+		d.Values[a.Name] = intOrStringValue(a.Value)
 	}
 
 	if t.Status.StartTime != nil {
@@ -109,6 +143,9 @@ func newMetricData(t *optimizev1beta2.Trial, target runtime.Object) *MetricData
 // Engine is used to render Go text templates
 type Engine struct {
 	FuncMap template.FuncMap
+
+	// The experiment used to describe parameters to patch templates
+	experiment *optimizev1beta2.Experiment
 }
 
 // New creates a new template engine
@@ -118,13 +155,21 @@ func New() *Engine {
 	}
 }
 
+// WithExperiment returns a copy of the engine which exposes the experiment metadata and parameter definitions to
+// patch and Helm value templates.
+func (e *Engine) WithExperiment(exp *optimizev1beta2.Experiment) *Engine {
+	ee := *e
+	ee.experiment = exp
+	return &ee
+}
+
 // TODO Investigate better use of template names
 // Would it be possible to have the template engine hold more scope? e.g. create the template engine using the full list
 // of patch templates or metrics (or the experiment itself, trial for HelmValues) and then render the individual values by template name?
 
 // RenderPatch returns the JSON representation of the supplied patch template (input can be a Go template that produces YAML)
 func (e *Engine) RenderPatch(patch *optimizev1beta2.PatchTemplate, trial *optimizev1beta2.Trial) ([]byte, error) {
-	data := newPatchData(trial)
+	data := newPatchData(trial, e.experiment)
 	b, err := e.render("patch", patch.Patch, data) // TODO What should we use for patch template names? Something from the targetRef?
 	if err != nil {
 		return nil, err
@@ -134,7 +179,7 @@ func (e *Engine) RenderPatch(patch *optimizev1beta2.PatchTemplate, trial *optimi
 
 // RenderHelmValue returns a rendered string of the supplied Helm value
 func (e *Engine) RenderHelmValue(helmValue *optimizev1beta2.HelmValue, trial *optimizev1beta2.Trial) (string, error) {
-	data := newPatchData(trial)
+	data := newPatchData(trial, e.experiment)
 	b, err := e.render(helmValue.Name, helmValue.Value.String(), data)
 	if err != nil {
 		return "", err
@@ -156,6 +201,38 @@ func (e *Engine) RenderMetricQueries(metric *optimizev1beta2.Metric, trial *opti
 	return b1.String(), b2.String(), nil
 }
 
+// RenderMetricRequest returns a copy of the metric HTTP request with the header values and body rendered
+func (e *Engine) RenderMetricRequest(metric *optimizev1beta2.Metric, trial *optimizev1beta2.Trial, target runtime.Object) (*optimizev1beta2.MetricHTTPRequest, error) {
+	if metric.HTTP == nil {
+		return nil, nil
+	}
+
+	data := newMetricData(trial, target)
+	req := metric.HTTP.DeepCopy()
+	for i := range req.Headers {
+		b, err := e.render(metric.Name, req.Headers[i].Value, data)
+		if err != nil {
+			return nil, err
+		}
+		req.Headers[i].Value = b.String()
+	}
+	b, err := e.render(metric.Name, req.Body, data)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = b.String()
+	return req, nil
+}
+
+// RenderHTTPGetURL returns the URL of an HTTP readiness check rendered using the content of the readiness target
+func (e *Engine) RenderHTTPGetURL(check *optimizev1beta2.HTTPGetCheck, target *unstructured.Unstructured) (string, error) {
+	b, err := e.render("httpGet", check.URL, target.UnstructuredContent())
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
 func (e *Engine) render(name, text string, data interface{}) (*bytes.Buffer, error) {
 	tmpl, err := template.New(name).Funcs(e.FuncMap).Parse(text)
 	if err != nil {
@@ -17,6 +17,7 @@ limitations under the License.
 package template
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -30,7 +31,16 @@ import (
 )
 
 func TestEngine_RenderPatch(t *testing.T) {
-	eng := New()
+	eng := New().WithExperiment(&optimizev1beta2.Experiment{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-exp"},
+		Spec: optimizev1beta2.ExperimentSpec{
+			Parameters: []optimizev1beta2.Parameter{
+				{Name: "cpu", Min: 100, Max: 2000},
+				{Name: "ratio", Min: 1, Max: 4},
+				{Name: "memory", Min: 128, Max: 4096, Baseline: &intstr.IntOrString{IntVal: 512}},
+			},
+		},
+	})
 
 	cases := []struct {
 		desc          string
@@ -69,6 +79,32 @@ func TestEngine_RenderPatch(t *testing.T) {
 			},
 			expected: []byte(`{"spec":{"replicas":2}}`),
 		},
+
+		{
+			desc: "parameter metadata",
+			patchTemplate: optimizev1beta2.PatchTemplate{
+				Patch: `metadata:
+  labels:
+    experiment: {{ .Experiment.Name }}
+spec:
+  resources:
+    requests:
+      cpu: {{ .Values.cpu | millicores }}
+      memory: {{ .Parameters.memory.Baseline | mebibytes }}
+    limits:
+      cpu: {{ mul .Values.cpu .Values.ratio | clamp 0 .Parameters.cpu.Max | millicores }}
+`,
+			},
+			trial: optimizev1beta2.Trial{
+				Spec: optimizev1beta2.TrialSpec{
+					Assignments: []optimizev1beta2.Assignment{
+						{Name: "cpu", Value: intstr.FromInt(750)},
+						{Name: "ratio", Value: intstr.FromInt(3)},
+					},
+				},
+			},
+			expected: []byte(`{"metadata":{"labels":{"experiment":"my-exp"}},"spec":{"resources":{"limits":{"cpu":"2000m"},"requests":{"cpu":"750m","memory":"512Mi"}}}}`),
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.desc, func(t *testing.T) {
@@ -377,6 +413,45 @@ func TestEngine_RenderMetricQueriesFailures(t *testing.T) {
 	}
 }
 
+func TestEngine_RenderMetricRequest(t *testing.T) {
+	eng := New()
+	now := metav1.Now()
+
+	metric := &optimizev1beta2.Metric{
+		Name: "testMetric",
+		HTTP: &optimizev1beta2.MetricHTTPRequest{
+			Method: "POST",
+			Headers: []optimizev1beta2.MetricHTTPHeader{
+				{Name: "X-Trial", Value: "{{ .Trial.Name }}"},
+			},
+			Body: `{"start":{{ .StartTime.Unix }},"end":{{ .CompletionTime.Unix }},"replicas":{{ .Values.replicas }}}`,
+		},
+	}
+	trial := &optimizev1beta2.Trial{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-trial"},
+		Spec: optimizev1beta2.TrialSpec{
+			Assignments: []optimizev1beta2.Assignment{{Name: "replicas", Value: intstr.FromInt(3)}},
+		},
+		Status: optimizev1beta2.TrialStatus{
+			StartTime:      &metav1.Time{Time: now.Add(-5 * time.Second)},
+			CompletionTime: &now,
+		},
+	}
+
+	req, err := eng.RenderMetricRequest(metric, trial, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "POST", req.Method)
+		assert.Equal(t, []optimizev1beta2.MetricHTTPHeader{{Name: "X-Trial", Value: "my-trial"}}, req.Headers)
+		assert.Equal(t, fmt.Sprintf(`{"start":%d,"end":%d,"replicas":3}`, now.Add(-5*time.Second).Unix(), now.Unix()), req.Body)
+		assert.Equal(t, "{{ .Trial.Name }}", metric.HTTP.Headers[0].Value, "metric should not be modified")
+	}
+
+	req, err = eng.RenderMetricRequest(&optimizev1beta2.Metric{Name: "testMetric"}, trial, nil)
+	if assert.NoError(t, err) {
+		assert.Nil(t, req)
+	}
+}
+
 var (
 	expectedCPUUtilizationQueryWithParams = `
 scalar(
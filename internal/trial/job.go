@@ -78,6 +78,7 @@ func NewJob(t *optimizev1beta2.Trial) *batchv1.Job {
 		c := &job.Spec.Template.Spec.Containers[i]
 		c.Env = setup.AppendAssignmentEnv(t, c.Env)
 		c.Env = setup.AppendPrometheusEnv(t, c.Env)
+		c.Env = setup.AppendMetricsEnv(t, c.Env)
 	}
 
 	// Containers cannot be empty, inject a sleep by default
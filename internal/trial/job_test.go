@@ -99,3 +99,38 @@ func TestNewJob(t *testing.T) {
 		})
 	}
 }
+
+func TestNewJobMetricsEnv(t *testing.T) {
+	tt := &optimizev1beta2.Trial{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-trial",
+			Namespace: "default",
+			Annotations: map[string]string{
+				optimizev1beta2.AnnotationMetricsURL:    "http://controller:8090/namespaces/default/trials/my-trial/metrics",
+				optimizev1beta2.AnnotationMetricsSecret: "my-trial-metrics",
+			},
+		},
+		Spec: optimizev1beta2.TrialSpec{
+			JobTemplate: &batchv1beta1.JobTemplateSpec{
+				Spec: batchv1.JobSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{{Name: "load-test", Image: "busybox"}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	job := NewJob(tt)
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "STORMFORGE_METRICS_URL", Value: "http://controller:8090/namespaces/default/trials/my-trial/metrics"},
+		{Name: "STORMFORGE_METRICS_TOKEN", ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "my-trial-metrics"},
+				Key:                  "token",
+			},
+		}},
+	}, job.Spec.Template.Spec.Containers[0].Env)
+}
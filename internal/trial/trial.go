@@ -43,7 +43,7 @@ func IsAbandoned(t *optimizev1beta2.Trial) bool {
 	return !IsFinished(t) && !t.GetDeletionTimestamp().IsZero()
 }
 
-// IsActive checks to see if the specified trial and any setup delete tasks are NOT finished
+// IsActive checks to see if the specified trial, any setup delete tasks and any patch restoration are NOT finished
 func IsActive(t *optimizev1beta2.Trial) bool {
 	// Not finished, definitely active
 	if !IsFinished(t) {
@@ -51,8 +51,9 @@ func IsActive(t *optimizev1beta2.Trial) bool {
 	}
 
 	// Check if a setup delete task exists and has not yet completed (remember the TrialSetupDeleted status is optional!)
+	// or if patched objects have not yet been restored (the TrialRestored status is also optional)
 	for _, c := range t.Status.Conditions {
-		if c.Type == optimizev1beta2.TrialSetupDeleted && c.Status != corev1.ConditionTrue {
+		if (c.Type == optimizev1beta2.TrialSetupDeleted || c.Type == optimizev1beta2.TrialRestored) && c.Status != corev1.ConditionTrue {
 			return true
 		}
 	}
@@ -123,6 +124,46 @@ func IsBaseline(t *optimizev1beta2.Trial, exp *optimizev1beta2.Experiment) bool
 	return true
 }
 
+// PendingPatchWave returns the lowest wave with patch operations that still have remaining attempts, returns false if
+// there are no patch operations left to apply
+func PendingPatchWave(t *optimizev1beta2.Trial) (int32, bool) {
+	var wave int32
+	var pending bool
+	for i := range t.Status.PatchOperations {
+		p := &t.Status.PatchOperations[i]
+		if p.AttemptsRemaining > 0 && (!pending || p.Wave < wave) {
+			wave, pending = p.Wave, true
+		}
+	}
+	return wave, pending
+}
+
+// IsWaveReady checks to see if the readiness checks for all of the waves lower than the specified wave have passed
+func IsWaveReady(t *optimizev1beta2.Trial, wave int32) bool {
+	for i := range t.Status.ReadinessChecks {
+		c := &t.Status.ReadinessChecks[i]
+		if c.Wave < wave && c.AttemptsRemaining > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// WaveAppliedTimes returns the latest time a patch was applied for each wave.
+func WaveAppliedTimes(t *optimizev1beta2.Trial) map[int32]metav1.Time {
+	result := make(map[int32]metav1.Time)
+	for i := range t.Status.PatchOperations {
+		p := &t.Status.PatchOperations[i]
+		if p.AppliedTime == nil {
+			continue
+		}
+		if at, ok := result[p.Wave]; !ok || at.Before(p.AppliedTime) {
+			result[p.Wave] = *p.AppliedTime
+		}
+	}
+	return result
+}
+
 // NeedsCleanup checks to see if a trial's TTL has expired
 func NeedsCleanup(t *optimizev1beta2.Trial) bool {
 	// Already deleted or still active, no cleanup necessary
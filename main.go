@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 
 	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
@@ -30,6 +31,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
@@ -43,6 +45,7 @@ func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 
 	_ = optimizev1beta2.AddToScheme(scheme)
+	_ = metricsv1beta1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -51,8 +54,11 @@ func main() {
 	handleDebugArgs()
 
 	var metricsAddr string
+	var pushAddr, pushURL string
 	var enableLeaderElection bool
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&pushAddr, "push-addr", ":8090", "The address the trial metric push endpoint binds to (plain HTTP), empty to disable.")
+	flag.StringVar(&pushURL, "push-url", "", "The URL trial jobs use to reach the metric push endpoint.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
 	flag.Parse()
@@ -68,6 +74,10 @@ func main() {
 	v := version.GetInfo()
 	setupLog.Info("StormForge Optimize Controller", "version", v.String(), "gitCommit", v.GitCommit)
 
+	if pushURL == "" {
+		pushURL = defaultPushURL(pushAddr)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: metricsAddr,
@@ -118,9 +128,10 @@ func main() {
 		os.Exit(1)
 	}
 	if err = (&controllers.TrialJobReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Trial"),
-		Scheme: mgr.GetScheme(),
+		Client:  mgr.GetClient(),
+		Log:     ctrl.Log.WithName("controllers").WithName("Trial"),
+		Scheme:  mgr.GetScheme(),
+		PushURL: pushURL,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Trial")
 		os.Exit(1)
@@ -144,6 +155,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	// The metric push endpoint accepts values from trial jobs
+	if err = (&controllers.MetricPushServer{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("MetricPush"),
+		Addr:   pushAddr,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "MetricPush")
+		os.Exit(1)
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
@@ -151,6 +172,22 @@ func main() {
 	}
 }
 
+// defaultPushURL returns the in-cluster URL of the metric push endpoint using the service name and namespace exposed
+// to the controller through its environment
+func defaultPushURL(pushAddr string) string {
+	name, ns := os.Getenv("PUSH_SERVICE_NAME"), os.Getenv("POD_NAMESPACE")
+	if pushAddr == "" || name == "" || ns == "" {
+		return ""
+	}
+
+	_, port, err := net.SplitHostPort(pushAddr)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("http://%s.%s.svc:%s", name, ns, port)
+}
+
 // handleDebugArgs will make the process dump and exit if the first arg is either "version" or "config"
 func handleDebugArgs() {
 	if len(os.Args) > 1 {
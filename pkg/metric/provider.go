@@ -0,0 +1,114 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metric is used to add metric types to the controller. Providers are compiled in by registering them from
+// the `init` function of a package that is imported by the controller (and CLI, for validation).
+package metric
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Provider is used to collect the values for a single metric type.
+type Provider interface {
+	// ApplyDefaults fills in type specific default values for a metric being collected for the supplied trial.
+	ApplyDefaults(t *optimizev1beta2.Trial, m *optimizev1beta2.Metric) error
+	// Validate checks a metric definition (with rendered queries) for problems, including optional fields which are
+	// not supported by the provider. Problems which do not prevent the metric from being captured should be reported
+	// as a `Warning`.
+	Validate(m *optimizev1beta2.Metric) []error
+	// Capture returns the value and error of a metric whose queries have already been rendered. The optional secret
+	// contains the credentials referenced by the metric.
+	Capture(ctx context.Context, log logr.Logger, t *optimizev1beta2.Trial, m *optimizev1beta2.Metric, secret *corev1.Secret) (float64, float64, error)
+}
+
+// Warning is a problem reported during validation that does not prevent a metric from being captured.
+type Warning string
+
+func (w Warning) Error() string {
+	return string(w)
+}
+
+// CaptureError describes problems that arise while capturing metric values. Providers should return a capture error
+// with a retry delay when the value is not available yet.
+type CaptureError struct {
+	// A description of what went wrong
+	Message string
+	// The URL that was used to capture the metric
+	Address string
+	// The metric query that failed
+	Query string
+	// The minimum amount of time until the metric is expected to be available
+	RetryAfter time.Duration
+}
+
+func (e *CaptureError) Error() string {
+	return e.Message
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[optimizev1beta2.MetricType]Provider)
+)
+
+// Register makes a metric provider available for the supplied metric type. Register panics if it is called twice for
+// the same metric type.
+func Register(metricType optimizev1beta2.MetricType, p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if p == nil {
+		panic("metric: Register provider is nil")
+	}
+	if _, dup := providers[metricType]; dup {
+		panic(fmt.Sprintf("metric: Register called twice for provider %s", metricType))
+	}
+	providers[metricType] = p
+}
+
+// Lookup returns the provider registered for the supplied metric type. An empty type is treated as "kubernetes".
+func Lookup(metricType optimizev1beta2.MetricType) (Provider, bool) {
+	if metricType == "" {
+		metricType = optimizev1beta2.MetricKubernetes
+	}
+
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	p, ok := providers[metricType]
+	return p, ok
+}
+
+// Types returns a sorted list of the registered metric types.
+func Types() []optimizev1beta2.MetricType {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	types := make([]optimizev1beta2.MetricType, 0, len(providers))
+	for t := range providers {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
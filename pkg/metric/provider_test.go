@@ -0,0 +1,104 @@
+/*
+Copyright 2022 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric_test
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	optimizev1beta2 "github.com/thestormforge/optimize-controller/v2/api/v1beta2"
+	internalmetric "github.com/thestormforge/optimize-controller/v2/internal/metric"
+	"github.com/thestormforge/optimize-controller/v2/pkg/metric"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// lengthProvider is an out-of-tree style provider that reports the length of the rendered query.
+type lengthProvider struct{}
+
+func (p *lengthProvider) ApplyDefaults(t *optimizev1beta2.Trial, m *optimizev1beta2.Metric) error {
+	if m.URL == "" {
+		m.URL = "length://" + t.Namespace
+	}
+	return nil
+}
+
+func (p *lengthProvider) Validate(m *optimizev1beta2.Metric) []error {
+	if strings.TrimSpace(m.Query) == "" {
+		return []error{metric.Warning("empty query")}
+	}
+	return nil
+}
+
+func (p *lengthProvider) Capture(_ context.Context, _ logr.Logger, _ *optimizev1beta2.Trial, m *optimizev1beta2.Metric, _ *corev1.Secret) (float64, float64, error) {
+	return float64(len(m.Query)), math.NaN(), nil
+}
+
+func TestProviderRegistry(t *testing.T) {
+	const lengthType optimizev1beta2.MetricType = "test-length"
+	metric.Register(lengthType, &lengthProvider{})
+	t.Cleanup(func() { metric.Unregister(lengthType) })
+
+	// Built-in types are registered by the controller packages
+	assert.Subset(t, metric.Types(), []optimizev1beta2.MetricType{
+		optimizev1beta2.MetricKubernetes,
+		optimizev1beta2.MetricPrometheus,
+		optimizev1beta2.MetricDatadog,
+		optimizev1beta2.MetricJSONPath,
+		optimizev1beta2.MetricNewRelic,
+		lengthType,
+	})
+
+	// The empty type is the same as Kubernetes
+	p, ok := metric.Lookup("")
+	if assert.True(t, ok) {
+		kp, _ := metric.Lookup(optimizev1beta2.MetricKubernetes)
+		assert.Equal(t, kp, p)
+	}
+
+	_, ok = metric.Lookup("unknown")
+	assert.False(t, ok)
+
+	// Duplicate registrations are not allowed
+	assert.Panics(t, func() { metric.Register(lengthType, &lengthProvider{}) })
+
+	// Defaults are applied by type
+	trial := &optimizev1beta2.Trial{ObjectMeta: metav1.ObjectMeta{Namespace: "default"}}
+	m := &optimizev1beta2.Metric{Name: "testMetric", Type: lengthType, Query: "{{ .Trial.Namespace }}"}
+	if assert.NoError(t, internalmetric.ApplyDefaults(trial, m)) {
+		assert.Equal(t, "length://default", m.URL)
+	}
+	assert.Error(t, internalmetric.ApplyDefaults(trial, &optimizev1beta2.Metric{Type: "unknown"}))
+
+	// Capture is delegated after the query is rendered
+	now := metav1.Now()
+	later := metav1.NewTime(now.Add(5 * time.Second))
+	trial.Status.StartTime, trial.Status.CompletionTime = &now, &later
+	value, _, err := internalmetric.CaptureMetric(context.TODO(), zap.New(), nil, trial, m, nil, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, float64(len("default")), value)
+	}
+
+	_, _, err = internalmetric.CaptureMetric(context.TODO(), zap.New(), nil, trial, &optimizev1beta2.Metric{Type: "unknown"}, nil, nil)
+	assert.EqualError(t, err, "unknown metric type: unknown")
+}